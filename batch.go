@@ -0,0 +1,199 @@
+package spellbook
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// KeyAtomic is the input requesting a batch to be applied all-or-nothing
+const KeyAtomic = "atomic"
+
+// BatchHandler is implemented by RestHandlers that can process batch requests.
+// Batch requests are served by RestControllers with the Batch flag set
+type BatchHandler interface {
+	HandleBatch(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse
+}
+
+// Transactional is implemented by managers that can run a set of operations in a transaction.
+// The manager must use the context passed to fn to join the transaction,
+// and nested calls must join the transaction in progress
+type Transactional interface {
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type BatchOperationType string
+
+const (
+	BatchCreate BatchOperationType = "create"
+	BatchUpdate BatchOperationType = "update"
+	BatchDelete BatchOperationType = "delete"
+)
+
+// BatchOperation is a single operation of a batch request.
+// Id is required by updates and deletes, Bundle by creates and updates
+type BatchOperation struct {
+	Op     BatchOperationType `json:"op"`
+	Id     string             `json:"id,omitempty"`
+	Bundle json.RawMessage    `json:"bundle,omitempty"`
+}
+
+// BatchResult is the outcome of a single operation of a batch request
+type BatchResult struct {
+	Index  int                `json:"index"`
+	Op     BatchOperationType `json:"op"`
+	Id     string             `json:"id,omitempty"`
+	Status int                `json:"status"`
+	Item   interface{}        `json:"item,omitempty"`
+	Error  *Problem           `json:"error,omitempty"`
+}
+
+var errBatchFailed = errors.New("batch operation failed")
+
+// Handles POST requests on batch controllers.
+// The body is an array of operations, which are run in order through the manager and the extenders.
+// With atomic=true the manager must implement Transactional and the batch stops at the first failure,
+// rolling back the operations already applied
+func (handler BaseRestHandler) HandleBatch(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	renderer := flamel.JSONRenderer{}
+	out.Renderer = &renderer
+
+	ins := InputsFromContext(ctx)
+	j, ok := ins[flamel.KeyRequestJSON]
+	if !ok {
+		return handler.ErrorToStatus(ctx, NewFieldError("", errMissingBody), out)
+	}
+
+	var ops []BatchOperation
+	if err := json.Unmarshal([]byte(j.Value()), &ops); err != nil {
+		return handler.ErrorToStatus(ctx, NewFieldError("", fmt.Errorf("bad json: %s", err.Error())), out)
+	}
+
+	if len(ops) == 0 {
+		return handler.ErrorToStatus(ctx, NewFieldError("", errors.New("empty batch")), out)
+	}
+
+	atomic := false
+	if ain, ok := ins[KeyAtomic]; ok {
+		a, err := strconv.ParseBool(ain.Value())
+		if err != nil {
+			return handler.ErrorToStatus(ctx, NewFieldError(KeyAtomic, errors.New("atomic must be a boolean")), out)
+		}
+		atomic = a
+	}
+
+	results := make([]BatchResult, len(ops))
+
+	if !atomic {
+		status := http.StatusOK
+		for i, op := range ops {
+			results[i] = handler.batchOperation(ctx, i, op)
+			if results[i].Error != nil {
+				status = http.StatusMultiStatus
+			}
+		}
+		renderer.Data = results
+		return flamel.HttpResponse{Status: status}
+	}
+
+	tm, ok := handler.Manager.(Transactional)
+	if !ok {
+		return handler.ErrorToStatus(ctx, NewFieldError(KeyAtomic, errors.New("the resource doesn't support atomic batches")), out)
+	}
+
+	failed := -1
+	err := tm.RunInTransaction(ctx, func(ctx context.Context) error {
+		for i, op := range ops {
+			results[i] = handler.batchOperation(ctx, i, op)
+			if results[i].Error != nil {
+				failed = i
+				return errBatchFailed
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		renderer.Data = results
+		return flamel.HttpResponse{Status: http.StatusOK}
+	}
+
+	if failed < 0 {
+		// the transaction itself failed
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	// nothing has been applied: report the failing operation and mark the others as not done
+	for i, op := range ops {
+		if i == failed {
+			continue
+		}
+		results[i] = BatchResult{Index: i, Op: op.Op, Id: op.Id, Status: http.StatusFailedDependency}
+	}
+	renderer.Data = results
+	return flamel.HttpResponse{Status: results[failed].Status}
+}
+
+// runs a single operation of a batch
+func (handler BaseRestHandler) batchOperation(ctx context.Context, index int, op BatchOperation) BatchResult {
+	result := BatchResult{Index: index, Op: op.Op, Id: op.Id}
+
+	resource, status, err := handler.applyOperation(ctx, op)
+	if err != nil {
+		problem := ProblemFromError(err)
+		result.Status = problem.Status
+		result.Error = &problem
+		return result
+	}
+
+	result.Status = status
+	if resource != nil {
+		result.Id = resource.Id()
+		if op.Op != BatchDelete {
+			result.Item = resource
+		}
+	}
+	return result
+}
+
+func (handler BaseRestHandler) applyOperation(ctx context.Context, op BatchOperation) (Resource, int, error) {
+	switch op.Op {
+	case BatchCreate:
+		resource, err := handler.Manager.NewResource(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if err := resource.FromRepresentation(RepresentationTypeJSON, op.Bundle); err != nil {
+			return nil, 0, NewFieldError("bundle", fmt.Errorf("bad json: %s", err.Error()))
+		}
+
+		if err := handler.create(ctx, resource, op.Bundle); err != nil {
+			return nil, 0, err
+		}
+		return resource, http.StatusCreated, nil
+	case BatchUpdate, BatchDelete:
+		if op.Id == "" {
+			return nil, 0, NewFieldError("id", fmt.Errorf("id is required by %s operations", op.Op))
+		}
+
+		resource, err := handler.Manager.FromId(ctx, op.Id)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if op.Op == BatchDelete {
+			return resource, http.StatusOK, handler.delete(ctx, resource, nil)
+		}
+
+		if len(op.Bundle) == 0 {
+			return nil, 0, NewFieldError("bundle", errors.New("bundle is required by update operations"))
+		}
+		return resource, http.StatusOK, handler.update(ctx, resource, op.Bundle, nil)
+	}
+	return nil, 0, NewFieldError("op", fmt.Errorf("unknown operation %q", op.Op))
+}
@@ -38,20 +38,27 @@ type Attachment struct {
 	Created      time.Time
 	Updated      time.Time
 	Uploader     string
+
+	// when the attachment was moved to the trash, see spellbook.TrashRepository.
+	// The datastore stores Deleted, sql databases DeletedAt, which gorm soft deletes with
+	Deleted   time.Time  `gorm:"-"`
+	DeletedAt *time.Time `model:"-"`
 }
 
-func (attachment *Attachment) setParentKey(key string) {
-	if key == AttachmentGlobalParent {
-		attachment.ParentID.Valid = false
-		attachment.ParentKey = key
+// sets the parent of the attachment.
+// Only the attachments of contents reference their parent by the sql foreign key,
+// the keys of the other parent types don't refer to the rows of the contents
+func (attachment *Attachment) setParent(parentType string, key string) {
+	attachment.ParentType = parentType
+	attachment.ParentKey = key
+	attachment.ParentID = sql.NullInt64{}
+
+	if parentType != AttachmentParentTypeContent || key == AttachmentGlobalParent {
 		return
 	}
-
 	if v, err := strconv.Atoi(key); err == nil {
-		attachment.ParentID.Int64 = int64(v)
-		attachment.ParentID.Valid = true
+		attachment.ParentID = sql.NullInt64{Int64: int64(v), Valid: true}
 	}
-	attachment.ParentKey = key
 }
 
 // returns the global key if there is no foreign key set
@@ -95,8 +102,7 @@ func (attachment *Attachment) UnmarshalJSON(data []byte) error {
 	attachment.ResourceThumbUrl = alias.ResourceThumbUrl
 	attachment.Group = alias.Group
 	attachment.Type = alias.Type
-	attachment.setParentKey(alias.ParentKey)
-	attachment.ParentType = alias.ParentType
+	attachment.setParent(alias.ParentType, alias.ParentKey)
 	attachment.Created = alias.Created
 	attachment.Updated = alias.Updated
 	attachment.Uploader = alias.Uploader
@@ -153,6 +159,11 @@ func (attachment *Attachment) Id() string {
 	return fmt.Sprintf("%d", attachment.ID)
 }
 
+// Version returns the last update time of the attachment, in microseconds
+func (attachment *Attachment) Version() string {
+	return strconv.FormatInt(attachment.Updated.UnixNano()/int64(time.Microsecond), 10)
+}
+
 func (attachment *Attachment) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
 	switch rtype {
 	case spellbook.RepresentationTypeJSON:
@@ -0,0 +1,37 @@
+package content
+
+import (
+	"database/sql"
+	"decodica.com/spellbook"
+	"testing"
+)
+
+func TestAttachmentParent(t *testing.T) {
+	tests := []struct {
+		name       string
+		parentType string
+		parentKey  string
+		parentID   int64
+	}{
+		{"content", AttachmentParentTypeContent, "12", 12},
+		{"global", "", AttachmentGlobalParent, 0},
+		{"content of a string key", AttachmentParentTypeContent, "agxzfnNwZWxsYm9vaw", 0},
+		{"other parent type", "event", "12", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attachment := &Attachment{ParentID: sql.NullInt64{Int64: 99, Valid: true}}
+			bundle := []byte(`{"name":"a","parentType":"` + test.parentType + `","parentKey":"` + test.parentKey + `"}`)
+			if err := attachment.FromRepresentation(spellbook.RepresentationTypeJSON, bundle); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if attachment.ParentID.Int64 != test.parentID || attachment.ParentID.Valid != (test.parentID != 0) {
+				t.Errorf("got parent id %+v, want %d", attachment.ParentID, test.parentID)
+			}
+			if attachment.ParentKey != test.parentKey || attachment.ParentType != test.parentType {
+				t.Errorf("got parent %s %s, want %s %s", attachment.ParentType, attachment.ParentKey, test.parentType, test.parentKey)
+			}
+		})
+	}
+}
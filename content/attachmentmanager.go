@@ -2,13 +2,12 @@ package content
 
 import (
 	"context"
-	"decodica.com/flamel/model"
 	"decodica.com/spellbook"
 	"decodica.com/spellbook/identity"
+	"decodica.com/spellbook/trash"
 	"errors"
 	"fmt"
 	"google.golang.org/appengine/log"
-	"reflect"
 	"sort"
 	"time"
 )
@@ -25,7 +24,58 @@ func NewAttachmentControllerWithKey(key string) *spellbook.RestController {
 	return c
 }
 
-type AttachmentManager struct{}
+// AttachmentManager handles the attachments stored in the repository, moving the deleted ones to the Trash,
+// where they are kept in place until they are restored or purged, see spellbook.TrashRepository.
+// The zero value stores them in the datastore, managers with a Repository and no Trash delete permanently
+type AttachmentManager struct {
+	Repository spellbook.Repository
+	Trash      spellbook.Repository
+}
+
+// trash item type of the attachments
+const TrashTypeAttachment = "attachment"
+
+func (manager AttachmentManager) attachments() spellbook.Repository {
+	if manager.Repository == nil {
+		return attachmentRepository{}
+	}
+	return manager.Repository
+}
+
+// attachments are moved to the trash only if their repository can keep them there
+func (manager AttachmentManager) trash() spellbook.Repository {
+	if _, ok := manager.attachments().(spellbook.TrashRepository); !ok {
+		return nil
+	}
+	if manager.Trash == nil && manager.Repository == nil {
+		return trash.DatastoreRepository{}
+	}
+	return manager.Trash
+}
+
+// fields the attachments can be filtered by
+var attachmentFilterFields = spellbook.FilterFields{
+	"Name":         spellbook.FieldString,
+	"Group":        spellbook.FieldString,
+	"Type":         spellbook.FieldString,
+	"ParentKey":    spellbook.FieldString,
+	"ParentType":   spellbook.FieldString,
+	"DisplayOrder": spellbook.FieldInt,
+	"Created":      spellbook.FieldTime,
+	"Updated":      spellbook.FieldTime,
+	"Uploader":     spellbook.FieldString,
+}
+
+// Describe describes the attachments for the OpenAPI document
+func (manager AttachmentManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "Attachment",
+		Filterable:       attachmentFilterFields,
+		Orderable:        []string{"DisplayOrder", "Name", "Created", "Updated"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadContent, spellbook.PermissionReadMedia},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteContent, spellbook.PermissionWriteMedia},
+	}
+}
 
 func (manager AttachmentManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &Attachment{}, nil
@@ -33,75 +83,47 @@ func (manager AttachmentManager) NewResource(ctx context.Context) (spellbook.Res
 
 func (manager AttachmentManager) FromId(ctx context.Context, strId string) (spellbook.Resource, error) {
 
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionReadContent) && !current.HasPermission(spellbook.PermissionReadMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionReadContent
-		if !current.HasPermission(spellbook.PermissionReadMedia) {
-			p = spellbook.PermissionReadMedia
-		}
-		return nil, spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionReadContent, spellbook.PermissionReadMedia); err != nil {
+		return nil, err
 	}
 
-	att := Attachment{}
-	if err := model.FromEncodedKey(ctx, &att, strId); err != nil {
+	att, err := manager.attachments().FromId(ctx, strId)
+	if err != nil {
 		log.Errorf(ctx, "could not retrieve attachment %s: %s", strId, err.Error())
 		return nil, err
 	}
 
-	return &att, nil
+	return att, nil
 }
 
 func (manager AttachmentManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionReadContent) && !current.HasPermission(spellbook.PermissionReadMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionReadContent
-		if !current.HasPermission(spellbook.PermissionReadMedia) {
-			p = spellbook.PermissionReadMedia
-		}
-		return nil, spellbook.NewPermissionError(spellbook.PermissionName(p))
-	}
-
-	var attachments []*Attachment
-	q := model.NewQuery(&Attachment{})
-	q = q.OffsetBy(opts.Page * opts.Size)
+	resources, _, err := manager.ListOfWithCursor(ctx, opts)
+	return resources, err
+}
 
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
+// ListOfWithCursor lists the attachments, paging them by cursor if one is provided
+func (manager AttachmentManager) ListOfWithCursor(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, string, error) {
+	if err := mediaPermission(ctx, spellbook.PermissionReadContent, spellbook.PermissionReadMedia); err != nil {
+		return nil, "", err
 	}
 
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
-	}
-
-	// get one more so we know if we are done
-	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &attachments)
+	query, cursor, err := spellbook.PageQuery(opts, &Attachment{})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	resources := make([]spellbook.Resource, len(attachments))
-	for i := range attachments {
-		resources[i] = attachments[i]
+	resources, err := manager.attachments().ListOf(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving attachments: %s", err.Error())
+		return nil, "", err
 	}
 
-	return resources, nil
+	return cursor.Page(resources, opts)
 }
 
 func (manager AttachmentManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionReadContent) && !current.HasPermission(spellbook.PermissionReadMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionReadContent
-		if !current.HasPermission(spellbook.PermissionReadMedia) {
-			p = spellbook.PermissionReadMedia
-		}
-		return nil, spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionReadContent, spellbook.PermissionReadMedia); err != nil {
+		return nil, err
 	}
 
 	a := []string{"Group", "ParentKey"} // list property accepted
@@ -114,50 +136,42 @@ func (manager AttachmentManager) ListOfProperties(ctx context.Context, opts spel
 		return nil, errors.New("no property found")
 	}
 
-	var conts []*Attachment
-	q := model.NewQuery(&Attachment{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	repository, ok := manager.attachments().(spellbook.DistinctRepository)
+	if !ok {
+		return nil, spellbook.NewUnsupportedError()
 	}
 
-	q = q.Distinct(name)
-	q = q.Limit(opts.Size + 1)
-	err := q.GetAll(ctx, &conts)
+	query := spellbook.Query{Filters: opts.Filters, Offset: opts.Page * opts.Size, Limit: opts.Size + 1}
+	result, err := repository.Distinct(ctx, name, query)
 	if err != nil {
 		log.Errorf(ctx, "Error retrieving result: %+v", err)
 		return nil, err
 	}
-	var result []string
-	for _, c := range conts {
-		value := reflect.ValueOf(c).Elem().FieldByName(name).String()
-		if len(value) > 0 {
-			result = append(result, value)
-		}
-	}
 	return result, nil
 }
 
+// returns a permission error if the identity of the context has neither the content nor the media permission.
+// The error names the media permission, the one specific to the attachments
+func mediaPermission(ctx context.Context, content spellbook.Permission, media spellbook.Permission) error {
+	if current := spellbook.IdentityFromContext(ctx); current != nil && (current.HasPermission(content) || current.HasPermission(media)) {
+		return nil
+	}
+	return spellbook.NewPermissionError(spellbook.PermissionName(media))
+}
+
+// Count returns the number of attachments matching the filters of the options
+func (manager AttachmentManager) Count(ctx context.Context, opts spellbook.ListOptions) (int, error) {
+	if err := mediaPermission(ctx, spellbook.PermissionReadContent, spellbook.PermissionReadMedia); err != nil {
+		return 0, err
+	}
+
+	return manager.attachments().Count(ctx, spellbook.QueryFromOptions(opts))
+}
+
 func (manager AttachmentManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 	current := spellbook.IdentityFromContext(ctx)
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionWriteContent) && !current.HasPermission(spellbook.PermissionWriteMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionWriteContent
-		if !current.HasPermission(spellbook.PermissionWriteMedia) {
-			p = spellbook.PermissionWriteMedia
-		}
-		return spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionWriteContent, spellbook.PermissionWriteMedia); err != nil {
+		return err
 	}
 
 	attachment := res.(*Attachment)
@@ -183,9 +197,11 @@ func (manager AttachmentManager) Create(ctx context.Context, res spellbook.Resou
 	}
 
 	attachment.Created = time.Now().UTC()
-	attachment.Uploader = current.(identity.User).Username()
+	if user, ok := current.(identity.User); ok {
+		attachment.Uploader = user.Username()
+	}
 
-	err := model.Create(ctx, attachment)
+	err := manager.attachments().Create(ctx, attachment)
 	if err != nil {
 		log.Errorf(ctx, "error creating attachment %s: %s", attachment.Name, err)
 		return err
@@ -195,13 +211,8 @@ func (manager AttachmentManager) Create(ctx context.Context, res spellbook.Resou
 }
 
 func (manager AttachmentManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionWriteContent) && !current.HasPermission(spellbook.PermissionWriteMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionWriteContent
-		if !current.HasPermission(spellbook.PermissionWriteMedia) {
-			p = spellbook.PermissionWriteMedia
-		}
-		return spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionWriteContent, spellbook.PermissionWriteMedia); err != nil {
+		return err
 	}
 
 	other := Attachment{}
@@ -226,7 +237,7 @@ func (manager AttachmentManager) Update(ctx context.Context, res spellbook.Resou
 		}
 	}
 
-	attachment.setParentKey(other.ParentKey)
+	attachment.setParent(other.ParentType, other.ParentKey)
 
 	if attachment.ParentKey == "" {
 		msg := fmt.Sprintf("attachment parent can't be empty. Use %s as a parent for global attachments", AttachmentGlobalParent)
@@ -238,28 +249,116 @@ func (manager AttachmentManager) Update(ctx context.Context, res spellbook.Resou
 		attachment.ResourceThumbUrl = attachment.ResourceUrl
 	}
 
-	attachment.Updated = time.Now().UTC()
+	attachment.Updated = updateTime()
 	attachment.AltText = other.AltText
 
-	return model.Update(ctx, attachment)
+	return manager.attachments().Update(ctx, attachment)
 }
 
 func (manager AttachmentManager) Delete(ctx context.Context, res spellbook.Resource) error {
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionWriteContent) && !current.HasPermission(spellbook.PermissionWriteMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionWriteContent
-		if !current.HasPermission(spellbook.PermissionWriteMedia) {
-			p = spellbook.PermissionWriteMedia
-		}
-		return spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionWriteContent, spellbook.PermissionWriteMedia); err != nil {
+		return err
 	}
 
 	attachment := res.(*Attachment)
-	err := model.Delete(ctx, attachment, nil)
+	if manager.trash() == nil {
+		if err := manager.attachments().Delete(ctx, attachment); err != nil {
+			log.Errorf(ctx, "error deleting attachment %s: %s", attachment.Name, err.Error())
+			return err
+		}
+		return nil
+	}
+
+	item, err := trash.NewItem(ctx, TrashTypeAttachment, attachment.Name, attachment)
+	if err != nil {
+		return err
+	}
+
+	return spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := manager.attachments().(spellbook.TrashRepository).Trash(ctx, attachment); err != nil {
+			log.Errorf(ctx, "error moving attachment %s to the trash: %s", attachment.Name, err.Error())
+			return err
+		}
+		if err := manager.trash().Create(ctx, item); err != nil {
+			log.Errorf(ctx, "error moving attachment %s to the trash: %s", attachment.Name, err.Error())
+			return err
+		}
+		return nil
+	}, manager.attachments(), manager.trash())
+}
+
+// returns the trashed attachment of the item, or a not found error if it is no longer in the trash
+func (manager AttachmentManager) trashed(ctx context.Context, item *trash.Item) (*Attachment, error) {
+	attachments, ok := manager.attachments().(spellbook.TrashRepository)
+	if !ok {
+		return nil, spellbook.ErrNotFound
+	}
+	res, err := attachments.Trashed(ctx, item.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*Attachment), nil
+}
+
+// Restore brings back a trashed attachment, with its id.
+// The parent of the attachment must exist: trashed parents are to be restored first
+func (manager AttachmentManager) Restore(ctx context.Context, item *trash.Item) error {
+	attachment, err := manager.trashed(ctx, item)
+	if spellbook.IsNotFound(err) {
+		return spellbook.NewFieldError("resourceId", fmt.Errorf("attachment %s is no longer in the trash", item.ResourceId))
+	}
+	if err != nil {
+		return err
+	}
+
+	if sa := SupportedAttachmentsFromContext(ctx); sa != nil && !sa.IsSupported(attachment) {
+		msg := fmt.Sprintf("unsupported parent type %q for attachment", attachment.ParentType)
+		return spellbook.NewFieldError("parentType", errors.New(msg))
+	}
+
+	if err := manager.attachments().(spellbook.TrashRepository).Restore(ctx, attachment); err != nil {
+		log.Errorf(ctx, "error restoring attachment %s: %s", attachment.Name, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Purge deletes for good a trashed attachment. Attachments no longer in the trash are left untouched
+func (manager AttachmentManager) Purge(ctx context.Context, item *trash.Item) error {
+	attachment, err := manager.trashed(ctx, item)
+	if spellbook.IsNotFound(err) {
+		return nil
+	}
 	if err != nil {
-		log.Errorf(ctx, "error deleting attachment %s: %s", attachment.Name, err.Error())
 		return err
 	}
 
+	if err := manager.attachments().Delete(ctx, attachment); err != nil {
+		log.Errorf(ctx, "error purging attachment %s: %s", attachment.Name, err.Error())
+		return err
+	}
 	return nil
 }
+
+// OnParentDelete applies the cascade policy of the parent type to the attachments of a parent being deleted.
+// Managers of the declared parent types call it before deleting a parent:
+// permissions are checked by the caller
+func (manager AttachmentManager) OnParentDelete(ctx context.Context, parentType string, parentKey string) error {
+	attachments, err := attachmentsOfParent(ctx, manager.attachments(), parentType, parentKey)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the attachments of %s %s: %s", parentType, parentKey, err.Error())
+		return err
+	}
+
+	changes, err := cascade(parentType, attachments, manager.attachments(), false)
+	if err != nil {
+		return err
+	}
+	return spellbook.RunInTransaction(ctx, changes, manager.attachments())
+}
+
+// RunInTransaction runs fn in the transactions of the repositories of the manager,
+// making the batch requests and the extenders hooks atomic
+func (manager AttachmentManager) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInTransaction(ctx, fn, manager.attachments(), manager.trash())
+}
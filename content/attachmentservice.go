@@ -10,6 +10,9 @@ const attKey attk = "__attachments"
 
 var allowedParents []string
 
+// declaration of the service, queried for the cascade policies and the parents existence
+var parentDeclaration ParentDeclaration
+
 type SupportedAttachments struct{}
 
 var supportedAtt SupportedAttachments
@@ -33,6 +36,8 @@ type AttachmentService struct {
 	ParentDeclaration
 }
 
+// ParentDeclaration declares the parent types of the attachments.
+// It may also implement CascadeDeclaration and ParentResolver
 type ParentDeclaration interface {
 	// return the supported parents
 	DeclareParents() []string
@@ -48,6 +53,7 @@ func (service *AttachmentService) Name() string {
 func (service *AttachmentService) Initialize() {
 	// add default supported parents
 	allowedParents = append(allowedParents, "content")
+	parentDeclaration = service.ParentDeclaration
 	if service.ParentDeclaration != nil {
 		parents := service.DeclareParents()
 		for _, p := range parents {
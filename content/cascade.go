@@ -0,0 +1,98 @@
+package content
+
+import (
+	"context"
+	"database/sql"
+	"decodica.com/spellbook"
+	"fmt"
+)
+
+// CascadePolicy is what happens to the attachments of a parent when the parent is deleted
+type CascadePolicy int
+
+const (
+	// the attachments are deleted together with the parent
+	CascadeDelete CascadePolicy = iota
+	// the parent can't be deleted while it has attachments
+	CascadeRestrict
+	// the attachments are kept as global attachments
+	CascadeDetach
+)
+
+// CascadeDeclaration is implemented by the parent declarations that set the cascade policy of the parent types.
+// The attachments of parent types without a declaration are deleted with their parent
+type CascadeDeclaration interface {
+	DeclareCascade(parentType string) CascadePolicy
+}
+
+// ParentResolver is implemented by the parent declarations that can tell if a parent of a declared type exists,
+// so that the orphan attachments of that type can be found
+type ParentResolver interface {
+	ParentExists(ctx context.Context, parentType string, parentKey string) (bool, error)
+}
+
+// CascadePolicyOf returns the cascade policy of the parent type
+func CascadePolicyOf(parentType string) CascadePolicy {
+	if cd, ok := parentDeclaration.(CascadeDeclaration); ok {
+		return cd.DeclareCascade(parentType)
+	}
+	return CascadeDelete
+}
+
+// returns the attachments of the parent, sorted by display order
+func attachmentsOfParent(ctx context.Context, repository spellbook.Repository, parentType string, parentKey string) ([]*Attachment, error) {
+	resources, err := repository.ListOf(ctx, spellbook.Query{
+		Filters: []spellbook.Filter{
+			{Field: "ParentKey", Operator: spellbook.FilterEqual, Value: parentKey},
+			{Field: "ParentType", Operator: spellbook.FilterEqual, Value: parentType},
+		},
+		Order: "DisplayOrder",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]*Attachment, len(resources))
+	for i := range resources {
+		attachments[i] = resources[i].(*Attachment)
+	}
+	return attachments, nil
+}
+
+// returns the changes the cascade policy of the parent type makes to the attachments of a deleted parent.
+// The attachments are retrieved by the caller before the parent is deleted,
+// since queries can't run in datastore transactions, while the changes run in the transaction deleting the parent.
+// Parents moved to the trash take their deleted attachments with them, if the repository can keep them there
+func cascade(parentType string, attachments []*Attachment, repository spellbook.Repository, trashed bool) (func(ctx context.Context) error, error) {
+	policy := CascadePolicyOf(parentType)
+	if policy == CascadeRestrict && len(attachments) > 0 {
+		return nil, spellbook.NewFieldError("attachments", fmt.Errorf("the %s has %d attachments and can't be deleted", parentType, len(attachments)))
+	}
+
+	return func(ctx context.Context) error {
+		for _, att := range attachments {
+			if policy == CascadeDetach {
+				att.ParentKey = AttachmentGlobalParent
+				att.ParentType = ""
+				att.ParentID = sql.NullInt64{}
+				att.Updated = updateTime()
+				if err := repository.Update(ctx, att); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if tr, ok := repository.(spellbook.TrashRepository); ok && trashed {
+				if err := tr.Trash(ctx, att); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := repository.Delete(ctx, att); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
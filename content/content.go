@@ -104,14 +104,14 @@ type Content struct {
 	Type        string         `model:"search"`
 	IdTranslate string         `gorm:"UNIQUE_INDEX:content_idtranslate_locale"`
 	Slug        string         `gorm:"-"`
-	SqlSlug     sql.NullString `model:"-" gorm:"column:slug;UNIQUE_INDEX:content_slug"`
+	SqlSlug     sql.NullString `model:"-" gorm:"column:slug;UNIQUE_INDEX:content_slug_locale"`
 	Title       string         `model:"search"`
 	Subtitle    string         `model:"search"`
 	Body        string         `model:"search,noindex,HTML"`
 	Tags        string         `model:"search"`
 	Category    string         `model:"search,atom" page:"gettable,category"`
 	Topic       string         `model:"search"`
-	Locale      string         `model:"search,atom" gorm:"NOT NULL;UNIQUE_INDEX:content_code_locale,content_idtranslate_locale"`
+	Locale      string         `model:"search,atom" gorm:"NOT NULL;UNIQUE_INDEX:content_code_locale,content_idtranslate_locale,content_slug_locale"`
 	Description string         `model:"search"`
 	Cover       string
 	Revision    int
@@ -132,6 +132,18 @@ type Content struct {
 	// KeyTypeEvent
 	StartDate time.Time
 	EndDate   time.Time
+
+	// publication schedule, see Scheduler
+	PublishAt   time.Time `model:"search"`
+	UnpublishAt time.Time `model:"search"`
+
+	// terms of the embedded search index, see Search. Sql databases index the contents themselves
+	SearchTerms []string `gorm:"-"`
+
+	// when the content was moved to the trash, see spellbook.TrashRepository.
+	// The datastore stores Deleted, sql databases DeletedAt, which gorm soft deletes with
+	Deleted   time.Time  `gorm:"-"`
+	DeletedAt *time.Time `model:"-"`
 }
 
 // code setters and getters
@@ -170,10 +182,46 @@ func (content *Content) getSlug() string {
 	return content.Slug
 }
 
+// copies the editable fields of the other content
+func (content *Content) assign(other *Content) {
+	content.Type = other.Type
+	content.Title = other.Title
+	content.Subtitle = other.Subtitle
+	content.Category = other.Category
+	content.Topic = other.Topic
+	content.Locale = other.Locale
+	content.Description = other.Description
+	content.setCode(other.Code)
+	content.Body = other.Body
+	content.Cover = other.Cover
+	content.Editor = other.Editor
+	content.Order = other.Order
+	content.Tags = other.Tags
+	content.setSlug(other.Slug)
+	content.ParentKey = other.ParentKey
+	content.StartDate = other.StartDate
+	content.EndDate = other.EndDate
+	content.PublishAt = other.PublishAt
+	content.UnpublishAt = other.UnpublishAt
+}
+
 func (content Content) IsPublished() bool {
 	return !content.Published.IsZero()
 }
 
+// IsVisible reports if the content can be read publicly at the given time:
+// published contents are hidden before their PublishAt and from their UnpublishAt,
+// even if the schedule hasn't run yet
+func (content Content) IsVisible(at time.Time) bool {
+	if content.state() != PublicationStatePublished {
+		return false
+	}
+	if !content.PublishAt.IsZero() && content.PublishAt.After(at) {
+		return false
+	}
+	return content.UnpublishAt.IsZero() || at.Before(content.UnpublishAt)
+}
+
 func (content Content) hasStartDate() bool {
 	return !content.StartDate.IsZero()
 }
@@ -210,6 +258,8 @@ func (content *Content) UnmarshalJSON(data []byte) error {
 		IsPublished bool          `json:"isPublished"`
 		StartDate   time.Time     `json:"startDate"`
 		EndDate     time.Time     `json:"endDate"`
+		PublishAt   time.Time     `json:"publishAt"`
+		UnpublishAt time.Time     `json:"unpublishAt"`
 	}{}
 
 	err := json.Unmarshal(data, &alias)
@@ -236,6 +286,8 @@ func (content *Content) UnmarshalJSON(data []byte) error {
 	content.Updated = alias.Updated
 	content.StartDate = alias.StartDate
 	content.EndDate = alias.EndDate
+	content.PublishAt = alias.PublishAt
+	content.UnpublishAt = alias.UnpublishAt
 	content.setCode(alias.Code)
 	content.IdTranslate = alias.IdTranslate
 	content.ParentKey = alias.Parent
@@ -271,9 +323,12 @@ func (content *Content) MarshalJSON() ([]byte, error) {
 		Created     time.Time     `json:"created"`
 		Updated     time.Time     `json:"updated"`
 		Published   time.Time     `json:"published"`
+		State       string        `json:"state"`
 		Parent      string        `json:"parent"`
 		StartDate   time.Time     `json:"startDate"`
 		EndDate     time.Time     `json:"endDate"`
+		PublishAt   time.Time     `json:"publishAt"`
+		UnpublishAt time.Time     `json:"unpublishAt"`
 	}
 
 	tags := make([]string, 0, 0)
@@ -318,8 +373,11 @@ func (content *Content) MarshalJSON() ([]byte, error) {
 			Code:        content.getCode(),
 			Updated:     content.Updated,
 			Published:   content.Published,
+			State:       string(content.state()),
 			StartDate:   content.StartDate,
 			EndDate:     content.EndDate,
+			PublishAt:   content.PublishAt,
+			UnpublishAt: content.UnpublishAt,
 			Parent:      content.ParentKey,
 		},
 	})
@@ -336,6 +394,17 @@ func (content *Content) Id() string {
 	return fmt.Sprintf("%d", content.ID)
 }
 
+// Version returns the revision and the last update time of the content, in microseconds
+func (content *Content) Version() string {
+	return fmt.Sprintf("%d-%d", content.Revision, content.Updated.UnixNano()/int64(time.Microsecond))
+}
+
+// returns the current time with the microsecond precision of the datastore and of Postgres,
+// so that the versions of the resources are the same before and after they are stored
+func updateTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
 func (content *Content) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
 	switch rtype {
 	case spellbook.RepresentationTypeJSON:
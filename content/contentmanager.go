@@ -3,13 +3,14 @@ package content
 import (
 	"cloud.google.com/go/datastore"
 	"context"
-	"decodica.com/flamel/model"
+	"database/sql"
 	"decodica.com/spellbook"
 	"decodica.com/spellbook/identity"
+	"decodica.com/spellbook/trash"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"google.golang.org/appengine/log"
-	"reflect"
 	"sort"
 	"time"
 )
@@ -25,7 +26,116 @@ func NewContentControllerWithKey(key string) *spellbook.RestController {
 	return c
 }
 
-type ContentManager struct{}
+// ContentManager handles the contents and their attachments, stored in the given repositories.
+// Each update stores the replaced revision of the content in the Revisions repository.
+// Contents change publication state through the transitions of the Workflow, recorded in StateChanges.
+// Deleted contents are moved to the Trash, together with the attachments deleted with them:
+// they are kept in place, hidden by the repositories, until they are restored or purged, see spellbook.TrashRepository.
+// Slug changes are recorded in Redirects, see SlugRedirect.
+// Contents form trees through their ParentKey, the children of deleted contents are handled by the Children policy.
+// The zero value stores them in the datastore and follows the DefaultWorkflow,
+// managers with a Repository and no Revisions, StateChanges, Redirects or Trash keep no history and delete permanently
+type ContentManager struct {
+	Repository   spellbook.Repository
+	Attachments  spellbook.Repository
+	Revisions    spellbook.Repository
+	StateChanges spellbook.Repository
+	Redirects    spellbook.Repository
+	Trash        spellbook.Repository
+	Workflow     Workflow
+	Children     ChildrenPolicy
+}
+
+// trash item type of the contents
+const TrashTypeContent = "content"
+
+func (manager ContentManager) contents() spellbook.Repository {
+	if manager.Repository == nil {
+		return contentRepository{}
+	}
+	return manager.Repository
+}
+
+func (manager ContentManager) attachments() spellbook.Repository {
+	if manager.Attachments == nil {
+		return attachmentRepository{}
+	}
+	return manager.Attachments
+}
+
+func (manager ContentManager) revisions() spellbook.Repository {
+	if manager.Revisions == nil && manager.Repository == nil {
+		return revisionRepository{}
+	}
+	return manager.Revisions
+}
+
+func (manager ContentManager) stateChanges() spellbook.Repository {
+	if manager.StateChanges == nil && manager.Repository == nil {
+		return stateChangeRepository{}
+	}
+	return manager.StateChanges
+}
+
+func (manager ContentManager) redirects() spellbook.Repository {
+	if manager.Redirects == nil && manager.Repository == nil {
+		return redirectRepository{}
+	}
+	return manager.Redirects
+}
+
+func (manager ContentManager) workflow() Workflow {
+	if len(manager.Workflow.Transitions) == 0 {
+		return DefaultWorkflow
+	}
+	return manager.Workflow
+}
+
+// contents are moved to the trash only if their repository can keep them there
+func (manager ContentManager) trash() spellbook.Repository {
+	if _, ok := manager.contents().(spellbook.TrashRepository); !ok {
+		return nil
+	}
+	if manager.Trash == nil && manager.Repository == nil {
+		return trash.DatastoreRepository{}
+	}
+	return manager.Trash
+}
+
+// fields the contents can be filtered by
+var contentFilterFields = spellbook.FilterFields{
+	"Type":             spellbook.FieldString,
+	"Title":            spellbook.FieldString,
+	"Slug":             spellbook.FieldString,
+	"Code":             spellbook.FieldString,
+	"Category":         spellbook.FieldString,
+	"Topic":            spellbook.FieldString,
+	"Tags":             spellbook.FieldString,
+	"Locale":           spellbook.FieldString,
+	"IdTranslate":      spellbook.FieldString,
+	"Author":           spellbook.FieldString,
+	"Editor":           spellbook.FieldString,
+	"ParentKey":        spellbook.FieldString,
+	"Order":            spellbook.FieldInt,
+	"Created":          spellbook.FieldTime,
+	"Updated":          spellbook.FieldTime,
+	"Published":        spellbook.FieldTime,
+	"PublicationState": spellbook.FieldString,
+	"PublishAt":        spellbook.FieldTime,
+	"UnpublishAt":      spellbook.FieldTime,
+}
+
+// Describe describes the contents for the OpenAPI document
+func (manager ContentManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "Content",
+		Filterable:       contentFilterFields,
+		Orderable:        []string{"Order", "Title", "Created", "Updated", "Published"},
+		Searchable:       true,
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadContent},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteContent},
+	}
+}
 
 func (manager ContentManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &Content{}, nil
@@ -37,61 +147,57 @@ func (manager ContentManager) FromId(ctx context.Context, id string) (spellbook.
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
 	}
 
-	cont := Content{}
-
-	if err := model.FromEncodedKey(ctx, &cont, id); err != nil {
+	res, err := manager.contents().FromId(ctx, id)
+	if err != nil {
 		log.Errorf(ctx, "could not retrieve content %s: %s", id, err.Error())
 		return nil, err
 	}
+	cont := res.(*Content)
 
 	// attachment
-	q := model.NewQuery((*Attachment)(nil))
-	q = q.WithField("ParentKey =", cont.Id())
-	q = q.OrderBy("DisplayOrder", model.ASC)
-	if err := q.GetMulti(ctx, &cont.Attachments); err != nil {
+	cont.Attachments, err = manager.attachmentsOf(ctx, cont)
+	if err != nil {
 		log.Errorf(ctx, "could not retrieve content %s attachments: %s", id, err.Error())
 		return nil, err
 	}
 
-	return &cont, nil
+	return cont, nil
+}
+
+// returns the attachments of the content, sorted by display order
+func (manager ContentManager) attachmentsOf(ctx context.Context, content *Content) ([]*Attachment, error) {
+	return attachmentsOfParent(ctx, manager.attachments(), AttachmentParentTypeContent, content.Id())
 }
 
 func (manager ContentManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	resources, _, err := manager.ListOfWithCursor(ctx, opts)
+	return resources, err
+}
+
+// ListOfWithCursor lists the contents, paging them by cursor if one is provided
+func (manager ContentManager) ListOfWithCursor(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, string, error) {
 
 	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
-		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+		return nil, "", spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
 	}
 
-	var conts []*Content
-	q := model.NewQuery(&Content{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	if opts.Search != "" {
+		resources, err := manager.search(ctx, opts)
+		return resources, "", err
 	}
 
-	// get one more so we know if we are done
-	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &conts)
+	query, cursor, err := spellbook.PageQuery(opts, &Content{})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	resources := make([]spellbook.Resource, len(conts))
-	for i := range conts {
-		resources[i] = conts[i]
+	resources, err := manager.contents().ListOf(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving contents: %s", err.Error())
+		return nil, "", err
 	}
 
-	return resources, nil
+	return cursor.Page(resources, opts)
 }
 
 func (manager ContentManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
@@ -112,41 +218,43 @@ func (manager ContentManager) ListOfProperties(ctx context.Context, opts spellbo
 		return nil, datastore.ErrNoSuchEntity
 	}
 
-	var conts []*Content
-	q := model.NewQuery(&Content{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	repository, ok := manager.contents().(spellbook.DistinctRepository)
+	if !ok {
+		return nil, spellbook.NewUnsupportedError()
 	}
 
-	q = q.Distinct(name)
-	q = q.Limit(opts.Size + 1)
-	err := q.GetAll(ctx, &conts)
+	query := spellbook.Query{Filters: opts.Filters, Offset: opts.Page * opts.Size, Limit: opts.Size + 1}
+	result, err := repository.Distinct(ctx, name, query)
 	if err != nil {
 		log.Errorf(ctx, "Error retrieving result: %+v", err)
 		return nil, err
 	}
-	var result []string
-	for _, c := range conts {
-		value := reflect.ValueOf(c).Elem().FieldByName(name).String()
-		if len(value) > 0 {
-			result = append(result, value)
-		}
-	}
 	return result, nil
 }
 
+// Count returns the number of contents matching the filters and the search of the options
+func (manager ContentManager) Count(ctx context.Context, opts spellbook.ListOptions) (int, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return 0, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	if opts.Search != "" {
+		results, err := manager.Search(ctx, opts.Search, spellbook.Query{Filters: opts.Filters})
+		return len(results), err
+	}
+	return manager.contents().Count(ctx, spellbook.QueryFromOptions(opts))
+}
+
+// returns the filters of the contents that would conflict with the given one,
+// either by slug or, for special contents, by code, and the conflicting field
+func uniqueFilters(content *Content) ([]spellbook.Filter, string) {
+	locale := spellbook.Filter{Field: "Locale", Operator: spellbook.FilterEqual, Value: content.Locale}
+	if content.Code == "" {
+		return []spellbook.Filter{{Field: "Slug", Operator: spellbook.FilterEqual, Value: content.Slug}, locale}, "slug"
+	}
+	return []spellbook.Filter{{Field: "Code", Operator: spellbook.FilterEqual, Value: content.Code}, locale}, "code"
+}
+
 func (manager ContentManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 
 	current := spellbook.IdentityFromContext(ctx)
@@ -161,10 +269,10 @@ func (manager ContentManager) Create(ctx context.Context, res spellbook.Resource
 		content.IdTranslate = time.Now().Format(time.RFC3339Nano)
 	} else {
 		// check same idTranslate and Locale
-		q := model.NewQuery((*Content)(nil))
-		q = q.WithField("IdTranslate =", content.IdTranslate)
-		q = q.WithField("Locale = ", content.Locale)
-		count, err := q.Count(ctx)
+		count, err := manager.contents().Count(ctx, spellbook.Query{Filters: []spellbook.Filter{
+			{Field: "IdTranslate", Operator: spellbook.FilterEqual, Value: content.IdTranslate},
+			{Field: "Locale", Operator: spellbook.FilterEqual, Value: content.Locale},
+		}, Trashed: true})
 		if err != nil {
 			return spellbook.NewFieldError("locale", fmt.Errorf("error verifying locale translate: %s", err.Error()))
 		}
@@ -175,11 +283,19 @@ func (manager ContentManager) Create(ctx context.Context, res spellbook.Resource
 	}
 	content.Revision = 1
 
+	// contents are created as drafts, published ones are published by the workflow
+	var change *StateChange
+	content.PublicationState = PublicationStateDraft
 	if content.IsPublished() {
-		content.PublicationState = PublicationStatePublished
-		content.Published = time.Now().UTC()
-	} else {
-		content.PublicationState = PublicationStateUnpublished
+		content.Published = time.Time{}
+		transition, ok := manager.workflow().between(PublicationStateDraft, PublicationStatePublished)
+		if !ok {
+			return spellbook.NewFieldError("isPublished", errors.New("drafts can't be published, the content must be reviewed first"))
+		}
+		var err error
+		if change, err = changeState(ctx, content, transition, ""); err != nil {
+			return err
+		}
 	}
 
 	if content.Type == "" {
@@ -190,25 +306,21 @@ func (manager ContentManager) Create(ctx context.Context, res spellbook.Resource
 		return spellbook.NewFieldError("title", errors.New("title can't be empty"))
 	}
 
+	// non special contents without a slug get one from the title
 	if content.Slug == "" && content.Code == "" {
-		return spellbook.NewFieldError("slug", fmt.Errorf("non special content can't have an empty slug"))
+		slug, err := manager.uniqueSlug(ctx, content)
+		if err != nil {
+			return err
+		}
+		content.setSlug(slug)
 	}
 
 	// if the same slug already exists, we must return
-	// otherwise we would overwrite an existing entry, which is not in the spirit of the create method
-	q := model.NewQuery((*Content)(nil))
-
-	// if is a special content, we check that the content doesn't already exist
-	reason := "code"
-	if content.Code == "" {
-		q = q.WithField("Slug =", content.Slug)
-		q = q.WithField("Locale = ", content.Locale)
-		reason = "slug"
-	} else {
-		q = q.WithField("Code =", content.Code)
-		q = q.WithField("Locale = ", content.Locale)
-	}
-	count, err := q.Count(ctx)
+	// otherwise we would overwrite an existing entry, which is not in the spirit of the create method.
+	// if is a special content, we check that the content doesn't already exist.
+	// Trashed contents keep their slug until they are purged, so that they can be restored
+	filters, reason := uniqueFilters(content)
+	count, err := manager.contents().Count(ctx, spellbook.Query{Filters: filters, Trashed: true})
 	if err != nil {
 		return spellbook.NewFieldError("slug", fmt.Errorf("error verifying slug uniqueness: %s", err.Error()))
 	}
@@ -223,24 +335,64 @@ func (manager ContentManager) Create(ctx context.Context, res spellbook.Resource
 		return spellbook.NewFieldError("endDate", errors.New(msg))
 	}
 
+	if err := validateSchedule(content); err != nil {
+		return err
+	}
+
+	if err := manager.validateParent(ctx, "", content.ParentKey); err != nil {
+		return err
+	}
+
 	if user, ok := current.(identity.User); ok {
 		content.Author = user.Username()
 	}
+	content.index()
 
-	// // WARNING: the volatile field Multimedia because Memcache (Gob)
-	//	can't ignore field
-	tmp := content.Attachments
+	// the attachments of the bundle are created together with the content
+	attachments := content.Attachments
 	content.Attachments = nil
+	defer func() {
+		content.Attachments = attachments
+	}()
 
-	err = model.Create(ctx, content)
-	if err != nil {
-		log.Errorf(ctx, "error creating post %s: %s", content.Slug, err)
-		return err
-	}
+	err = spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := manager.contents().Create(ctx, content); err != nil {
+			log.Errorf(ctx, "error creating post %s: %s", content.Slug, err)
+			return err
+		}
 
-	// return the swapped multimedia value
-	content.Attachments = tmp
+		// the change is recorded once the content has its key
+		if change != nil && manager.stateChanges() != nil {
+			change.ContentKey = content.Id()
+			if err := manager.stateChanges().Create(ctx, change); err != nil {
+				log.Errorf(ctx, "error recording the publication of post %s: %s", content.Slug, err)
+				return err
+			}
+		}
+		return manager.createAttachments(ctx, content, attachments)
+	}, manager.contents(), manager.attachments(), manager.stateChanges())
 
+	return err
+}
+
+// creates the attachments of a content being created
+func (manager ContentManager) createAttachments(ctx context.Context, content *Content, attachments []*Attachment) error {
+	for _, att := range attachments {
+		att.ParentKey = content.Id()
+		att.ParentType = AttachmentParentTypeContent
+		att.ParentID = sql.NullInt64{Int64: int64(content.ID), Valid: content.ID != 0}
+		if att.Created.IsZero() {
+			att.Created = content.Created
+		}
+		att.Updated = updateTime()
+		if att.Uploader == "" {
+			att.Uploader = content.Author
+		}
+		if err := manager.attachments().Create(ctx, att); err != nil {
+			log.Errorf(ctx, "error creating attachment %s of post %s: %s", att.Name, content.Slug, err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -267,92 +419,201 @@ func (manager ContentManager) Update(ctx context.Context, res spellbook.Resource
 		return spellbook.NewFieldError("title", errors.New("title can't be empty"))
 	}
 
+	// contents updated without a slug keep theirs, so that their URLs don't change
+	if other.Slug == "" && other.Code == "" {
+		slug := content.getSlug()
+		if slug == "" {
+			var err error
+			if slug, err = manager.uniqueSlug(ctx, other); err != nil {
+				return err
+			}
+		}
+		other.setSlug(slug)
+	}
+
 	// if the same slug already exists, we must return
 	// otherwise we would overwrite an existing entry, which is not in the spirit of the create method
-	q := model.NewQuery((*Content)(nil))
+	filters, reason := uniqueFilters(other)
+	compare, err := manager.contents().ListOf(ctx, spellbook.Query{Filters: filters, Limit: 1, Trashed: true})
+	if err != nil {
+		return spellbook.NewFieldError("slug", fmt.Errorf("error verifying content correctness: %s", err.Error()))
+	}
 
-	if other.Slug == "" && other.Code == "" {
-		return spellbook.NewFieldError("slug", fmt.Errorf("non special content can't have an empty slug"))
+	if len(compare) > 0 && compare[0].Id() != content.Id() {
+		return spellbook.NewFieldError("slug", fmt.Errorf("a content with the same %s already exists", reason))
 	}
 
-	reason := "code"
-	if other.Code == "" {
-		q = q.WithField("Slug =", other.Slug)
-		q = q.WithField("Locale = ", other.Locale)
-		reason = "slug"
-	} else {
-		q = q.WithField("Code =", other.Code)
-		q = q.WithField("Locale = ", other.Locale)
+	if !other.StartDate.IsZero() && !other.EndDate.IsZero() && other.EndDate.Before(other.StartDate) {
+		msg := fmt.Sprintf("end date %v can't be before start date %v", other.EndDate, other.StartDate)
+		return spellbook.NewFieldError("endDate", errors.New(msg))
 	}
 
-	compare := Content{}
-	err := q.First(ctx, &compare)
-	if err == nil && compare.EncodedKey() != content.EncodedKey() {
-		return spellbook.NewFieldError("slug", fmt.Errorf("a content with the same %s already exists", reason))
+	if err := validateSchedule(other); err != nil {
+		return err
 	}
 
-	if err != nil && err != datastore.ErrNoSuchEntity {
-		return spellbook.NewFieldError("slug", fmt.Errorf("error verifying content correctness: %s", err.Error()))
+	if other.ParentKey != content.ParentKey {
+		if err := manager.validateParent(ctx, content.Id(), other.ParentKey); err != nil {
+			return err
+		}
 	}
 
-	content.Type = other.Type
-	content.Title = other.Title
-	content.Subtitle = other.Subtitle
-	content.Category = other.Category
-	content.Topic = other.Topic
-	content.Locale = other.Locale
-	content.Description = other.Description
-	content.setCode(other.Code)
-	content.Body = other.Body
-	content.Cover = other.Cover
-	content.Revision = other.Revision
-	content.Editor = other.Editor
-	content.Order = other.Order
-	content.Updated = time.Now().UTC()
-	content.Tags = other.Tags
-	content.setSlug(other.Slug)
-	content.ParentKey = other.ParentKey
-
-	if !other.IsPublished() {
-		// not set
-		content.Published = time.Time{} // zero
-	} else {
-		// set
-		// check previous data
-		if !content.IsPublished() {
-			content.Published = time.Now().UTC()
+	// publishing and unpublishing are the transitions between the two states,
+	// made on a copy so that the revision keeps the replaced state
+	var change *StateChange
+	changed := *content
+	if other.IsPublished() != content.IsPublished() {
+		from, to := content.state(), PublicationStatePublished
+		if content.IsPublished() {
+			to = PublicationStateDraft
+		}
+		transition, ok := manager.workflow().between(from, to)
+		if !ok {
+			return spellbook.NewFieldError("isPublished", fmt.Errorf("a content in state %s can't be moved to %s", from, to))
+		}
+		if change, err = changeState(ctx, &changed, transition, ""); err != nil {
+			return err
 		}
 	}
 
-	if content.IsPublished() {
-		content.PublicationState = PublicationStatePublished
-	} else {
-		content.PublicationState = PublicationStateUnpublished
+	return manager.update(ctx, content, change, func() {
+		content.assign(other)
+		content.PublicationState = changed.PublicationState
+		content.Published = changed.Published
+
+		if user, ok := current.(identity.User); ok {
+			content.Author = user.Username()
+		}
+	})
+}
+
+// Transition makes the named transition of the workflow on the content, returning the recorded change.
+// Permissions are the ones of the transition
+func (manager ContentManager) Transition(ctx context.Context, content *Content, name string, reason string) (*StateChange, error) {
+	transition, ok := manager.workflow().transition(name)
+	if !ok {
+		return nil, spellbook.NewFieldError("transition", fmt.Errorf("unknown transition %q", name))
 	}
 
-	if !other.StartDate.IsZero() && !other.EndDate.IsZero() && other.EndDate.Before(other.StartDate) {
-		msg := fmt.Sprintf("end date %v can't be before start date %v", other.EndDate, other.StartDate)
-		return spellbook.NewFieldError("endDate", errors.New(msg))
+	change, err := changeState(ctx, content, transition, reason)
+	if err != nil {
+		return nil, err
 	}
 
-	content.StartDate = other.StartDate
-	content.EndDate = other.EndDate
+	if err := manager.storeTransition(ctx, content, change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
 
-	if user, ok := current.(identity.User); ok {
-		content.Author = user.Username()
+// stores the content changed by a transition together with the record of the change
+func (manager ContentManager) storeTransition(ctx context.Context, content *Content, change *StateChange) error {
+	content.Updated = updateTime()
+
+	return spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if manager.stateChanges() != nil {
+			if err := manager.stateChanges().Create(ctx, change); err != nil {
+				log.Errorf(ctx, "error recording transition %s of post %s: %s", change.Transition, content.Slug, err)
+				return err
+			}
+		}
+
+		if err := manager.contents().Update(ctx, content); err != nil {
+			return fmt.Errorf("error updating post %s: %s", content.Slug, err)
+		}
+		return nil
+	}, manager.contents(), manager.stateChanges())
+}
+
+// stores the revision of the content, then updates the content with the changes made by apply.
+// The revision is handled server side and is the base of the content version.
+// The state change, if any, has already been made and is recorded with the update,
+// as is the redirect from the replaced slug
+func (manager ContentManager) update(ctx context.Context, content *Content, change *StateChange, apply func()) error {
+	revision, err := newRevision(ctx, content)
+	if err != nil {
+		return err
 	}
 
-	tmp := content.Attachments
-	content.Attachments = nil
+	locale, slug := content.Locale, content.getSlug()
+	apply()
+	content.Revision++
+	content.Updated = updateTime()
+	content.index()
 
-	if err := model.Update(ctx, content); err != nil {
-		return fmt.Errorf("error updating post %s: %s", content.Slug, err)
+	redirect := func(ctx context.Context) error { return nil }
+	if slug != "" && slug != content.getSlug() {
+		if redirect, err = manager.moved(ctx, content, locale, slug); err != nil {
+			log.Errorf(ctx, "error retrieving the redirects of post %s: %s", slug, err)
+			return err
+		}
 	}
 
-	// return the swapped multimedia value
-	content.Attachments = tmp
+	return spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if manager.revisions() != nil {
+			if err := manager.revisions().Create(ctx, revision); err != nil {
+				log.Errorf(ctx, "error storing revision %d of post %s: %s", revision.Number, content.Slug, err)
+				return err
+			}
+		}
 
-	return nil
+		if change != nil && manager.stateChanges() != nil {
+			if err := manager.stateChanges().Create(ctx, change); err != nil {
+				log.Errorf(ctx, "error recording transition %s of post %s: %s", change.Transition, content.Slug, err)
+				return err
+			}
+		}
+
+		if err := redirect(ctx); err != nil {
+			log.Errorf(ctx, "error recording the redirect from %s of post %s: %s", slug, content.Slug, err)
+			return err
+		}
+
+		if err := manager.contents().Update(ctx, content); err != nil {
+			return fmt.Errorf("error updating post %s: %s", content.Slug, err)
+		}
+		return nil
+	}, manager.contents(), manager.revisions(), manager.stateChanges(), manager.redirects())
+}
+
+// RestoreRevision updates the content to the revision, storing the replaced revision like any other update.
+// Attachments are not part of the revisions and the publication state belongs to the workflow: both are left as they are
+func (manager ContentManager) RestoreRevision(ctx context.Context, content *Content, revision *Revision) error {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionWriteContent) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteContent))
+	}
+
+	if revision.ContentKey != content.Id() {
+		return spellbook.NewFieldError("revision", fmt.Errorf("revision %d is not a revision of content %s", revision.Number, content.Id()))
+	}
+
+	other := &Content{}
+	if err := other.FromRepresentation(spellbook.RepresentationTypeJSON, []byte(revision.Data)); err != nil {
+		return spellbook.NewFieldError("data", fmt.Errorf("bad revision %d: %s", revision.Number, err.Error()))
+	}
+
+	// the slug or the code of the revision may have been taken in the meantime
+	filters, reason := uniqueFilters(other)
+	compare, err := manager.contents().ListOf(ctx, spellbook.Query{Filters: filters, Limit: 1, Trashed: true})
+	if err != nil {
+		return spellbook.NewFieldError("slug", fmt.Errorf("error verifying content correctness: %s", err.Error()))
+	}
+
+	if len(compare) > 0 && compare[0].Id() != content.Id() {
+		return spellbook.NewFieldError("slug", fmt.Errorf("a content with the same %s already exists", reason))
+	}
+
+	// the parent of the revision may have been deleted or moved under the content in the meantime
+	if other.ParentKey != content.ParentKey {
+		if err := manager.validateParent(ctx, content.Id(), other.ParentKey); err != nil {
+			return err
+		}
+	}
+
+	return manager.update(ctx, content, nil, func() {
+		content.assign(other)
+		content.Author = other.Author
+	})
 }
 
 func (manager ContentManager) Delete(ctx context.Context, res spellbook.Resource) error {
@@ -362,29 +623,269 @@ func (manager ContentManager) Delete(ctx context.Context, res spellbook.Resource
 	}
 
 	content := res.(*Content)
-	err := model.Delete(ctx, content, nil)
+
+	children, descendants, err := manager.orphaned(ctx, content)
 	if err != nil {
-		log.Errorf(ctx, "error deleting content %s: %s", content.Slug, err.Error())
 		return err
 	}
 
-	// delete attachments with parent = slug
-	attachments := make([]*Attachment, 0, 0)
-	q := model.NewQuery(&Attachment{})
-	q.WithField("ParentKey =", content.EncodedKey())
-	err = q.GetMulti(ctx, &attachments)
+	remove, err := manager.removal(ctx, content)
 	if err != nil {
-		log.Errorf(ctx, "error retrieving attachments: %s", err)
 		return err
 	}
 
-	for _, att := range attachments {
-		err = model.Delete(ctx, att, nil)
+	err = spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := children(ctx); err != nil {
+			log.Errorf(ctx, "error handling the children of content %s: %s", content.Slug, err.Error())
+			return err
+		}
+		return remove(ctx)
+	}, manager.contents(), manager.attachments(), manager.trash())
+	if err != nil {
+		return err
+	}
+
+	// trashed contents keep their history until they are purged
+	if manager.trash() == nil {
+		for _, c := range append(descendants, content) {
+			manager.deleteRevisions(ctx, c)
+			manager.deleteRedirects(ctx, c)
+		}
+	}
+	return nil
+}
+
+// prepares the removal of the content with its attachments, moving them to the trash if the manager keeps one.
+// The attachments are retrieved right away, since queries can't run in datastore transactions,
+// while the returned function removes the content and is meant to run in the transaction of the delete
+func (manager ContentManager) removal(ctx context.Context, content *Content) (func(ctx context.Context) error, error) {
+	attachments, err := manager.attachmentsOf(ctx, content)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving attachments: %s", err)
+		return nil, err
+	}
+
+	changes, err := cascade(AttachmentParentTypeContent, attachments, manager.attachments(), manager.trash() != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the item of the trashed content lists the attachments trashed with it
+	var item *trash.Item
+	if manager.trash() != nil {
+		trashed := *content
+		trashed.Attachments = nil
+		if CascadePolicyOf(AttachmentParentTypeContent) == CascadeDelete {
+			trashed.Attachments = attachments
+		}
+		if item, err = trash.NewItem(ctx, TrashTypeContent, content.Title, &trashed); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(ctx context.Context) error {
+		// attachments are changed first, so that they never reference a missing content
+		if err := changes(ctx); err != nil {
+			log.Errorf(ctx, "error updating the attachments of content %s: %s", content.Slug, err.Error())
+			return err
+		}
+
+		if item == nil {
+			if err := manager.contents().Delete(ctx, content); err != nil {
+				log.Errorf(ctx, "error deleting content %s: %s", content.Slug, err.Error())
+				return err
+			}
+			return nil
+		}
+
+		if err := manager.contents().(spellbook.TrashRepository).Trash(ctx, content); err != nil {
+			log.Errorf(ctx, "error moving content %s to the trash: %s", content.Slug, err.Error())
+			return err
+		}
+		if err := manager.trash().Create(ctx, item); err != nil {
+			log.Errorf(ctx, "error moving content %s to the trash: %s", content.Slug, err.Error())
+			return err
+		}
+		return nil
+	}, nil
+}
+
+// deletes the revisions of a deleted content.
+// Histories can outgrow a transaction, so revisions are deleted once the content is gone:
+// the ones left behind by a failure are harmless, since they are only reached through their content
+func (manager ContentManager) deleteRevisions(ctx context.Context, content *Content) {
+	if manager.revisions() == nil {
+		return
+	}
+
+	query := spellbook.Query{Filters: []spellbook.Filter{{Field: "ContentKey", Operator: spellbook.FilterEqual, Value: content.Id()}}}
+	revisions, err := manager.revisions().ListOf(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the revisions of deleted content %s: %s", content.Slug, err.Error())
+		return
+	}
+
+	for _, revision := range revisions {
+		if err := manager.revisions().Delete(ctx, revision); err != nil {
+			log.Errorf(ctx, "error deleting revision %s of deleted content %s: %s", revision.Id(), content.Slug, err.Error())
+		}
+	}
+}
+
+// returns the trashed content of the item, or a not found error if it is no longer in the trash
+func (manager ContentManager) trashed(ctx context.Context, item *trash.Item) (*Content, error) {
+	contents, ok := manager.contents().(spellbook.TrashRepository)
+	if !ok {
+		return nil, spellbook.ErrNotFound
+	}
+	res, err := contents.Trashed(ctx, item.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*Content), nil
+}
+
+// returns the attachments trashed together with the content of the item.
+// The ones no longer in the trash are skipped
+func (manager ContentManager) trashedAttachments(ctx context.Context, item *trash.Item) ([]*Attachment, error) {
+	data := struct {
+		Attachments []struct {
+			Id string `json:"id"`
+		} `json:"attachments"`
+	}{}
+	if err := json.Unmarshal([]byte(item.Data), &data); err != nil {
+		return nil, spellbook.NewFieldError("data", fmt.Errorf("bad trashed content: %s", err.Error()))
+	}
+
+	repository, ok := manager.attachments().(spellbook.TrashRepository)
+	if !ok {
+		return nil, nil
+	}
+
+	var attachments []*Attachment
+	for _, a := range data.Attachments {
+		res, err := repository.Trashed(ctx, a.Id)
+		if spellbook.IsNotFound(err) {
+			continue
+		}
 		if err != nil {
-			log.Errorf(ctx, "error deleting attachment %s: %s", att.Name, err.Error())
+			return nil, err
+		}
+		attachments = append(attachments, res.(*Attachment))
+	}
+	return attachments, nil
+}
+
+// Restore brings back a trashed content, with its id, its history and the attachments trashed with it.
+// Contents whose parent is gone are restored as roots
+func (manager ContentManager) Restore(ctx context.Context, item *trash.Item) error {
+	content, err := manager.trashed(ctx, item)
+	if spellbook.IsNotFound(err) {
+		return spellbook.NewFieldError("resourceId", fmt.Errorf("content %s is no longer in the trash", item.ResourceId))
+	}
+	if err != nil {
+		return err
+	}
+
+	attachments, err := manager.trashedAttachments(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	filters, reason := uniqueFilters(content)
+	count, err := manager.contents().Count(ctx, spellbook.Query{Filters: filters})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return spellbook.NewFieldError("slug", fmt.Errorf("a content with the same %s already exists.", reason))
+	}
+
+	parentKey := content.ParentKey
+	if content.ParentKey != "" {
+		if _, err := manager.contents().FromId(ctx, content.ParentKey); spellbook.IsNotFound(err) {
+			content.ParentKey = ""
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := manager.contents().(spellbook.TrashRepository).Restore(ctx, content); err != nil {
+			log.Errorf(ctx, "error restoring content %s: %s", content.Slug, err.Error())
+			return err
+		}
+		if content.ParentKey != parentKey {
+			if err := manager.contents().Update(ctx, content); err != nil {
+				log.Errorf(ctx, "error moving restored content %s to the root: %s", content.Slug, err.Error())
+				return err
+			}
+		}
+
+		for _, attachment := range attachments {
+			if err := manager.attachments().(spellbook.TrashRepository).Restore(ctx, attachment); err != nil {
+				log.Errorf(ctx, "error restoring attachment %s of content %s: %s", attachment.Id(), content.Slug, err.Error())
+				return err
+			}
+		}
+		return nil
+	}, manager.contents(), manager.attachments())
+}
+
+// Purge deletes for good a trashed content, with the attachments trashed with it, its revisions and its redirects.
+// Contents no longer in the trash are left untouched
+func (manager ContentManager) Purge(ctx context.Context, item *trash.Item) error {
+	content, err := manager.trashed(ctx, item)
+	if spellbook.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	attachments, err := manager.trashedAttachments(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	err = spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		for _, attachment := range attachments {
+			if err := manager.attachments().Delete(ctx, attachment); err != nil {
+				log.Errorf(ctx, "error purging attachment %s of content %s: %s", attachment.Id(), content.Slug, err.Error())
+				return err
+			}
+		}
+
+		if err := manager.contents().Delete(ctx, content); err != nil {
+			log.Errorf(ctx, "error purging content %s: %s", content.Slug, err.Error())
 			return err
 		}
+		return nil
+	}, manager.contents(), manager.attachments())
+	if err != nil {
+		return err
 	}
 
+	manager.deleteRevisions(ctx, content)
+	manager.deleteRedirects(ctx, content)
 	return nil
 }
+
+// Resave saves again all the contents and the attachments, see spellbook.Resave.
+// Contents are indexed again too, so that the ones written before the embedded index are found by Search
+func (manager ContentManager) Resave(ctx context.Context) (int, error) {
+	saved, err := spellbook.Resave(ctx, manager.contents(), func(res spellbook.Resource) {
+		res.(*Content).index()
+	})
+	if err != nil {
+		return saved, err
+	}
+	n, err := spellbook.Resave(ctx, manager.attachments(), nil)
+	return saved + n, err
+}
+
+// RunInTransaction runs fn in the transactions of the repositories of the manager,
+// making the batch requests and the extenders hooks atomic
+func (manager ContentManager) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInTransaction(ctx, fn, manager.contents(), manager.attachments(), manager.revisions(), manager.stateChanges(), manager.redirects(), manager.trash())
+}
@@ -0,0 +1,107 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/memory"
+	"decodica.com/spellbook/spellbooktest"
+	"decodica.com/spellbook/trash"
+	"fmt"
+	"testing"
+)
+
+// returns the suite of the content manager, run in the context
+func contentSuite(manager spellbook.Manager, ctx context.Context) spellbooktest.ManagerSuite {
+	return spellbooktest.ManagerSuite{
+		Manager: manager,
+		Context: ctx,
+		Bundle: func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"type":"page","title":"Content %d","slug":"content-%d","locale":"en"}`, i, i))
+		},
+		UpdateBundle: func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"type":"page","title":"Updated %d","slug":"content-%d","locale":"en","body":"<p>updated</p>"}`, i, i))
+		},
+		Order: "Slug",
+		Filter: func(i int) spellbook.Filter {
+			return spellbook.Filter{Field: "Slug", Operator: spellbook.FilterEqual, Value: fmt.Sprintf("content-%d", i)}
+		},
+		Duplicate: func() []byte {
+			return []byte(`{"type":"page","title":"Duplicate","slug":"content-0","locale":"en"}`)
+		},
+	}
+}
+
+func TestContentManagerSuite(t *testing.T) {
+	user := spellbooktest.NewUser("admin", spellbook.PermissionReadContent, spellbook.PermissionWriteContent)
+	manager := ContentManager{Repository: memory.NewRepository(), Attachments: memory.NewRepository()}
+	contentSuite(manager, spellbooktest.NewContext(user)).Run(t)
+}
+
+// returns a content manager keeping the contents and their history in memory
+func newMemoryManager() ContentManager {
+	// revisions are numbered by content
+	revisions := &memory.Repository{Key: func(res spellbook.Resource) string {
+		revision := res.(*Revision)
+		return revision.ContentKey + "/" + revision.Id()
+	}}
+	return ContentManager{
+		Repository:   memory.NewRepository(),
+		Attachments:  memory.NewRepository(),
+		Revisions:    revisions,
+		StateChanges: memory.NewRepository(),
+		Redirects:    memory.NewRepository(),
+	}
+}
+
+// creates the content of the JSON bundle with the manager
+func newContent(t *testing.T, ctx context.Context, manager ContentManager, bundle string) *Content {
+	t.Helper()
+	content := &Content{}
+	if err := content.FromRepresentation(spellbook.RepresentationTypeJSON, []byte(bundle)); err != nil {
+		t.Fatalf("invalid bundle %s: %s", bundle, err)
+	}
+	if err := manager.Create(ctx, content, []byte(bundle)); err != nil {
+		t.Fatalf("error creating the content %s: %s", bundle, err)
+	}
+	return content
+}
+
+// purges the deleted resource of the sql managers through their trash
+func sqlPurge(restorers map[string]trash.Restorer) func(ctx context.Context, res spellbook.Resource) error {
+	manager := trash.TrashManager{Repository: trash.SqlRepository, Restorers: restorers}
+	return func(ctx context.Context, res spellbook.Resource) error {
+		filters := []spellbook.Filter{{Field: "ResourceId", Operator: spellbook.FilterEqual, Value: res.Id()}}
+		items, err := trash.SqlRepository.ListOf(ctx, spellbook.Query{Filters: filters, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return spellbook.ErrNotFound
+		}
+		return manager.Delete(ctx, items[0])
+	}
+}
+
+func TestSqlContentManagerSuite(t *testing.T) {
+	suite := contentSuite(SqlContentManager{}, newSqlContext(newSqliteDB(t)))
+	suite.Purge = sqlPurge(map[string]trash.Restorer{TrashTypeContent: SqlContentManager{}})
+	suite.Run(t)
+}
+
+func TestSqlAttachmentManagerSuite(t *testing.T) {
+	spellbooktest.ManagerSuite{
+		Manager: SqlAttachmentManager{},
+		Context: newSqlContext(newSqliteDB(t)),
+		Bundle: func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"name":"attachment-%d","parentKey":"%s","resourceUrl":"https://example.com/%d.png"}`, i, AttachmentGlobalParent, i))
+		},
+		UpdateBundle: func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"name":"attachment-%d","parentKey":"%s","resourceUrl":"https://example.com/%d.png","altText":"updated"}`, i, AttachmentGlobalParent, i))
+		},
+		Order: "Name",
+		Purge: sqlPurge(map[string]trash.Restorer{TrashTypeAttachment: SqlAttachmentManager{}}),
+		Filter: func(i int) spellbook.Filter {
+			return spellbook.Filter{Field: "Name", Operator: spellbook.FilterEqual, Value: fmt.Sprintf("attachment-%d", i)}
+		},
+	}.Run(t)
+}
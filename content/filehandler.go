@@ -20,10 +20,19 @@ func (handler fileHandler) HandlePost(ctx context.Context, out *flamel.ResponseO
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
+	extenders := spellbook.ExtendersFromContext(ctx)
+	if err := extenders.BeforeCreate(ctx, res, nil); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
 	if err = handler.Manager.Create(ctx, res, nil); err != nil {
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
+	if err := extenders.AfterCreate(ctx, res, nil); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
 	renderer := flamel.JSONRenderer{}
 	renderer.Data = res
 	out.Renderer = &renderer
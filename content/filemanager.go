@@ -3,7 +3,6 @@ package content
 import (
 	"cloud.google.com/go/storage"
 	"context"
-	"decodica.com/flamel"
 	"decodica.com/spellbook"
 	"errors"
 	"fmt"
@@ -47,13 +46,8 @@ func (manager FileManager) NewResource(ctx context.Context) (spellbook.Resource,
 }
 
 func (manager FileManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionReadContent) && !current.HasPermission(spellbook.PermissionReadMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionReadContent
-		if !current.HasPermission(spellbook.PermissionReadMedia) {
-			p = spellbook.PermissionReadMedia
-		}
-		return nil, spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionReadContent, spellbook.PermissionReadMedia); err != nil {
+		return nil, err
 	}
 
 	bucket, err := manager.BucketName(ctx)
@@ -70,24 +64,31 @@ func (manager FileManager) FromId(ctx context.Context, id string) (spellbook.Res
 }
 
 func (manager FileManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	resources, _, err := manager.ListOfWithCursor(ctx, opts)
+	return resources, err
+}
 
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionReadContent) && !current.HasPermission(spellbook.PermissionReadMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionReadContent
-		if !current.HasPermission(spellbook.PermissionReadMedia) {
-			p = spellbook.PermissionReadMedia
-		}
-		return nil, spellbook.NewPermissionError(spellbook.PermissionName(p))
+// ListOfWithCursor lists the files of the bucket.
+// The cursor wraps the page token of the bucket listing, so that pages don't need to be walked from the start
+func (manager FileManager) ListOfWithCursor(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, string, error) {
+
+	if err := mediaPermission(ctx, spellbook.PermissionReadContent, spellbook.PermissionReadMedia); err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := spellbook.DecodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
 	}
 
 	bucket, err := manager.BucketName(ctx)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	client, err := storage.NewClient(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create client: %s", err.Error())
+		return nil, "", fmt.Errorf("failed to create client: %s", err.Error())
 	}
 	defer client.Close()
 
@@ -99,10 +100,17 @@ func (manager FileManager) ListOf(ctx context.Context, opts spellbook.ListOption
 	q.Versions = false
 
 	it := handle.Objects(ctx, q)
-	objs, err := manager.listPagination(ctx, it, opts)
+	// offset paging of pages other than the first one is kept for backward compatibility
+	var objs []*storage.ObjectAttrs
+	var token string
+	if cursor.Token != "" || opts.Page == 0 {
+		objs, token, err = manager.listToken(ctx, it, opts, cursor.Token)
+	} else {
+		objs, err = manager.listPagination(ctx, it, opts)
+	}
 	if err != nil {
 		log.Errorf(ctx, "listBucket: unable to list bucket %q: %v", bucket, err)
-		return nil, err
+		return nil, "", err
 	}
 	for _, obj := range objs {
 		name := obj.Name
@@ -125,7 +133,12 @@ func (manager FileManager) ListOf(ctx context.Context, opts spellbook.ListOption
 		resources[i] = files[i]
 	}
 
-	return resources, nil
+	next := ""
+	if token != "" {
+		next = spellbook.Cursor{Token: token}.Encode()
+	}
+
+	return resources, next, nil
 }
 
 func (manager FileManager) listPagination(ctx context.Context, it *storage.ObjectIterator, opts spellbook.ListOptions) ([]*storage.ObjectAttrs, error) {
@@ -149,26 +162,33 @@ func (manager FileManager) listPagination(ctx context.Context, it *storage.Objec
 	return objs, nil
 }
 
+// lists the page of objects starting at the given page token.
+// Returns the token of the following page, if any
+func (manager FileManager) listToken(ctx context.Context, it *storage.ObjectIterator, opts spellbook.ListOptions, token string) ([]*storage.ObjectAttrs, string, error) {
+	p := iterator.NewPager(it, opts.Size, token)
+	objs := make([]*storage.ObjectAttrs, 0, 0)
+	next, err := p.NextPage(&objs)
+	if err != nil {
+		return nil, "", err
+	}
+	return objs, next, nil
+}
+
 func (manager FileManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
 	return nil, spellbook.NewUnsupportedError()
 }
 
 func (manager FileManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 
-	if current := spellbook.IdentityFromContext(ctx); current == nil || (!current.HasPermission(spellbook.PermissionWriteContent) && !current.HasPermission(spellbook.PermissionWriteMedia)) {
-		var p spellbook.Permission
-		p = spellbook.PermissionWriteContent
-		if !current.HasPermission(spellbook.PermissionWriteMedia) {
-			p = spellbook.PermissionWriteMedia
-		}
-		return spellbook.NewPermissionError(spellbook.PermissionName(p))
+	if err := mediaPermission(ctx, spellbook.PermissionWriteContent, spellbook.PermissionWriteMedia); err != nil {
+		return err
 	}
 
 	rfile := res.(*File)
 
-	ins := flamel.InputsFromContext(ctx)
+	ins := spellbook.InputsFromContext(ctx)
 
-	tv := spellbook.NewField("type", true, ins)
+	tv := spellbook.NewInputField("type", true, ins)
 	tv.AddValidator(spellbook.FileNameValidator{})
 	typ, err := tv.Value()
 	if err != nil {
@@ -176,7 +196,7 @@ func (manager FileManager) Create(ctx context.Context, res spellbook.Resource, b
 	}
 
 	// namespace is the sub folder where the file will be loaded
-	nsv := spellbook.NewField("namespace", false, ins)
+	nsv := spellbook.NewInputField("namespace", false, ins)
 	nsv.AddValidator(spellbook.FileNameValidator{AllowEmpty: true})
 	namespace, err := nsv.Value()
 	if err != nil {
@@ -188,7 +208,7 @@ func (manager FileManager) Create(ctx context.Context, res spellbook.Resource, b
 		namespace = fmt.Sprintf("/%s", namespace)
 	}
 
-	nv := spellbook.NewField("name", true, ins)
+	nv := spellbook.NewInputField("name", true, ins)
 	nv.AddValidator(spellbook.FileNameValidator{})
 	name, err := nv.Value()
 	if err != nil {
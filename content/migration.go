@@ -0,0 +1,192 @@
+package content
+
+import (
+	"decodica.com/spellbook/sql"
+	"fmt"
+	"github.com/jinzhu/gorm"
+)
+
+// Migrations create the sql schema of the contents, of their history and of their attachments.
+// Tables and indexes are created only if missing, so that databases created by AutoMigrate adopt them
+var Migrations = sql.Migrations{
+	{
+		Version: 2026101801,
+		Name:    "create contents",
+		Up: sql.Steps(
+			sql.CreateTable("contents",
+				sql.Column{Name: "id", Type: sql.TypeSerial},
+				sql.Column{Name: "type", Type: sql.TypeString},
+				sql.Column{Name: "id_translate", Type: sql.TypeString},
+				sql.Column{Name: "slug", Type: sql.TypeString},
+				sql.Column{Name: "title", Type: sql.TypeText},
+				sql.Column{Name: "subtitle", Type: sql.TypeText},
+				sql.Column{Name: "body", Type: sql.TypeText},
+				sql.Column{Name: "tags", Type: sql.TypeText},
+				sql.Column{Name: "category", Type: sql.TypeString},
+				sql.Column{Name: "topic", Type: sql.TypeString},
+				sql.Column{Name: "locale", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "description", Type: sql.TypeText},
+				sql.Column{Name: "cover", Type: sql.TypeText},
+				sql.Column{Name: "revision", Type: sql.TypeInteger},
+				sql.Column{Name: "order", Type: sql.TypeInteger},
+				sql.Column{Name: "author", Type: sql.TypeString},
+				sql.Column{Name: "editor", Type: sql.TypeString},
+				sql.Column{Name: "created", Type: sql.TypeTime},
+				sql.Column{Name: "updated", Type: sql.TypeTime},
+				sql.Column{Name: "published", Type: sql.TypeTime},
+				sql.Column{Name: "publication_state", Type: sql.TypeString},
+				sql.Column{Name: "parent", Type: sql.TypeString},
+				sql.Column{Name: "code", Type: sql.TypeString},
+				sql.Column{Name: "start_date", Type: sql.TypeTime},
+				sql.Column{Name: "end_date", Type: sql.TypeTime},
+			),
+			sql.CreateUniqueIndex("content_idtranslate_locale", "contents", "id_translate", "locale"),
+			sql.CreateUniqueIndex("content_slug", "contents", "slug"),
+			sql.CreateUniqueIndex("content_code_locale", "contents", "code", "locale"),
+		),
+		Down: sql.DropTable("contents"),
+	},
+	{
+		Version: 2026101802,
+		Name:    "create attachments",
+		Up: sql.CreateTable("attachments",
+			sql.Column{Name: "id", Type: sql.TypeSerial},
+			sql.Column{Name: "name", Type: sql.TypeString},
+			sql.Column{Name: "alt_text", Type: sql.TypeText},
+			sql.Column{Name: "description", Type: sql.TypeText},
+			sql.Column{Name: "resource_url", Type: sql.TypeText},
+			sql.Column{Name: "resource_thumb_url", Type: sql.TypeText},
+			sql.Column{Name: "group", Type: sql.TypeString},
+			sql.Column{Name: "type", Type: sql.TypeString},
+			sql.Column{Name: "parent_key", Type: sql.TypeString},
+			sql.Column{Name: "parent_type", Type: sql.TypeString, NotNull: true},
+			sql.Column{Name: "parent_id", Type: sql.TypeInteger},
+			sql.Column{Name: "display_order", Type: sql.TypeInteger},
+			sql.Column{Name: "created", Type: sql.TypeTime},
+			sql.Column{Name: "updated", Type: sql.TypeTime},
+			sql.Column{Name: "uploader", Type: sql.TypeString},
+		),
+		Down: sql.DropTable("attachments"),
+	},
+	{
+		Version: 2026101804,
+		Name:    "attachments parent foreign key",
+		Up: sql.Steps(
+			// attachments of missing contents keep their parent key, but not the reference
+			func(tx *gorm.DB) error {
+				return tx.Exec(fmt.Sprintf("UPDATE %[1]s SET %[2]s = NULL WHERE %[2]s IS NOT NULL AND %[2]s NOT IN (SELECT %[3]s FROM %[4]s)",
+					sql.Quote(tx, "attachments"), sql.Quote(tx, "parent_id"), sql.Quote(tx, "id"), sql.Quote(tx, "contents"))).Error
+			},
+			sql.AddForeignKey("attachments_parent_id_fkey", "attachments", "parent_id", "contents", "id"),
+		),
+		Down: sql.DropForeignKey("attachments_parent_id_fkey", "attachments"),
+	},
+	{
+		Version: 2026101806,
+		Name:    "create content revisions",
+		Up: sql.Steps(
+			sql.CreateTable("content_revisions",
+				sql.Column{Name: "id", Type: sql.TypeSerial},
+				sql.Column{Name: "content_key", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "number", Type: sql.TypeInteger},
+				sql.Column{Name: "data", Type: sql.TypeText},
+				sql.Column{Name: "editor", Type: sql.TypeString},
+				sql.Column{Name: "created", Type: sql.TypeTime},
+			),
+			sql.CreateUniqueIndex("content_revision_number", "content_revisions", "content_key", "number"),
+		),
+		Down: sql.DropTable("content_revisions"),
+	},
+	{
+		Version: 2026101807,
+		Name:    "create content state changes",
+		Up: sql.CreateTable("content_state_changes",
+			sql.Column{Name: "id", Type: sql.TypeSerial},
+			sql.Column{Name: "content_key", Type: sql.TypeString, NotNull: true},
+			sql.Column{Name: "transition", Type: sql.TypeString},
+			sql.Column{Name: "from", Type: sql.TypeString},
+			sql.Column{Name: "to", Type: sql.TypeString},
+			sql.Column{Name: "user", Type: sql.TypeString},
+			sql.Column{Name: "reason", Type: sql.TypeText},
+			sql.Column{Name: "created", Type: sql.TypeTime},
+		),
+		Down: sql.DropTable("content_state_changes"),
+	},
+	{
+		Version: 2026101808,
+		Name:    "content publication schedule",
+		Up: sql.Steps(
+			sql.AddColumn("contents", sql.Column{Name: "publish_at", Type: sql.TypeTime}),
+			sql.AddColumn("contents", sql.Column{Name: "unpublish_at", Type: sql.TypeTime}),
+		),
+		Down: sql.Steps(
+			sql.DropColumn("contents", "publish_at"),
+			sql.DropColumn("contents", "unpublish_at"),
+		),
+	},
+	{
+		Version: 2026101809,
+		Name:    "content slugs unique by locale",
+		Up: sql.Steps(
+			sql.DropIndex("content_slug", "contents"),
+			sql.CreateUniqueIndex("content_slug_locale", "contents", "slug", "locale"),
+		),
+		Down: sql.Steps(
+			sql.DropIndex("content_slug_locale", "contents"),
+			sql.CreateUniqueIndex("content_slug", "contents", "slug"),
+		),
+	},
+	{
+		Version: 2026101810,
+		Name:    "create content slug redirects",
+		Up: sql.Steps(
+			sql.CreateTable("content_slug_redirects",
+				sql.Column{Name: "id", Type: sql.TypeSerial},
+				sql.Column{Name: "locale", Type: sql.TypeString},
+				sql.Column{Name: "from", Type: sql.TypeString},
+				sql.Column{Name: "to", Type: sql.TypeString},
+				sql.Column{Name: "content_key", Type: sql.TypeString},
+				sql.Column{Name: "created", Type: sql.TypeTime},
+			),
+			sql.CreateUniqueIndex("content_slug_redirect", "content_slug_redirects", "locale", "from"),
+		),
+		Down: sql.DropTable("content_slug_redirects"),
+	},
+	{
+		Version: 2026101811,
+		Name:    "content full-text search",
+		Up:      searchIndex,
+		Down:    dropSearchIndex,
+	},
+	{
+		Version: 2026101812,
+		Name:    "soft delete contents and attachments",
+		Up: sql.Steps(
+			sql.AddColumn("contents", sql.Column{Name: "deleted_at", Type: sql.TypeTime}),
+			sql.AddColumn("attachments", sql.Column{Name: "deleted_at", Type: sql.TypeTime}),
+		),
+		Down: sql.Steps(
+			sql.DropColumn("contents", "deleted_at"),
+			sql.DropColumn("attachments", "deleted_at"),
+		),
+	},
+	{
+		Version: 2026101813,
+		Name:    "attachments foreign key of content parents",
+		// only the attachments of contents reference them, see Attachment.setParent.
+		// The contents delete their attachments by default, see CascadeDelete: the managers detach
+		// or keep the attachments of the other policies before a content row is deleted
+		Up: sql.Steps(
+			sql.DropForeignKey("attachments_parent_id_fkey", "attachments"),
+			func(tx *gorm.DB) error {
+				return tx.Exec(fmt.Sprintf("UPDATE %[1]s SET %[2]s = NULL WHERE %[3]s <> ?",
+					sql.Quote(tx, "attachments"), sql.Quote(tx, "parent_id"), sql.Quote(tx, "parent_type")), AttachmentParentTypeContent).Error
+			},
+			sql.AddForeignKeyOnDelete("attachments_parent_id_fkey", "attachments", "parent_id", "contents", "id", "CASCADE"),
+		),
+		Down: sql.Steps(
+			sql.DropForeignKey("attachments_parent_id_fkey", "attachments"),
+			sql.AddForeignKey("attachments_parent_id_fkey", "attachments", "parent_id", "contents", "id"),
+		),
+	},
+}
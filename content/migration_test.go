@@ -0,0 +1,180 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"decodica.com/spellbook/sql"
+	"decodica.com/spellbook/trash"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"testing"
+)
+
+// migrations of the schema the sql managers of the contents run on
+var sqlMigrations = append(append(sql.Migrations{}, Migrations...), trash.Migrations...)
+
+// returns an in-memory sqlite database with the schema of the migrations
+func newSqliteDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("error opening sqlite: %s", err)
+	}
+	// every connection opens a database of its own
+	db.DB().SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	if err := sqlMigrations.Up(db); err != nil {
+		t.Fatalf("error migrating: %s", err)
+	}
+	return db
+}
+
+// returns a context running the sql managers on the database, as a user that can write the contents and the trash
+func newSqlContext(db *gorm.DB) context.Context {
+	user := spellbooktest.NewUser("admin", spellbook.PermissionReadContent, spellbook.PermissionWriteContent,
+		spellbook.PermissionReadMedia, spellbook.PermissionWriteMedia, spellbook.PermissionReadTrash, spellbook.PermissionWriteTrash)
+	return sql.NewContext(spellbooktest.NewContext(user), db)
+}
+
+func TestMigrationsSqlite(t *testing.T) {
+	db := newSqliteDB(t)
+
+	applied, err := sqlMigrations.Applied(db)
+	if err != nil {
+		t.Fatalf("error reading the applied migrations: %s", err)
+	}
+	if len(applied) != len(sqlMigrations) {
+		t.Errorf("got %d applied migrations, want %d", len(applied), len(sqlMigrations))
+	}
+
+	columns := map[string][]string{
+		"contents":               {"id", "slug", "locale", "parent", "publish_at", "deleted_at"},
+		"attachments":            {"id", "parent_key", "parent_id", "deleted_at"},
+		"content_revisions":      {"id", "content_key"},
+		"content_state_changes":  {"id", "content_key"},
+		"content_slug_redirects": {"id", "from", "to"},
+	}
+	for table, names := range columns {
+		for _, name := range names {
+			if !db.Dialect().HasColumn(table, name) {
+				t.Errorf("column %s of table %s is missing", name, table)
+			}
+		}
+	}
+	var indexes int
+	if err := db.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = ?", "content_slug_locale").Row().Scan(&indexes); err != nil || indexes != 1 {
+		t.Errorf("got %d unique indexes of the slugs and error %v, want 1", indexes, err)
+	}
+	// SQLite can't add the foreign key of the attachments to the existing table: the managers delete them with their contents
+	if db.Dialect().HasForeignKey("attachments", "attachments_parent_id_fkey") {
+		t.Error("the foreign key of the attachments has been added on sqlite")
+	}
+
+	// applying them again does nothing
+	if err := sqlMigrations.Up(db); err != nil {
+		t.Fatalf("error applying the migrations again: %s", err)
+	}
+
+	if err := sqlMigrations.Down(db, 0); err != nil {
+		t.Fatalf("error reverting the migrations: %s", err)
+	}
+	for table := range columns {
+		if db.Dialect().HasTable(table) {
+			t.Errorf("table %s has not been dropped", table)
+		}
+	}
+	if err := sqlMigrations.Up(db); err != nil {
+		t.Fatalf("error applying the reverted migrations: %s", err)
+	}
+}
+
+func TestSqlManagersSqlite(t *testing.T) {
+	ctx := newSqlContext(newSqliteDB(t))
+	manager := SqlContentManager{}
+
+	content := &Content{Type: "page", Title: "Hello world", Locale: "en", Body: "<p>first</p>"}
+	if err := manager.Create(ctx, content, nil); err != nil {
+		t.Fatalf("error creating the content: %s", err)
+	}
+	id := content.Id()
+
+	attachment := &Attachment{Name: "cover", ParentType: AttachmentParentTypeContent, ParentKey: id, ResourceUrl: "https://example.com/cover.png"}
+	if err := (SqlAttachmentManager{}).Create(ctx, attachment, nil); err != nil {
+		t.Fatalf("error creating the attachment: %s", err)
+	}
+
+	revisions := func() int {
+		t.Helper()
+		count, err := sqlRevisionRepository.Count(ctx, spellbook.Query{Filters: []spellbook.Filter{{Field: "ContentKey", Operator: spellbook.FilterEqual, Value: id}}})
+		if err != nil {
+			t.Fatalf("error counting the revisions: %s", err)
+		}
+		return count
+	}
+
+	res, err := manager.FromId(ctx, id)
+	if err != nil {
+		t.Fatalf("error retrieving the content: %s", err)
+	}
+	stored := res.(*Content)
+	if stored.getSlug() != "hello-world" || len(stored.Attachments) != 1 {
+		t.Errorf("got slug %q and %d attachments, want hello-world and 1", stored.getSlug(), len(stored.Attachments))
+	}
+
+	bundle := []byte(`{"type":"page","title":"Hello world","locale":"en","body":"<p>second</p>","attachments":[{"id":"` + attachment.Id() + `","name":"cover","resourceUrl":"https://example.com/cover.png"}]}`)
+	if err := manager.Update(ctx, stored, bundle); err != nil {
+		t.Fatalf("error updating the content: %s", err)
+	}
+	if count := revisions(); count != 1 {
+		t.Errorf("got %d revisions, want the replaced revision", count)
+	}
+
+	if err := manager.Delete(ctx, stored); err != nil {
+		t.Fatalf("error deleting the content: %s", err)
+	}
+	if _, err := manager.FromId(ctx, id); !spellbook.IsNotFound(err) {
+		t.Fatalf("deleted content: got error %v, want not found", err)
+	}
+
+	trashManager := trash.TrashManager{Repository: trash.SqlRepository, Restorers: map[string]trash.Restorer{TrashTypeContent: manager}}
+	items, err := trashManager.ListOf(ctx, spellbook.ListOptions{Size: 10})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("got %d trash items and error %v, want the deleted content", len(items), err)
+	}
+	item := items[0].(*trash.Item)
+
+	if err := trashManager.Update(ctx, item, []byte(`{"restore":true}`)); err != nil {
+		t.Fatalf("error restoring the content: %s", err)
+	}
+	res, err = manager.FromId(ctx, id)
+	if err != nil {
+		t.Fatalf("restored content %s: %s", id, err)
+	}
+	if restored := res.(*Content); len(restored.Attachments) != 1 || restored.Body != "<p>second</p>" {
+		t.Errorf("restored content has body %q and %d attachments", restored.Body, len(restored.Attachments))
+	}
+	if count := revisions(); count != 1 {
+		t.Errorf("restored content has %d revisions, want its history", count)
+	}
+
+	if err := manager.Delete(ctx, res); err != nil {
+		t.Fatalf("error deleting the content again: %s", err)
+	}
+	items, _ = trashManager.ListOf(ctx, spellbook.ListOptions{Size: 10})
+	if len(items) != 1 {
+		t.Fatalf("got %d trash items, want the deleted content", len(items))
+	}
+	if err := trashManager.Delete(ctx, items[0]); err != nil {
+		t.Fatalf("error purging the content: %s", err)
+	}
+	if _, err := manager.content().trashed(ctx, items[0].(*trash.Item)); !spellbook.IsNotFound(err) {
+		t.Errorf("purged content: got error %v, want not found", err)
+	}
+	if count := revisions(); count != 0 {
+		t.Errorf("purged content has %d revisions left", count)
+	}
+}
@@ -0,0 +1,268 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// reasons an attachment is reported as orphan
+const (
+	OrphanReasonMissingParent     = "missing parent"
+	OrphanReasonUnsupportedParent = "unsupported parent type"
+)
+
+// OrphanAttachment is an attachment whose parent doesn't exist or can't have attachments
+type OrphanAttachment struct {
+	Attachment *Attachment `json:"attachment"`
+	Reason     string      `json:"reason"`
+}
+
+func (orphan *OrphanAttachment) Id() string {
+	return orphan.Attachment.Id()
+}
+
+func (orphan *OrphanAttachment) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(orphan)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (orphan *OrphanAttachment) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// NewOrphanAttachmentController returns the admin controller reporting the orphan attachments of the datastore
+func NewOrphanAttachmentController() *spellbook.RestController {
+	return spellbook.NewRestController(spellbook.BaseRestHandler{Manager: OrphanAttachmentManager{}})
+}
+
+// NewSqlOrphanAttachmentController returns the admin controller reporting the orphan attachments of the sql database
+func NewSqlOrphanAttachmentController() *spellbook.RestController {
+	manager := OrphanAttachmentManager{Contents: sqlContentRepository, Attachments: sqlAttachmentRepository}
+	return spellbook.NewRestController(spellbook.BaseRestHandler{Manager: manager})
+}
+
+// OrphanAttachmentManager lists the attachments whose parent is missing.
+// The parents of the contents are looked up in the Contents repository,
+// the parents of the other types through the ParentResolver of the attachment service, if any.
+// The zero value reads the datastore
+type OrphanAttachmentManager struct {
+	Contents    spellbook.Repository
+	Attachments spellbook.Repository
+}
+
+// number of attachments read at once while looking for the orphans
+const orphanScanSize = 100
+
+func (manager OrphanAttachmentManager) contents() spellbook.Repository {
+	if manager.Contents == nil {
+		return contentRepository{}
+	}
+	return manager.Contents
+}
+
+func (manager OrphanAttachmentManager) attachments() spellbook.Repository {
+	if manager.Attachments == nil {
+		return attachmentRepository{}
+	}
+	return manager.Attachments
+}
+
+func (manager OrphanAttachmentManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager OrphanAttachmentManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// ListOf returns the page of the orphan attachments, see ListOfWithCursor
+func (manager OrphanAttachmentManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	resources, _, err := manager.ListOfWithCursor(ctx, opts)
+	return resources, err
+}
+
+// ListOfWithCursor scans the attachments by creation time, returning the page of the orphan ones.
+// The cursor resumes the scan after the last orphan returned, while the pages requested without one
+// scan the attachments from the first, skipping the orphans of the previous pages
+func (manager OrphanAttachmentManager) ListOfWithCursor(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, string, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadMedia) {
+		return nil, "", spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadMedia))
+	}
+
+	opts.Order = "Created"
+	opts.Descending = false
+	cursor, err := spellbook.DecodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := spellbook.Query{Order: opts.Order, Limit: orphanScanSize}
+	skip := 0
+	if cursor.IsKeyset() {
+		if query.Start, err = cursor.OrderValue(&Attachment{}); err != nil {
+			return nil, "", err
+		}
+	} else {
+		skip = opts.Page * opts.Size
+	}
+
+	// orphans of the previous page created at the start of the scan
+	scanned := make(map[string]bool, len(cursor.Ids))
+	for _, id := range cursor.Ids {
+		scanned[id] = true
+	}
+
+	// get one more so we know if we are done
+	var orphans []*OrphanAttachment
+	for len(orphans) < opts.Size+1 {
+		resources, err := manager.attachments().ListOf(ctx, query)
+		if err != nil {
+			log.Errorf(ctx, "error retrieving attachments: %s", err.Error())
+			return nil, "", err
+		}
+
+		var batch []*Attachment
+		for _, res := range resources {
+			if !scanned[res.Id()] {
+				batch = append(batch, res.(*Attachment))
+			}
+		}
+
+		reasons, err := manager.orphanReasons(ctx, batch)
+		if err != nil {
+			log.Errorf(ctx, "error retrieving the parents of the attachments: %s", err.Error())
+			return nil, "", err
+		}
+		for i, att := range batch {
+			if reasons[i] == "" {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			orphans = append(orphans, &OrphanAttachment{Attachment: att, Reason: reasons[i]})
+			if len(orphans) == opts.Size+1 {
+				break
+			}
+		}
+
+		if len(resources) < orphanScanSize {
+			break
+		}
+
+		// the next batch starts from the creation time of the last attachment,
+		// after the ones created at that time which have already been scanned
+		last := resources[len(resources)-1].(*Attachment).Created
+		same := 0
+		for _, res := range resources {
+			if res.(*Attachment).Created.Equal(last) {
+				same++
+			}
+		}
+		if start, ok := query.Start.(time.Time); ok && start.Equal(last) {
+			query.Offset += same
+		} else {
+			query.Offset = same
+		}
+		query.Start = last
+	}
+
+	// the cursor points to the last orphan of the page, by the attachment it reports
+	page := make([]spellbook.Resource, len(orphans))
+	attachments := make([]spellbook.Resource, len(orphans))
+	for i := range orphans {
+		page[i] = orphans[i]
+		attachments[i] = orphans[i].Attachment
+	}
+	next, err := spellbook.NextCursor(cursor, attachments, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// returns why each attachment is an orphan, or an empty string for the ones that aren't.
+// The parent contents of the attachments are read at once
+func (manager OrphanAttachmentManager) orphanReasons(ctx context.Context, attachments []*Attachment) ([]string, error) {
+	reasons := make([]string, len(attachments))
+
+	var keys []string
+	parents := map[string]bool{}
+	for _, att := range attachments {
+		if att.ParentKey != AttachmentGlobalParent && att.ParentType == AttachmentParentTypeContent && !parents[att.ParentKey] {
+			parents[att.ParentKey] = false
+			keys = append(keys, att.ParentKey)
+		}
+	}
+	if len(keys) > 0 {
+		contents, err := spellbook.FromIds(ctx, manager.contents(), keys)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			parents[key] = contents[i] != nil
+		}
+	}
+
+	for i, att := range attachments {
+		reason, err := manager.orphanReason(ctx, att, parents)
+		if err != nil {
+			return nil, err
+		}
+		reasons[i] = reason
+	}
+	return reasons, nil
+}
+
+// returns why the attachment is an orphan, or an empty string if it isn't.
+// contents tells if the parent contents of the attachments exist
+func (manager OrphanAttachmentManager) orphanReason(ctx context.Context, att *Attachment, contents map[string]bool) (string, error) {
+	if att.ParentKey == AttachmentGlobalParent {
+		return "", nil
+	}
+
+	if sa := SupportedAttachmentsFromContext(ctx); sa != nil && !sa.IsSupported(att) {
+		return OrphanReasonUnsupportedParent, nil
+	}
+
+	if att.ParentType == AttachmentParentTypeContent {
+		if !contents[att.ParentKey] {
+			return OrphanReasonMissingParent, nil
+		}
+		return "", nil
+	}
+
+	if resolver, ok := parentDeclaration.(ParentResolver); ok {
+		exists, err := resolver.ParentExists(ctx, att.ParentType, att.ParentKey)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return OrphanReasonMissingParent, nil
+		}
+	}
+	return "", nil
+}
+
+func (manager OrphanAttachmentManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager OrphanAttachmentManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager OrphanAttachmentManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager OrphanAttachmentManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
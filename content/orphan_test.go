@@ -0,0 +1,68 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/memory"
+	"decodica.com/spellbook/spellbooktest"
+	"testing"
+	"time"
+)
+
+func TestOrphanAttachmentManager(t *testing.T) {
+	user := spellbooktest.NewUser("admin", spellbook.PermissionReadMedia)
+	ctx := spellbooktest.NewContext(user)
+	contents := memory.NewRepository()
+	attachments := memory.NewRepository()
+	manager := OrphanAttachmentManager{Contents: contents, Attachments: attachments}
+
+	parent := &Content{Title: "Parent", Slug: "parent"}
+	if err := contents.Create(ctx, parent); err != nil {
+		t.Fatalf("error creating the parent: %s", err)
+	}
+
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, parentKey := range []string{parent.Id(), "999", AttachmentGlobalParent, "998"} {
+		att := &Attachment{Name: parentKey, ParentType: AttachmentParentTypeContent, ParentKey: parentKey, Created: created.Add(time.Duration(i) * time.Hour)}
+		if err := attachments.Create(ctx, att); err != nil {
+			t.Fatalf("error creating the attachment: %s", err)
+		}
+	}
+
+	resources, err := manager.ListOf(ctx, spellbook.ListOptions{Size: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("got %d orphans, want 2", len(resources))
+	}
+	for i, name := range []string{"999", "998"} {
+		orphan := resources[i].(*OrphanAttachment)
+		if orphan.Attachment.Name != name || orphan.Reason != OrphanReasonMissingParent {
+			t.Errorf("got orphan %s (%s), want %s", orphan.Attachment.Name, orphan.Reason, name)
+		}
+	}
+
+	// the cursor pages through the same orphans, each page holding one more to tell if there are others
+	var names []string
+	cursor := ""
+	for {
+		page, next, err := manager.ListOfWithCursor(ctx, spellbook.ListOptions{Size: 1, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(page) > 0 {
+			names = append(names, page[0].(*OrphanAttachment).Attachment.Name)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(names) != 2 || names[0] != "999" || names[1] != "998" {
+		t.Errorf("got the orphans %v by cursor, want [999 998]", names)
+	}
+
+	if _, err := manager.ListOf(spellbooktest.NewContext(nil), spellbook.ListOptions{Size: 10}); err == nil {
+		t.Error("the orphans were listed without the media permission")
+	}
+}
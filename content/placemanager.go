@@ -27,6 +27,28 @@ func NewPlaceControllerWithKey(key string) *spellbook.RestController {
 
 type PlaceManager struct{}
 
+// fields the places can be filtered by
+var placeFilterFields = spellbook.FilterFields{
+	"Name":       spellbook.FieldString,
+	"Area":       spellbook.FieldString,
+	"City":       spellbook.FieldString,
+	"PostalCode": spellbook.FieldString,
+	"Country":    spellbook.FieldString,
+	"Created":    spellbook.FieldTime,
+	"Updated":    spellbook.FieldTime,
+}
+
+// Describe describes the places for the OpenAPI document
+func (manager PlaceManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "Place",
+		Filterable:       placeFilterFields,
+		Orderable:        []string{"Name", "City", "Created", "Updated"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadPlace},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWritePlace},
+	}
+}
+
 func (manager PlaceManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &Place{}, nil
 }
@@ -56,37 +78,32 @@ func (manager PlaceManager) ListOf(ctx context.Context, opts spellbook.ListOptio
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadPlace))
 	}
 
-	var places []*Place
-	q := model.NewQuery(&Place{})
-	q = q.OffsetBy(opts.Page * opts.Size)
+	query := spellbook.QueryFromOptions(opts)
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+	return manager.list(ctx, query)
+}
 
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
+// lists the places of the query, see spellbook.DatastoreMerged
+func (manager PlaceManager) list(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreMerged(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		q, err := spellbook.DatastoreQuery(model.NewQuery(&Place{}), query, placeFilterFields)
+		if err != nil {
+			return nil, err
 		}
-		q = q.OrderBy(opts.Order, dir)
-	}
 
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
+		var places []*Place
+		if err := q.GetMulti(ctx, &places); err != nil {
+			return nil, err
 		}
-	}
-
-	// get one more so we know if we are done
-	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &places)
-	if err != nil {
-		return nil, err
-	}
 
-	resources := make([]spellbook.Resource, len(places))
-	for i := range places {
-		resources[i] = places[i]
-	}
-
-	return resources, nil
+		resources := make([]spellbook.Resource, len(places))
+		for i := range places {
+			resources[i] = places[i]
+		}
+		return resources, nil
+	})
 }
 
 func (manager PlaceManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
@@ -105,26 +122,15 @@ func (manager PlaceManager) ListOfProperties(ctx context.Context, opts spellbook
 	}
 
 	var conts []*Place
-	q := model.NewQuery(&Place{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	q, err := manager.query(opts)
+	if err != nil {
+		return nil, err
 	}
+	q = q.OffsetBy(opts.Page * opts.Size)
 
 	q = q.Distinct(name)
 	q = q.Limit(opts.Size + 1)
-	err := q.GetAll(ctx, &conts)
+	err = q.GetAll(ctx, &conts)
 	if err != nil {
 		log.Errorf(ctx, "Error retrieving result: %+v", err)
 		return nil, err
@@ -139,6 +145,35 @@ func (manager PlaceManager) ListOfProperties(ctx context.Context, opts spellbook
 	return result, nil
 }
 
+// builds the query of the places matching the order and the filters of the options
+func (manager PlaceManager) query(opts spellbook.ListOptions) (*model.Query, error) {
+	q := model.NewQuery(&Place{})
+
+	if opts.Order != "" {
+		dir := model.ASC
+		if opts.Descending {
+			dir = model.DESC
+		}
+		q = q.OrderBy(opts.Order, dir)
+	}
+
+	return spellbook.FilterQuery(q, placeFilterFields, opts.Filters)
+}
+
+// Count returns the number of places matching the filters of the options
+func (manager PlaceManager) Count(ctx context.Context, opts spellbook.ListOptions) (int, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadPlace) {
+		return 0, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadPlace))
+	}
+
+	build := func(query spellbook.Query) (*model.Query, error) {
+		return spellbook.DatastoreQuery(model.NewQuery(&Place{}), query, placeFilterFields)
+	}
+	return spellbook.DatastoreCount(ctx, spellbook.QueryFromOptions(opts), build, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return manager.list(ctx, query)
+	})
+}
+
 func (manager PlaceManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 	current := spellbook.IdentityFromContext(ctx)
 	if current == nil || !current.HasPermission(spellbook.PermissionWritePlace) {
@@ -186,7 +221,7 @@ func (manager PlaceManager) Update(ctx context.Context, res spellbook.Resource,
 	place.Position = other.Position
 	place.Phone = other.Phone
 	place.Website = other.Website
-	place.Updated = time.Now().UTC()
+	place.Updated = updateTime()
 
 	if place.Address == "" || !place.Position.Valid() {
 		return spellbook.NewFieldError("address", errors.New("address and position can't be empty"))
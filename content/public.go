@@ -0,0 +1,172 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// NewPublicContentController returns the read only controller of the visible contents of the datastore
+func NewPublicContentController() *spellbook.RestController {
+	return NewPublicContentControllerWithKey("")
+}
+
+func NewPublicContentControllerWithKey(key string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: PublicContentManager{}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+// NewSqlPublicContentController returns the read only controller of the visible contents of the sql database
+func NewSqlPublicContentController() *spellbook.RestController {
+	return NewSqlPublicContentControllerWithKey("")
+}
+
+func NewSqlPublicContentControllerWithKey(key string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: PublicContentManager{Content: SqlContentManager{}.content()}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+// PublicContentManager reads the contents of the Content manager that are visible now, see Content.IsVisible.
+// It requires no permission and is the read path of the public sites.
+// The zero Content manager reads the datastore
+type PublicContentManager struct {
+	Content ContentManager
+}
+
+// Describe describes the public contents for the OpenAPI document
+func (manager PublicContentManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:       "PublicContent",
+		Filterable: contentFilterFields,
+		Orderable:  []string{"Order", "Title", "Created", "Updated", "Published"},
+	}
+}
+
+func (manager PublicContentManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// FromId returns the content if it is visible, as if it didn't exist otherwise
+func (manager PublicContentManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	res, err := manager.Content.contents().FromId(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	content := res.(*Content)
+	if !content.IsVisible(time.Now().UTC()) {
+		return nil, spellbook.ErrNotFound
+	}
+
+	content.Attachments, err = manager.Content.attachmentsOf(ctx, content)
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve content %s attachments: %s", id, err.Error())
+		return nil, err
+	}
+	return content, nil
+}
+
+// FromSlug returns the visible content with the slug in the locale, see FromId
+func (manager PublicContentManager) FromSlug(ctx context.Context, locale string, slug string) (*Content, error) {
+	resources, err := manager.Content.contents().ListOf(ctx, spellbook.Query{Filters: []spellbook.Filter{
+		{Field: "Slug", Operator: spellbook.FilterEqual, Value: slug},
+		{Field: "Locale", Operator: spellbook.FilterEqual, Value: locale},
+	}, Limit: 1})
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve content %s: %s", slug, err.Error())
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, spellbook.ErrNotFound
+	}
+
+	content, err := manager.FromId(ctx, resources[0].Id())
+	if err != nil {
+		return nil, err
+	}
+	return content.(*Content), nil
+}
+
+// ListOf returns the page of the visible contents, see ListOfWithCursor
+func (manager PublicContentManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	resources, _, err := manager.ListOfWithCursor(ctx, opts)
+	return resources, err
+}
+
+// ListOfWithCursor reads the page of the published contents, paging them by cursor if one is provided.
+// The query skips the contents to be published later when its filters and order allow an inequality on PublishAt,
+// the ones past their UnpublishAt are dropped from the page: until the Scheduler unpublishes them pages can be shorter
+func (manager PublicContentManager) ListOfWithCursor(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, string, error) {
+	now := time.Now().UTC()
+
+	query, cursor, err := spellbook.PageQuery(opts, &Content{})
+	if err != nil {
+		return nil, "", err
+	}
+	if filtersPublishAt(query) {
+		query.Filters = append(query.Filters, spellbook.Filter{Field: "PublishAt", Operator: spellbook.FilterLessOrEqual, Value: now.Format(time.RFC3339)})
+	}
+	query.Filters = append(query.Filters, spellbook.Filter{Field: "PublicationState", Operator: spellbook.FilterEqual, Value: string(PublicationStatePublished)})
+
+	resources, err := manager.Content.contents().ListOf(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving contents: %s", err.Error())
+		return nil, "", err
+	}
+
+	resources, next, err := cursor.Page(resources, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	// the next page starts after the last content read, visible or not
+	if next != "" && len(resources) > opts.Size {
+		resources = resources[:opts.Size]
+	}
+
+	visible := make([]spellbook.Resource, 0, len(resources))
+	for _, res := range resources {
+		if res.(*Content).IsVisible(now) {
+			visible = append(visible, res)
+		}
+	}
+	return visible, next, nil
+}
+
+// reports if the query can filter PublishAt by inequality too:
+// the datastore applies inequalities to a single field, which must be the order one
+func filtersPublishAt(query spellbook.Query) bool {
+	if query.Order != "" && query.Order != "PublishAt" {
+		return false
+	}
+	for _, filter := range query.Filters {
+		switch filter.Operator {
+		case "", spellbook.FilterEqual, spellbook.FilterIn, spellbook.FilterNull:
+		default:
+			if filter.Field != "PublishAt" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (manager PublicContentManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager PublicContentManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager PublicContentManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager PublicContentManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
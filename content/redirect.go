@@ -0,0 +1,350 @@
+package content
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"time"
+)
+
+// SlugRedirect maps a past slug of a content to its current one, in the locale of the content.
+// Redirects are recorded by the ContentManager on every slug change
+type SlugRedirect struct {
+	model.Model `json:"-"`
+	ID          uint   `model:"-" json:"-"`
+	Locale      string `gorm:"UNIQUE_INDEX:content_slug_redirect"`
+	From        string `gorm:"UNIQUE_INDEX:content_slug_redirect"`
+	To          string
+	ContentKey  string
+	Created     time.Time
+}
+
+func (redirect SlugRedirect) TableName() string {
+	return "content_slug_redirects"
+}
+
+func (redirect *SlugRedirect) Id() string {
+	if id := redirect.EncodedKey(); id != "" {
+		return id
+	}
+	return fmt.Sprintf("%d", redirect.ID)
+}
+
+func (redirect *SlugRedirect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Id      string    `json:"id"`
+		Locale  string    `json:"locale"`
+		From    string    `json:"from"`
+		To      string    `json:"to"`
+		Content string    `json:"content"`
+		Created time.Time `json:"created"`
+	}{
+		Id:      redirect.Id(),
+		Locale:  redirect.Locale,
+		From:    redirect.From,
+		To:      redirect.To,
+		Content: redirect.ContentKey,
+		Created: redirect.Created,
+	})
+}
+
+func (redirect *SlugRedirect) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(redirect)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// redirects are recorded by the content manager only
+func (redirect *SlugRedirect) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// fields the redirects can be filtered by
+var slugRedirectFilterFields = spellbook.FilterFields{
+	"Locale":     spellbook.FieldString,
+	"From":       spellbook.FieldString,
+	"To":         spellbook.FieldString,
+	"ContentKey": spellbook.FieldString,
+	"Created":    spellbook.FieldTime,
+}
+
+// returns the redirects of the locale with the given field equal to the slug
+func (manager ContentManager) redirectsOf(ctx context.Context, locale string, field string, slug string) ([]*SlugRedirect, error) {
+	resources, err := manager.redirects().ListOf(ctx, spellbook.Query{Filters: []spellbook.Filter{
+		{Field: "Locale", Operator: spellbook.FilterEqual, Value: locale},
+		{Field: field, Operator: spellbook.FilterEqual, Value: slug},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	redirects := make([]*SlugRedirect, len(resources))
+	for i := range resources {
+		redirects[i] = resources[i].(*SlugRedirect)
+	}
+	return redirects, nil
+}
+
+// Redirect returns the redirect of the past slug in the locale, ErrNotFound if the slug has never been moved
+func (manager ContentManager) Redirect(ctx context.Context, locale string, slug string) (*SlugRedirect, error) {
+	if manager.redirects() == nil {
+		return nil, spellbook.ErrNotFound
+	}
+
+	redirects, err := manager.redirectsOf(ctx, locale, "From", slug)
+	if err != nil {
+		return nil, err
+	}
+	if len(redirects) == 0 {
+		return nil, spellbook.ErrNotFound
+	}
+	return redirects[0], nil
+}
+
+// returns the function storing the redirect of the content moved from the slug in the locale to its current slug.
+// Redirects to the old slug are moved to the new one, so that no redirect chains are served,
+// and the redirects from the new slug are dropped, since the slug is live again.
+// The redirects are read here and written by the returned function, which can run in a transaction
+func (manager ContentManager) moved(ctx context.Context, content *Content, locale string, from string) (func(ctx context.Context) error, error) {
+	if manager.redirects() == nil {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	to := content.getSlug()
+	chained, err := manager.redirectsOf(ctx, locale, "To", from)
+	if err != nil {
+		return nil, err
+	}
+	live, err := manager.redirectsOf(ctx, content.Locale, "From", to)
+	if err != nil {
+		return nil, err
+	}
+	// the old slug may have been moved before by another content
+	existing, err := manager.redirectsOf(ctx, locale, "From", from)
+	if err != nil {
+		return nil, err
+	}
+
+	redirect := &SlugRedirect{Locale: locale, From: from}
+	if len(existing) > 0 {
+		redirect = existing[0]
+	}
+	redirect.To = to
+	redirect.ContentKey = content.Id()
+	redirect.Created = time.Now().UTC()
+
+	return func(ctx context.Context) error {
+		for _, r := range live {
+			if err := manager.redirects().Delete(ctx, r); err != nil {
+				return fmt.Errorf("error deleting redirect from %s: %s", r.From, err.Error())
+			}
+		}
+
+		for _, r := range chained {
+			if r.Locale == content.Locale && r.From == to {
+				continue
+			}
+			r.To = to
+			if err := manager.redirects().Update(ctx, r); err != nil {
+				return fmt.Errorf("error moving redirect from %s: %s", r.From, err.Error())
+			}
+		}
+
+		if len(existing) > 0 {
+			return manager.redirects().Update(ctx, redirect)
+		}
+		return manager.redirects().Create(ctx, redirect)
+	}, nil
+}
+
+// deletes the redirects to a deleted content, the ones left behind by a failure lead to a missing content
+func (manager ContentManager) deleteRedirects(ctx context.Context, content *Content) {
+	if manager.redirects() == nil {
+		return
+	}
+
+	query := spellbook.Query{Filters: []spellbook.Filter{{Field: "ContentKey", Operator: spellbook.FilterEqual, Value: content.Id()}}}
+	redirects, err := manager.redirects().ListOf(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the redirects of deleted content %s: %s", content.Slug, err.Error())
+		return
+	}
+
+	for _, redirect := range redirects {
+		if err := manager.redirects().Delete(ctx, redirect); err != nil {
+			log.Errorf(ctx, "error deleting redirect %s of deleted content %s: %s", redirect.Id(), content.Slug, err.Error())
+		}
+	}
+}
+
+func NewSlugRedirectController() *spellbook.RestController {
+	return NewSlugRedirectControllerWithKey("")
+}
+
+func NewSlugRedirectControllerWithKey(key string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: SlugRedirectManager{}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+func NewSqlSlugRedirectController() *spellbook.RestController {
+	return NewSqlSlugRedirectControllerWithKey("")
+}
+
+func NewSqlSlugRedirectControllerWithKey(key string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: SlugRedirectManager{Content: SqlContentManager{}.content()}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+// SlugRedirectManager lists the slug redirects of the contents for the administrators.
+// Redirects can be deleted, e.g. to free a slug, but are created by the content manager only.
+// The zero Content manager reads the datastore
+type SlugRedirectManager struct {
+	Content ContentManager
+}
+
+func (manager SlugRedirectManager) redirects() (spellbook.Repository, error) {
+	if r := manager.Content.redirects(); r != nil {
+		return r, nil
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// Describe describes the slug redirects for the OpenAPI document
+func (manager SlugRedirectManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "SlugRedirect",
+		Filterable:       slugRedirectFilterFields,
+		Orderable:        []string{"Locale", "From", "Created"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadContent},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteContent},
+	}
+}
+
+func (manager SlugRedirectManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager SlugRedirectManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	redirects, err := manager.redirects()
+	if err != nil {
+		return nil, err
+	}
+
+	redirect, err := redirects.FromId(ctx, id)
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve slug redirect %s: %s", id, err.Error())
+		return nil, err
+	}
+	return redirect, nil
+}
+
+// ListOf lists the redirects, the last first
+func (manager SlugRedirectManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	redirects, err := manager.redirects()
+	if err != nil {
+		return nil, err
+	}
+
+	query := spellbook.QueryFromOptions(opts)
+	if query.Order == "" {
+		query.Order = "Created"
+		query.Descending = true
+	}
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+
+	return redirects.ListOf(ctx, query)
+}
+
+func (manager SlugRedirectManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager SlugRedirectManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager SlugRedirectManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager SlugRedirectManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionWriteContent) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteContent))
+	}
+
+	redirects, err := manager.redirects()
+	if err != nil {
+		return err
+	}
+	return redirects.Delete(ctx, res)
+}
+
+// SlugController renders the visible content with the slug in the locale.
+// Past slugs of the contents are answered by a MovedController to the Path of the current slug,
+// a format taking the locale and the slug, e.g. "/content/%s/%s"
+type SlugController struct {
+	Manager PublicContentManager
+	Locale  string
+	Slug    string
+	Path    string
+}
+
+// NewSlugController returns the controller of the datastore content with the slug in the locale
+func NewSlugController(locale string, slug string, path string) *SlugController {
+	return &SlugController{Locale: locale, Slug: slug, Path: path}
+}
+
+// NewSqlSlugController returns the controller of the sql content with the slug in the locale
+func NewSqlSlugController(locale string, slug string, path string) *SlugController {
+	return &SlugController{Manager: PublicContentManager{Content: SqlContentManager{}.content()}, Locale: locale, Slug: slug, Path: path}
+}
+
+func (controller *SlugController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	ins := spellbook.InputsFromContext(ctx)
+	if method := ins[flamel.KeyRequestMethod].Value(); method != http.MethodGet {
+		return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
+	}
+
+	content, err := controller.Manager.FromSlug(ctx, controller.Locale, controller.Slug)
+	if spellbook.IsNotFound(err) {
+		redirect, rerr := controller.Manager.Content.Redirect(ctx, controller.Locale, controller.Slug)
+		if rerr == nil {
+			moved := spellbook.MovedController{To: fmt.Sprintf(controller.Path, redirect.Locale, redirect.To)}
+			return moved.Process(ctx, out)
+		}
+		if !spellbook.IsNotFound(rerr) {
+			err = rerr
+		}
+	}
+	if err != nil {
+		return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(err), out)
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = content
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *SlugController) OnDestroy(ctx context.Context) {}
@@ -0,0 +1,447 @@
+package content
+
+import (
+	"context"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/sql"
+	"reflect"
+	"time"
+)
+
+// sql storage of the contents, of their history and of the attachments
+var (
+	sqlContentRepository     = sqlContentStore{sql.NewRepository(&Content{}, contentFilterFields, contentColumns)}
+	sqlRevisionRepository    = sql.NewRepository(&Revision{}, revisionFilterFields, nil)
+	sqlStateChangeRepository = sql.NewRepository(&StateChange{}, stateChangeFilterFields, nil)
+	sqlRedirectRepository    = sql.NewRepository(&SlugRedirect{}, slugRedirectFilterFields, nil)
+	sqlAttachmentRepository  = sql.NewRepository(&Attachment{}, attachmentFilterFields, attachmentColumns)
+)
+
+// datastore storage of the contents, the default of the ContentManager
+type contentRepository struct{}
+
+func (repository contentRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	content := Content{}
+	if err := model.FromEncodedKey(ctx, &content, id); err != nil {
+		return nil, err
+	}
+	if !content.Deleted.IsZero() {
+		return nil, spellbook.ErrNotFound
+	}
+	return &content, nil
+}
+
+func (repository contentRepository) query(query spellbook.Query) (*model.Query, error) {
+	return spellbook.DatastoreQuery(model.NewQuery(&Content{}), query, indexedFilterFields)
+}
+
+// lists the contents of the query, the trashed ones included, see spellbook.DatastoreMerged
+func (repository contentRepository) list(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreMerged(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		// requested fields are not pushed down to a projection query:
+		// projections only work on indexed properties and the body is not indexed
+		q, err := repository.query(query)
+		if err != nil {
+			return nil, err
+		}
+
+		var conts []*Content
+		if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &conts); err != nil {
+			return nil, err
+		}
+
+		resources := make([]spellbook.Resource, len(conts))
+		for i := range conts {
+			resources[i] = conts[i]
+		}
+		return resources, nil
+	})
+}
+
+func trashedContent(res spellbook.Resource) bool {
+	return !res.(*Content).Deleted.IsZero()
+}
+
+func (repository contentRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreUntrashed(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return repository.list(ctx, query)
+	}, trashedContent)
+}
+
+func (repository contentRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	return spellbook.DatastoreCountUntrashed(ctx, query, repository.query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return repository.list(ctx, query)
+	}, trashedContent)
+}
+
+// Distinct returns the distinct values of the field, the ones of the trashed contents included:
+// projections skip the entities without the projected properties, such as the ones stored before the trash mark
+func (repository contentRepository) Distinct(ctx context.Context, field string, query spellbook.Query) ([]string, error) {
+	query.Order = ""
+	q, err := repository.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var conts []*Content
+	if err := q.Distinct(field).GetAll(spellbook.DatastoreQueryContext(ctx), &conts); err != nil {
+		return nil, err
+	}
+	return distinctValues(len(conts), func(i int) interface{} { return conts[i] }, field), nil
+}
+
+func (repository contentRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	content := res.(*Content)
+
+	// // WARNING: the volatile field Multimedia because Memcache (Gob)
+	//	can't ignore field
+	tmp := content.Attachments
+	content.Attachments = nil
+	defer func() {
+		content.Attachments = tmp
+	}()
+
+	return model.Create(ctx, content)
+}
+
+func (repository contentRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	content := res.(*Content)
+
+	tmp := content.Attachments
+	content.Attachments = nil
+	defer func() {
+		content.Attachments = tmp
+	}()
+
+	return model.Update(ctx, content)
+}
+
+func (repository contentRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*Content), nil)
+}
+
+// Trash marks the content as deleted, see spellbook.TrashRepository
+func (repository contentRepository) Trash(ctx context.Context, res spellbook.Resource) error {
+	content := res.(*Content)
+	content.Deleted = time.Now().UTC()
+	return repository.Update(ctx, content)
+}
+
+// Trashed returns the content with the given id if it is in the trash
+func (repository contentRepository) Trashed(ctx context.Context, id string) (spellbook.Resource, error) {
+	content := Content{}
+	if err := model.FromEncodedKey(ctx, &content, id); err != nil {
+		return nil, err
+	}
+	if content.Deleted.IsZero() {
+		return nil, spellbook.ErrNotFound
+	}
+	return &content, nil
+}
+
+// Restore clears the deleted mark of the content
+func (repository contentRepository) Restore(ctx context.Context, res spellbook.Resource) error {
+	content := res.(*Content)
+	content.Deleted = time.Time{}
+	return repository.Update(ctx, content)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository contentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
+
+// datastore storage of the revisions, the default of the ContentManager
+type revisionRepository struct{}
+
+func (repository revisionRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	revision := Revision{}
+	if err := model.FromEncodedKey(ctx, &revision, id); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+func (repository revisionRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Revision{}), query, revisionFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []*Revision
+	if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &revisions); err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(revisions))
+	for i := range revisions {
+		resources[i] = revisions[i]
+	}
+	return resources, nil
+}
+
+func (repository revisionRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Revision{}), query, revisionFilterFields)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(spellbook.DatastoreQueryContext(ctx))
+}
+
+func (repository revisionRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	return model.Create(ctx, res.(*Revision))
+}
+
+// revisions are immutable
+func (repository revisionRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (repository revisionRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*Revision), nil)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository revisionRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
+
+// datastore storage of the state changes, the default of the ContentManager
+type stateChangeRepository struct{}
+
+func (repository stateChangeRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	change := StateChange{}
+	if err := model.FromEncodedKey(ctx, &change, id); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+func (repository stateChangeRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&StateChange{}), query, stateChangeFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []*StateChange
+	if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &changes); err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(changes))
+	for i := range changes {
+		resources[i] = changes[i]
+	}
+	return resources, nil
+}
+
+func (repository stateChangeRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&StateChange{}), query, stateChangeFilterFields)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(spellbook.DatastoreQueryContext(ctx))
+}
+
+func (repository stateChangeRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	return model.Create(ctx, res.(*StateChange))
+}
+
+// state changes are immutable
+func (repository stateChangeRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (repository stateChangeRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*StateChange), nil)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository stateChangeRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
+
+// datastore storage of the slug redirects, the default of the ContentManager
+type redirectRepository struct{}
+
+func (repository redirectRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	redirect := SlugRedirect{}
+	if err := model.FromEncodedKey(ctx, &redirect, id); err != nil {
+		return nil, err
+	}
+	return &redirect, nil
+}
+
+func (repository redirectRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&SlugRedirect{}), query, slugRedirectFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var redirects []*SlugRedirect
+	if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &redirects); err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(redirects))
+	for i := range redirects {
+		resources[i] = redirects[i]
+	}
+	return resources, nil
+}
+
+func (repository redirectRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&SlugRedirect{}), query, slugRedirectFilterFields)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(spellbook.DatastoreQueryContext(ctx))
+}
+
+func (repository redirectRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	return model.Create(ctx, res.(*SlugRedirect))
+}
+
+func (repository redirectRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return model.Update(ctx, res.(*SlugRedirect))
+}
+
+func (repository redirectRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*SlugRedirect), nil)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository redirectRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
+
+// datastore storage of the attachments, the default of the AttachmentManager
+type attachmentRepository struct{}
+
+func (repository attachmentRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	att := Attachment{}
+	if err := model.FromEncodedKey(ctx, &att, id); err != nil {
+		return nil, err
+	}
+	if !att.Deleted.IsZero() {
+		return nil, spellbook.ErrNotFound
+	}
+	return &att, nil
+}
+
+func (repository attachmentRepository) query(query spellbook.Query) (*model.Query, error) {
+	return spellbook.DatastoreQuery(model.NewQuery(&Attachment{}), query, attachmentFilterFields)
+}
+
+// lists the attachments of the query, the trashed ones included, see spellbook.DatastoreMerged
+func (repository attachmentRepository) list(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreMerged(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		q, err := repository.query(query)
+		if err != nil {
+			return nil, err
+		}
+
+		var attachments []*Attachment
+		if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &attachments); err != nil {
+			return nil, err
+		}
+
+		resources := make([]spellbook.Resource, len(attachments))
+		for i := range attachments {
+			resources[i] = attachments[i]
+		}
+		return resources, nil
+	})
+}
+
+func trashedAttachment(res spellbook.Resource) bool {
+	return !res.(*Attachment).Deleted.IsZero()
+}
+
+func (repository attachmentRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreUntrashed(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return repository.list(ctx, query)
+	}, trashedAttachment)
+}
+
+func (repository attachmentRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	return spellbook.DatastoreCountUntrashed(ctx, query, repository.query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return repository.list(ctx, query)
+	}, trashedAttachment)
+}
+
+// Distinct returns the distinct values of the field, the ones of the trashed attachments included, see contentRepository.Distinct
+func (repository attachmentRepository) Distinct(ctx context.Context, field string, query spellbook.Query) ([]string, error) {
+	query.Order = ""
+	q, err := repository.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*Attachment
+	if err := q.Distinct(field).GetAll(spellbook.DatastoreQueryContext(ctx), &attachments); err != nil {
+		return nil, err
+	}
+	return distinctValues(len(attachments), func(i int) interface{} { return attachments[i] }, field), nil
+}
+
+func (repository attachmentRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	return model.Create(ctx, res.(*Attachment))
+}
+
+func (repository attachmentRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return model.Update(ctx, res.(*Attachment))
+}
+
+func (repository attachmentRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*Attachment), nil)
+}
+
+// Trash marks the attachment as deleted, see spellbook.TrashRepository
+func (repository attachmentRepository) Trash(ctx context.Context, res spellbook.Resource) error {
+	att := res.(*Attachment)
+	att.Deleted = time.Now().UTC()
+	return repository.Update(ctx, att)
+}
+
+// Trashed returns the attachment with the given id if it is in the trash
+func (repository attachmentRepository) Trashed(ctx context.Context, id string) (spellbook.Resource, error) {
+	att := Attachment{}
+	if err := model.FromEncodedKey(ctx, &att, id); err != nil {
+		return nil, err
+	}
+	if att.Deleted.IsZero() {
+		return nil, spellbook.ErrNotFound
+	}
+	return &att, nil
+}
+
+// Restore clears the deleted mark of the attachment
+func (repository attachmentRepository) Restore(ctx context.Context, res spellbook.Resource) error {
+	att := res.(*Attachment)
+	att.Deleted = time.Time{}
+	return repository.Update(ctx, att)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository attachmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
+
+// returns the non empty values of the field of the n results of a distinct query
+func distinctValues(n int, result func(i int) interface{}, field string) []string {
+	var values []string
+	for i := 0; i < n; i++ {
+		value := reflect.ValueOf(result(i)).Elem().FieldByName(field).String()
+		if len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
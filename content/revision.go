@@ -0,0 +1,365 @@
+package content
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Revision is an immutable snapshot of a content, stored each time the content is updated.
+// Data is the JSON representation of the content in its Number revision,
+// Editor and Created are the user that replaced the revision and when
+type Revision struct {
+	model.Model `json:"-"`
+	ID          uint   `model:"-" json:"-"`
+	ContentKey  string `gorm:"NOT NULL;UNIQUE_INDEX:content_revision_number"`
+	Number      int    `gorm:"UNIQUE_INDEX:content_revision_number"`
+	Data        string `model:"noindex" gorm:"type:text"`
+	Editor      string
+	Created     time.Time
+}
+
+func (revision Revision) TableName() string {
+	return "content_revisions"
+}
+
+// returns the revision of the content as it is before being changed by the current user
+func newRevision(ctx context.Context, content *Content) (*Revision, error) {
+	snapshot := *content
+	snapshot.Attachments = nil
+	data, err := snapshot.ToRepresentation(spellbook.RepresentationTypeJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := Revision{ContentKey: content.Id(), Number: content.Revision, Data: string(data), Created: time.Now().UTC()}
+	if user, ok := spellbook.IdentityFromContext(ctx).(interface{ Username() string }); ok {
+		revision.Editor = user.Username()
+	}
+	return &revision, nil
+}
+
+// revisions are identified by their number among the revisions of their content
+func (revision *Revision) Id() string {
+	return strconv.Itoa(revision.Number)
+}
+
+func (revision *Revision) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Id      string          `json:"id"`
+		Content string          `json:"content"`
+		Number  int             `json:"number"`
+		Data    json.RawMessage `json:"data"`
+		Editor  string          `json:"editor"`
+		Created time.Time       `json:"created"`
+	}{
+		Id:      revision.Id(),
+		Content: revision.ContentKey,
+		Number:  revision.Number,
+		Data:    json.RawMessage(revision.Data),
+		Editor:  revision.Editor,
+		Created: revision.Created,
+	})
+}
+
+func (revision *Revision) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(revision)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// revisions are only created by the content manager
+func (revision *Revision) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// fields the revisions can be filtered by
+var revisionFilterFields = spellbook.FilterFields{
+	"ContentKey": spellbook.FieldString,
+	"Number":     spellbook.FieldInt,
+	"Editor":     spellbook.FieldString,
+	"Created":    spellbook.FieldTime,
+}
+
+// NewRevisionController returns the controller of the revisions of the datastore content with the given key
+func NewRevisionController(contentKey string) *spellbook.RestController {
+	return NewRevisionControllerWithKey(contentKey, "")
+}
+
+func NewRevisionControllerWithKey(contentKey string, key string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: RevisionManager{ContentKey: contentKey}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+// NewSqlRevisionController returns the controller of the revisions of the sql content with the given key
+func NewSqlRevisionController(contentKey string) *spellbook.RestController {
+	return NewSqlRevisionControllerWithKey(contentKey, "")
+}
+
+func NewSqlRevisionControllerWithKey(contentKey string, key string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: RevisionManager{ContentKey: contentKey, Content: SqlContentManager{}.content()}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+// RevisionManager lists the revisions of the content with the given key, stored by the Content manager.
+// A revision is restored by updating it with {"restore": true}.
+// The zero Content manager reads the datastore
+type RevisionManager struct {
+	ContentKey string
+	Content    ContentManager
+}
+
+func (manager RevisionManager) revisions() (spellbook.Repository, error) {
+	if r := manager.Content.revisions(); r != nil {
+		return r, nil
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// Describe describes the revisions for the OpenAPI document
+func (manager RevisionManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "Revision",
+		Filterable:       revisionFilterFields,
+		Orderable:        []string{"Number", "Created"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadContent},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteContent},
+	}
+}
+
+func (manager RevisionManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager RevisionManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	number, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, spellbook.NewFieldError("id", fmt.Errorf("invalid revision number %q", id))
+	}
+
+	revisions, err := manager.revisions()
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := revisions.ListOf(ctx, spellbook.Query{Filters: []spellbook.Filter{
+		{Field: "ContentKey", Operator: spellbook.FilterEqual, Value: manager.ContentKey},
+		{Field: "Number", Operator: spellbook.FilterEqual, Value: id},
+	}, Limit: 1})
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve revision %d of content %s: %s", number, manager.ContentKey, err.Error())
+		return nil, err
+	}
+
+	if len(resources) == 0 {
+		return nil, spellbook.ErrNotFound
+	}
+	return resources[0], nil
+}
+
+func (manager RevisionManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	revisions, err := manager.revisions()
+	if err != nil {
+		return nil, err
+	}
+
+	// the revisions of missing contents are not found
+	if _, err := manager.Content.contents().FromId(ctx, manager.ContentKey); err != nil {
+		return nil, err
+	}
+
+	query := spellbook.QueryFromOptions(opts)
+	query.Filters = append(query.Filters, spellbook.Filter{Field: "ContentKey", Operator: spellbook.FilterEqual, Value: manager.ContentKey})
+	// the last revisions come first
+	if query.Order == "" {
+		query.Order = "Number"
+		query.Descending = true
+	}
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+
+	return revisions.ListOf(ctx, query)
+}
+
+func (manager RevisionManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager RevisionManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// Update restores the content to the revision if the bundle is {"restore": true}.
+// The content is restored as a new revision, so that the restore can be undone
+func (manager RevisionManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionWriteContent) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteContent))
+	}
+
+	action := struct {
+		Restore bool `json:"restore"`
+	}{}
+	if err := json.Unmarshal(bundle, &action); err != nil {
+		return spellbook.NewFieldError("", fmt.Errorf("bad json %s", string(bundle)))
+	}
+	if !action.Restore {
+		return spellbook.NewFieldError("restore", errors.New("revisions can only be restored"))
+	}
+
+	content, err := manager.Content.contents().FromId(ctx, manager.ContentKey)
+	if err != nil {
+		return err
+	}
+	return manager.Content.RestoreRevision(ctx, content.(*Content), res.(*Revision))
+}
+
+// revisions are immutable
+func (manager RevisionManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// the revision number naming the current content in a diff
+const currentRevision = "current"
+
+// returns the JSON representation of the content in the revision with the given number, or of the current content
+func (manager RevisionManager) snapshot(ctx context.Context, number string) ([]byte, error) {
+	if number == currentRevision {
+		content, err := manager.Content.contents().FromId(ctx, manager.ContentKey)
+		if err != nil {
+			return nil, err
+		}
+		snapshot := *content.(*Content)
+		snapshot.Attachments = nil
+		return snapshot.ToRepresentation(spellbook.RepresentationTypeJSON)
+	}
+
+	revision, err := manager.FromId(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(revision.(*Revision).Data), nil
+}
+
+// FieldChange is the change of a content field between two revisions
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// fields that change with every revision, left out of the diffs
+var volatileFields = map[string]bool{
+	"id":       true,
+	"revision": true,
+	"updated":  true,
+}
+
+// Diff returns the changed fields between the two revisions, sorted by field.
+// Either revision can be "current", the content as it is now
+func (manager RevisionManager) Diff(ctx context.Context, from string, to string) ([]FieldChange, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	fields := make([]map[string]interface{}, 2)
+	for i, number := range []string{from, to} {
+		data, err := manager.snapshot(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &fields[i]); err != nil {
+			return nil, fmt.Errorf("bad snapshot of revision %s: %s", number, err.Error())
+		}
+	}
+
+	changes := make([]FieldChange, 0)
+	for field, value := range fields[0] {
+		if other, ok := fields[1][field]; !volatileFields[field] && (!ok || !reflect.DeepEqual(value, other)) {
+			changes = append(changes, FieldChange{Field: field, From: value, To: other})
+		}
+	}
+	for field, value := range fields[1] {
+		if _, ok := fields[0][field]; !volatileFields[field] && !ok {
+			changes = append(changes, FieldChange{Field: field, To: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+// RevisionDiffController renders the changed fields between the revisions in the "from" and "to" parameters.
+// The "to" revision defaults to the current content
+type RevisionDiffController struct {
+	Manager RevisionManager
+}
+
+// NewRevisionDiffController returns the diff of the revisions of the datastore content with the given key
+func NewRevisionDiffController(contentKey string) *RevisionDiffController {
+	return &RevisionDiffController{Manager: RevisionManager{ContentKey: contentKey}}
+}
+
+// NewSqlRevisionDiffController returns the diff of the revisions of the sql content with the given key
+func NewSqlRevisionDiffController(contentKey string) *RevisionDiffController {
+	return &RevisionDiffController{Manager: RevisionManager{ContentKey: contentKey, Content: SqlContentManager{}.content()}}
+}
+
+func (controller *RevisionDiffController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	ins := spellbook.InputsFromContext(ctx)
+	if method := ins[flamel.KeyRequestMethod].Value(); method != http.MethodGet {
+		return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
+	}
+
+	from := ins["from"].Value()
+	if from == "" {
+		return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(spellbook.NewFieldError("from", errors.New("the revision to compare is required"))), out)
+	}
+	to := currentRevision
+	if tin, ok := ins["to"]; ok && tin.Value() != "" {
+		to = tin.Value()
+	}
+
+	changes, err := controller.Manager.Diff(ctx, from, to)
+	if err != nil {
+		log.Errorf(ctx, "error comparing revisions %s and %s of content %s: %s", from, to, controller.Manager.ContentKey, err.Error())
+		return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(err), out)
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = struct {
+		From    string        `json:"from"`
+		To      string        `json:"to"`
+		Changes []FieldChange `json:"changes"`
+	}{from, to, changes}
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *RevisionDiffController) OnDestroy(ctx context.Context) {}
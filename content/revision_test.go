@@ -0,0 +1,72 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"testing"
+)
+
+func TestRevisionDiffAndRestore(t *testing.T) {
+	user := spellbooktest.NewUser("editor", spellbook.PermissionReadContent, spellbook.PermissionWriteContent)
+	ctx := spellbooktest.NewContext(user)
+	manager := newMemoryManager()
+	content := newContent(t, ctx, manager, `{"type":"page","title":"First","slug":"page","locale":"en","body":"<p>first</p>"}`)
+
+	if err := manager.Update(ctx, content, []byte(`{"type":"page","title":"Second","slug":"page","locale":"en","body":"<p>first</p>"}`)); err != nil {
+		t.Fatalf("error updating the content: %s", err)
+	}
+
+	revisions := RevisionManager{ContentKey: content.Id(), Content: manager}
+	listed, err := revisions.ListOf(ctx, spellbook.ListOptions{Size: 10})
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("got revisions %v and error %v, want the replaced one", listed, err)
+	}
+	if revision := listed[0].(*Revision); revision.Number != 1 || revision.Editor != "editor" {
+		t.Errorf("got revision %d by %q, want 1 by editor", revision.Number, revision.Editor)
+	}
+
+	changes, err := revisions.Diff(ctx, "1", currentRevision)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(changes) != 1 || changes[0].Field != "title" || changes[0].From != "First" || changes[0].To != "Second" {
+		t.Errorf("got changes %+v, want the title from First to Second", changes)
+	}
+
+	if _, err := revisions.Diff(ctx, "7", currentRevision); !spellbook.IsNotFound(err) {
+		t.Errorf("got error %v for the diff of a missing revision, want not found", err)
+	}
+
+	// revisions can only be restored
+	revision, err := revisions.FromId(ctx, "1")
+	if err != nil {
+		t.Fatalf("error reading the revision: %s", err)
+	}
+	if err := revisions.Update(ctx, revision, []byte(`{"restore":false}`)); err == nil {
+		t.Error("a revision was changed instead of restored")
+	}
+	reader := spellbooktest.NewContext(spellbooktest.NewUser("reader", spellbook.PermissionReadContent))
+	if err := revisions.Update(reader, revision, []byte(`{"restore":true}`)); err == nil {
+		t.Error("a revision was restored without the write permission")
+	}
+
+	if err := revisions.Update(ctx, revision, []byte(`{"restore":true}`)); err != nil {
+		t.Fatalf("error restoring the revision: %s", err)
+	}
+	res, err := manager.FromId(ctx, content.Id())
+	if err != nil {
+		t.Fatalf("error reading the content: %s", err)
+	}
+	if restored := res.(*Content); restored.Title != "First" || restored.Revision != 3 {
+		t.Errorf("got title %q in revision %d, want First in revision 3", restored.Title, restored.Revision)
+	}
+
+	// the restore is a revision too, so that it can be undone
+	changes, err = revisions.Diff(ctx, "2", currentRevision)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(changes) != 1 || changes[0].Field != "title" || changes[0].From != "Second" || changes[0].To != "First" {
+		t.Errorf("got changes %+v after the restore, want the title from Second to First", changes)
+	}
+}
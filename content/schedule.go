@@ -0,0 +1,190 @@
+package content
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/spellbook"
+	"errors"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"time"
+)
+
+// user recorded in the state changes made by the scheduler
+const SchedulerUser = "scheduler"
+
+// number of contents read at once by the scheduler
+const scheduleBatchSize = 100
+
+// returns an error if the content would be unpublished before being published
+func validateSchedule(content *Content) error {
+	if !content.PublishAt.IsZero() && !content.UnpublishAt.IsZero() && !content.UnpublishAt.After(content.PublishAt) {
+		return spellbook.NewFieldError("unpublishAt", errors.New("a content can't be unpublished before being published"))
+	}
+	return nil
+}
+
+// Scheduler publishes the contents of its Manager from their PublishAt and unpublishes them from their UnpublishAt,
+// through the transitions of the manager workflow.
+// Contents that the workflow doesn't allow to publish, e.g. the ones still in review, wait for the next run
+type Scheduler struct {
+	Manager ContentManager
+}
+
+// NewSqlScheduler returns the scheduler of the contents stored in the sql database
+func NewSqlScheduler() Scheduler {
+	return Scheduler{Manager: SqlContentManager{}.content()}
+}
+
+// Run publishes and unpublishes the contents due at the given time, returning how many have been changed
+func (scheduler Scheduler) Run(ctx context.Context, now time.Time) (published int, unpublished int, err error) {
+	published, err = scheduler.due(ctx, "PublishAt", now, scheduler.publish)
+	if err != nil {
+		return published, 0, err
+	}
+	unpublished, err = scheduler.due(ctx, "UnpublishAt", now, scheduler.unpublish)
+	return published, unpublished, err
+}
+
+// Start runs the scheduler every interval until the context is done.
+// It is the in-process alternative to the cron calling the ScheduleController
+func (scheduler Scheduler) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, _, err := scheduler.Run(ctx, now.UTC()); err != nil {
+				log.Errorf(ctx, "error running the content schedule: %s", err.Error())
+			}
+		}
+	}
+}
+
+// calls fn on the contents whose field is due at the given time, returning how many have been changed.
+// fn reports if the content has been changed and if it is still due
+func (scheduler Scheduler) due(ctx context.Context, field string, now time.Time, fn func(ctx context.Context, content *Content) (bool, bool, error)) (int, error) {
+	query := spellbook.Query{
+		Filters: []spellbook.Filter{
+			{Field: field, Operator: spellbook.FilterGreater, Value: time.Time{}.Format(time.RFC3339)},
+			{Field: field, Operator: spellbook.FilterLessOrEqual, Value: now.Format(time.RFC3339)},
+		},
+		Order: field,
+		Limit: scheduleBatchSize,
+	}
+
+	changed := 0
+	for {
+		resources, err := scheduler.Manager.contents().ListOf(ctx, query)
+		if err != nil {
+			return changed, err
+		}
+
+		for _, res := range resources {
+			content := res.(*Content)
+			ok, due, err := fn(ctx, content)
+			if err != nil {
+				log.Errorf(ctx, "error running the schedule of content %s: %s", content.Slug, err.Error())
+				return changed, err
+			}
+			if ok {
+				changed++
+			}
+			// the contents still due are skipped by the next batches
+			if due {
+				query.Offset++
+			}
+		}
+
+		if len(resources) < scheduleBatchSize {
+			return changed, nil
+		}
+	}
+}
+
+func (scheduler Scheduler) publish(ctx context.Context, content *Content) (bool, bool, error) {
+	at := content.PublishAt
+	content.PublishAt = time.Time{}
+
+	if content.state() == PublicationStatePublished {
+		return false, false, scheduler.Manager.contents().Update(ctx, content)
+	}
+
+	transition, ok := scheduler.Manager.workflow().between(content.state(), PublicationStatePublished)
+	if !ok {
+		return false, true, nil
+	}
+
+	change, err := makeTransition(content, transition, SchedulerUser, "scheduled publication")
+	if err != nil {
+		return false, false, err
+	}
+	content.Published = at
+	return true, false, scheduler.Manager.storeTransition(ctx, content, change)
+}
+
+func (scheduler Scheduler) unpublish(ctx context.Context, content *Content) (bool, bool, error) {
+	at := content.UnpublishAt
+	content.UnpublishAt = time.Time{}
+
+	if content.state() != PublicationStatePublished {
+		// the publication window has passed
+		if !content.PublishAt.IsZero() && content.PublishAt.Before(at) {
+			content.PublishAt = time.Time{}
+		}
+		return false, false, scheduler.Manager.contents().Update(ctx, content)
+	}
+
+	transition, ok := scheduler.Manager.workflow().between(PublicationStatePublished, PublicationStateDraft)
+	if !ok {
+		// hidden anyway, see Content.IsVisible
+		return false, true, nil
+	}
+
+	change, err := makeTransition(content, transition, SchedulerUser, "scheduled unpublication")
+	if err != nil {
+		return false, false, err
+	}
+	return true, false, scheduler.Manager.storeTransition(ctx, content, change)
+}
+
+// ScheduleController runs the scheduler, meant to be called by a cron job authenticated by the CronSecret, see spellbook.IsCron.
+// Users that can write the contents can run it too
+type ScheduleController struct {
+	Scheduler Scheduler
+}
+
+// NewScheduleController returns the controller running the schedule of the datastore contents
+func NewScheduleController() *ScheduleController {
+	return &ScheduleController{}
+}
+
+// NewSqlScheduleController returns the controller running the schedule of the sql contents
+func NewSqlScheduleController() *ScheduleController {
+	return &ScheduleController{Scheduler: NewSqlScheduler()}
+}
+
+func (controller *ScheduleController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	if current := spellbook.IdentityFromContext(ctx); !spellbook.IsCron(ctx) && (current == nil || !current.HasPermission(spellbook.PermissionWriteContent)) {
+		return flamel.HttpResponse{Status: http.StatusForbidden}
+	}
+
+	published, unpublished, err := controller.Scheduler.Run(ctx, time.Now().UTC())
+	if err != nil {
+		log.Errorf(ctx, "error running the content schedule, %d published and %d unpublished: %s", published, unpublished, err.Error())
+		return flamel.HttpResponse{Status: http.StatusInternalServerError}
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = struct {
+		Published   int `json:"published"`
+		Unpublished int `json:"unpublished"`
+	}{published, unpublished}
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *ScheduleController) OnDestroy(ctx context.Context) {}
@@ -0,0 +1,118 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/memory"
+	"decodica.com/spellbook/spellbooktest"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		state       PublicationState
+		publishAt   time.Time
+		unpublishAt time.Time
+		// visibility before and after the scheduler runs
+		visible    bool
+		visibleRun bool
+		// state and schedule after the scheduler runs
+		wantState       PublicationState
+		wantPublishAt   time.Time
+		wantUnpublishAt time.Time
+	}{
+		{"future publication", PublicationStateDraft, future, time.Time{}, false, false, PublicationStateDraft, future, time.Time{}},
+		{"due publication", PublicationStateDraft, past, time.Time{}, false, true, PublicationStatePublished, time.Time{}, time.Time{}},
+		{"published before its publication", PublicationStatePublished, future, time.Time{}, false, false, PublicationStatePublished, future, time.Time{}},
+		{"published at its publication", PublicationStatePublished, past, time.Time{}, true, true, PublicationStatePublished, time.Time{}, time.Time{}},
+		{"publication in review", PublicationStateInReview, past, time.Time{}, false, false, PublicationStateInReview, past, time.Time{}},
+		{"future unpublication", PublicationStatePublished, time.Time{}, future, true, true, PublicationStatePublished, time.Time{}, future},
+		{"expired", PublicationStatePublished, time.Time{}, past, false, false, PublicationStateDraft, time.Time{}, time.Time{}},
+		{"unpublished before its unpublication", PublicationStateDraft, time.Time{}, past, false, false, PublicationStateDraft, time.Time{}, time.Time{}},
+		{"publication window passed", PublicationStateDraft, past.Add(-time.Hour), past, false, false, PublicationStateDraft, time.Time{}, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := spellbooktest.NewContext(nil)
+			repository := memory.NewRepository()
+			manager := ContentManager{Repository: repository}
+
+			content := &Content{Title: test.name, Slug: "scheduled", PublicationState: test.state, PublishAt: test.publishAt, UnpublishAt: test.unpublishAt}
+			if test.state == PublicationStatePublished {
+				content.Published = past.Add(-24 * time.Hour)
+			}
+			if err := repository.Create(ctx, content); err != nil {
+				t.Fatalf("error creating the content: %s", err)
+			}
+
+			if visible := content.IsVisible(now); visible != test.visible {
+				t.Errorf("got visible %t before the scheduler runs, want %t", visible, test.visible)
+			}
+			public, err := PublicContentManager{Content: manager}.ListOf(ctx, spellbook.ListOptions{Size: 10})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (len(public) == 1) != test.visible {
+				t.Errorf("got %d public contents before the scheduler runs, want visible %t", len(public), test.visible)
+			}
+
+			if _, _, err := (Scheduler{Manager: manager}).Run(ctx, now); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			res, err := repository.FromId(ctx, content.Id())
+			if err != nil {
+				t.Fatalf("error reading the content: %s", err)
+			}
+			content = res.(*Content)
+			if content.state() != test.wantState {
+				t.Errorf("got state %s, want %s", content.state(), test.wantState)
+			}
+			if !content.PublishAt.Equal(test.wantPublishAt) || !content.UnpublishAt.Equal(test.wantUnpublishAt) {
+				t.Errorf("got schedule %s - %s, want %s - %s", content.PublishAt, content.UnpublishAt, test.wantPublishAt, test.wantUnpublishAt)
+			}
+			if visible := content.IsVisible(now); visible != test.visibleRun {
+				t.Errorf("got visible %t after the scheduler runs, want %t", visible, test.visibleRun)
+			}
+		})
+	}
+}
+
+func TestSchedulerPublished(t *testing.T) {
+	ctx := spellbooktest.NewContext(nil)
+	repository := memory.NewRepository()
+	manager := ContentManager{Repository: repository, StateChanges: memory.NewRepository()}
+	now := time.Now().UTC().Truncate(time.Second)
+	at := now.Add(-time.Hour)
+
+	content := &Content{Title: "Scheduled", Slug: "scheduled", PublicationState: PublicationStateDraft, PublishAt: at}
+	if err := repository.Create(ctx, content); err != nil {
+		t.Fatalf("error creating the content: %s", err)
+	}
+
+	published, unpublished, err := Scheduler{Manager: manager}.Run(ctx, now)
+	if err != nil || published != 1 || unpublished != 0 {
+		t.Fatalf("got %d published, %d unpublished and error %v, want 1 published", published, unpublished, err)
+	}
+
+	// the publication is dated at the schedule and recorded as made by the scheduler
+	res, _ := repository.FromId(ctx, content.Id())
+	if got := res.(*Content).Published; !got.Equal(at) {
+		t.Errorf("got publication time %s, want %s", got, at)
+	}
+	changes, err := manager.StateChanges.ListOf(ctx, spellbook.Query{})
+	if err != nil || len(changes) != 1 || changes[0].(*StateChange).User != SchedulerUser {
+		t.Errorf("got state changes %v and error %v, want the one of the scheduler", changes, err)
+	}
+
+	// the next run finds nothing due
+	published, unpublished, err = Scheduler{Manager: manager}.Run(ctx, now)
+	if err != nil || published != 0 || unpublished != 0 {
+		t.Errorf("got %d published, %d unpublished and error %v on the second run, want none", published, unpublished, err)
+	}
+}
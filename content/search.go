@@ -0,0 +1,334 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"errors"
+	"google.golang.org/appengine/log"
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// searchable fields of the contents, by JSON name, with their weight in the ranking of the embedded index
+var searchFields = []struct {
+	name   string
+	weight float64
+	text   func(content *Content) string
+}{
+	{"title", 4, func(content *Content) string { return content.Title }},
+	{"subtitle", 2, func(content *Content) string { return content.Subtitle }},
+	{"description", 2, func(content *Content) string { return content.Description }},
+	{"tags", 2, func(content *Content) string { return content.Tags }},
+	{"body", 1, func(content *Content) string { return stripHTML(content.Body) }},
+}
+
+// maximum number of terms indexed for a content, datastore entities have a limited number of index entries
+const maxSearchTerms = 1000
+
+// number of contents read at once while ranking them
+const searchScanSize = 100
+
+// maximum number of contents read by a search ranked by the ContentManager.
+// Repositories without the embedded index nor a Searcher, e.g. sql databases other than Postgres,
+// read every content matching the filters of the search: the ones beyond the limit are not ranked
+const maxSearchScan = 5000
+
+// number of words of the body snippets
+const snippetWords = 30
+
+var (
+	htmlBlocks = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlTags   = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// returns the text of the HTML, with its spaces collapsed
+func stripHTML(s string) string {
+	s = htmlBlocks.ReplaceAllString(s, " ")
+	s = htmlTags.ReplaceAllString(s, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// returns the search terms of the text: its words, normalized as in the slugs
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(Slugify(text), func(r rune) bool {
+		return r == '-'
+	})
+}
+
+// indexes the searchable fields of the content in its SearchTerms
+func (content *Content) index() {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, field := range searchFields {
+		for _, term := range searchTerms(field.text(content)) {
+			if !seen[term] && len(terms) < maxSearchTerms {
+				seen[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+	sort.Strings(terms)
+	content.SearchTerms = terms
+}
+
+// fields the datastore contents can be filtered by, including the terms of the embedded index
+var indexedFilterFields = func() spellbook.FilterFields {
+	fields := spellbook.FilterFields{"SearchTerms": spellbook.FieldString}
+	for name, t := range contentFilterFields {
+		fields[name] = t
+	}
+	return fields
+}()
+
+// SearchResult is a content found by a full-text search, with its rank
+// and the snippets of its matching fields, by JSON name, where the matches are enclosed in <mark> tags
+type SearchResult struct {
+	*Content
+	Rank       float64
+	Highlights map[string]string
+}
+
+func (result *SearchResult) MarshalJSON() ([]byte, error) {
+	data, err := result.Content.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields["rank"], err = json.Marshal(result.Rank); err != nil {
+		return nil, err
+	}
+	if fields["highlights"], err = json.Marshal(result.Highlights); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func (result *SearchResult) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(result)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// Searcher is implemented by the content repositories that search the contents themselves, e.g. on Postgres.
+// Search returns the page of the query of the contents matching the text, the best matches first.
+// The contents of the other repositories, or of the searchers returning an UnsupportedError,
+// are ranked by the ContentManager, which reads at most maxSearchScan of them.
+// Datastore contents written before the embedded index are found once indexed, see ContentManager.Resave
+type Searcher interface {
+	Search(ctx context.Context, text string, query spellbook.Query) ([]*SearchResult, error)
+}
+
+// Search returns the page of the query of the contents matching every word of the text, the best matches first
+func (manager ContentManager) Search(ctx context.Context, text string, query spellbook.Query) ([]*SearchResult, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	searcher, ok := manager.contents().(Searcher)
+	if !ok {
+		return manager.rank(ctx, text, query, true)
+	}
+
+	results, err := searcher.Search(ctx, text, query)
+	if _, unsupported := err.(spellbook.UnsupportedError); unsupported {
+		// searchers don't keep the terms of the embedded index
+		return manager.rank(ctx, text, query, false)
+	}
+	return results, err
+}
+
+// ranks the contents matching the filters of the query and the words of the text, returning the page of the query.
+// Indexed contents are selected by their search terms, the others are all read, up to maxSearchScan
+func (manager ContentManager) rank(ctx context.Context, text string, query spellbook.Query, indexed bool) ([]*SearchResult, error) {
+	terms := make(map[string]bool)
+	scan := spellbook.Query{Filters: append([]spellbook.Filter{}, query.Filters...), Limit: searchScanSize}
+	for _, term := range searchTerms(text) {
+		if indexed && !terms[term] {
+			scan.Filters = append(scan.Filters, spellbook.Filter{Field: "SearchTerms", Operator: spellbook.FilterEqual, Value: term})
+		}
+		terms[term] = true
+	}
+
+	results := make([]*SearchResult, 0)
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	for {
+		resources, err := manager.contents().ListOf(ctx, scan)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, res := range resources {
+			if result, ok := newSearchResult(res.(*Content), terms); ok {
+				results = append(results, result)
+			}
+		}
+
+		if len(resources) < searchScanSize {
+			break
+		}
+		scan.Offset += searchScanSize
+		if scan.Offset >= maxSearchScan {
+			log.Warningf(ctx, "search of %q stopped after %d contents, the others are not ranked", text, scan.Offset)
+			break
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+
+	if query.Offset >= len(results) {
+		return make([]*SearchResult, 0), nil
+	}
+	results = results[query.Offset:]
+	if query.Limit > 0 && query.Limit < len(results) {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+// returns the search result of the content if it matches every term.
+// The rank sums the weighted frequency of the terms in the fields, normalized by the length of the fields
+func newSearchResult(content *Content, terms map[string]bool) (*SearchResult, bool) {
+	result := SearchResult{Content: content, Highlights: make(map[string]string)}
+	found := make(map[string]bool)
+
+	for _, field := range searchFields {
+		text := field.text(content)
+		words := searchTerms(text)
+
+		frequency := 0
+		for _, word := range words {
+			if terms[word] {
+				frequency++
+				found[word] = true
+			}
+		}
+		if frequency == 0 {
+			continue
+		}
+
+		result.Rank += field.weight * float64(frequency) / math.Sqrt(float64(len(words)))
+		window := 0
+		if field.name == "body" {
+			window = snippetWords
+		}
+		result.Highlights[field.name] = highlight(text, terms, window)
+	}
+	return &result, len(found) == len(terms)
+}
+
+// returns the HTML escaped text with the words matching the terms enclosed in <mark> tags.
+// If the window is positive, only that many words around the first match are returned
+func highlight(text string, terms map[string]bool, window int) string {
+	type word struct {
+		start, end int
+		match      bool
+	}
+
+	matches := func(s string) bool {
+		for _, term := range searchTerms(s) {
+			if terms[term] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var words []word
+	start := -1
+	for i, r := range text {
+		letter := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		if letter && start < 0 {
+			start = i
+		}
+		if !letter && start >= 0 {
+			words = append(words, word{start, i, matches(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, word{start, len(text), matches(text[start:])})
+	}
+
+	from, to := 0, len(words)
+	begin, end := 0, len(text)
+	if window > 0 {
+		for i, w := range words {
+			if w.match {
+				from = i - window/3
+				break
+			}
+		}
+		if from < 0 {
+			from = 0
+		}
+		if from+window < to {
+			to = from + window
+		}
+		if from >= to {
+			return ""
+		}
+		begin, end = words[from].start, words[to-1].end
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("… ")
+	}
+	pos := begin
+	for _, w := range words[from:to] {
+		b.WriteString(html.EscapeString(text[pos:w.start]))
+		if w.match {
+			b.WriteString("<mark>" + html.EscapeString(text[w.start:w.end]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(text[w.start:w.end]))
+		}
+		pos = w.end
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if to < len(words) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
+
+// returns the page of the contents matching the search of the list options
+func (manager ContentManager) search(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	if opts.Cursor != "" {
+		return nil, spellbook.NewFieldError("cursor", errors.New("search results are paged by page"))
+	}
+	if opts.Order != "" {
+		return nil, spellbook.NewFieldError("order", errors.New("search results are ordered by relevance"))
+	}
+
+	query := spellbook.QueryFromOptions(opts)
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+
+	results, err := manager.Search(ctx, opts.Search, query)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(results))
+	for i := range results {
+		resources[i] = results[i]
+	}
+	return resources, nil
+}
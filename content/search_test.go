@@ -0,0 +1,97 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"reflect"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	content := &Content{
+		Title: "Café au lait",
+		Tags:  "drinks",
+		Body:  `<p>The <b>best</b> coffee &amp; more</p><script>var ignored</script>`,
+	}
+	content.index()
+
+	want := []string{"au", "best", "cafe", "coffee", "drinks", "lait", "more", "the"}
+	if !reflect.DeepEqual(content.SearchTerms, want) {
+		t.Errorf("got terms %v, want %v", content.SearchTerms, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := spellbooktest.NewContext(spellbooktest.NewUser("writer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent))
+	manager := newMemoryManager()
+	newContent(t, ctx, manager, `{"type":"page","title":"Coffee guide","locale":"en","body":"<p>All about the beans</p>"}`)
+	newContent(t, ctx, manager, `{"type":"page","title":"Tea","locale":"en","body":"<p>Coffee is not tea</p>"}`)
+	beans := newContent(t, ctx, manager, `{"type":"page","title":"Beans","locale":"en","body":"<p>Nothing else</p>"}`)
+
+	// returns the titles of the results of the search
+	search := func(text string) []string {
+		t.Helper()
+		results, err := manager.Search(ctx, text, spellbook.Query{Limit: 10})
+		if err != nil {
+			t.Fatalf("error searching %q: %s", text, err)
+		}
+		titles := make([]string, len(results))
+		for i, result := range results {
+			titles[i] = result.Title
+		}
+		return titles
+	}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		// matches in the title rank first
+		{"coffee", []string{"Coffee guide", "Tea"}},
+		{"COFFEE!", []string{"Coffee guide", "Tea"}},
+		// every word must match
+		{"coffee beans", []string{"Coffee guide"}},
+		{"espresso", []string{}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		if got := search(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("search %q: got %v, want %v", test.text, got, test.want)
+		}
+	}
+
+	results, err := manager.Search(ctx, "coffee", spellbook.Query{Limit: 1})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("got results %v and error %v, want the first one", results, err)
+	}
+	if got := results[0].Highlights["title"]; got != "<mark>Coffee</mark> guide" {
+		t.Errorf("got title highlight %q", got)
+	}
+
+	// updates index the contents again
+	if err := manager.Update(ctx, beans, []byte(`{"type":"page","title":"Beans","slug":"beans","locale":"en","body":"<p>Coffee beans</p>"}`)); err != nil {
+		t.Fatalf("error updating the content: %s", err)
+	}
+	if got := search("coffee beans"); !reflect.DeepEqual(got, []string{"Beans", "Coffee guide"}) {
+		t.Errorf("got %v after the update, want Beans and Coffee guide", got)
+	}
+
+	// contents stored without their terms are found once saved again
+	legacy := &Content{Type: "page", Title: "Legacy coffee", Slug: "legacy", Locale: "en"}
+	if err := manager.contents().Create(ctx, legacy); err != nil {
+		t.Fatalf("error creating the content: %s", err)
+	}
+	if got := search("legacy"); len(got) != 0 {
+		t.Errorf("got %v before the content is indexed, want nothing", got)
+	}
+	if _, err := manager.Resave(ctx); err != nil {
+		t.Fatalf("error saving the contents again: %s", err)
+	}
+	if got := search("legacy"); !reflect.DeepEqual(got, []string{"Legacy coffee"}) {
+		t.Errorf("got %v after the contents are saved again, want Legacy coffee", got)
+	}
+
+	if _, err := manager.Search(spellbooktest.NewContext(nil), "coffee", spellbook.Query{}); err == nil {
+		t.Error("the contents were searched without the read permission")
+	}
+}
@@ -0,0 +1,95 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"errors"
+	"fmt"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+// letters that don't decompose to a latin letter and a mark
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'ø': "o", 'œ': "oe", 'đ': "d", 'ð': "d", 'þ': "th", 'ł': "l", 'ı': "i", 'ħ': "h", 'ŋ': "ng",
+	// cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g",
+	// greek, without the accents removed by the decomposition
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l",
+	'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f",
+	'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Slugify returns the slug of the text: lowercase letters and digits separated by dashes.
+// Accents are removed and cyrillic and greek letters are transliterated to latin,
+// the letters of the other scripts are kept as they are
+func Slugify(text string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(t, strings.ToLower(text))
+	if err != nil {
+		stripped = strings.ToLower(text)
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range stripped {
+		if latin, ok := transliterations[r]; ok {
+			if latin == "" {
+				continue
+			}
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteString(latin)
+			dash = false
+			continue
+		}
+
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// returns a slug for the content generated from its title, unique in the locale of the content.
+// Taken slugs, trashed contents included, get the first free numeric suffix, e.g. title-2
+func (manager ContentManager) uniqueSlug(ctx context.Context, content *Content) (string, error) {
+	base := Slugify(content.Title)
+	if base == "" {
+		return "", spellbook.NewFieldError("slug", errors.New("a slug can't be generated from the title, it must be given"))
+	}
+
+	resources, err := manager.contents().ListOf(ctx, spellbook.Query{Filters: []spellbook.Filter{
+		{Field: "Slug", Operator: spellbook.FilterPrefix, Value: base},
+		{Field: "Locale", Operator: spellbook.FilterEqual, Value: content.Locale},
+	}, Trashed: true})
+	if err != nil {
+		return "", spellbook.NewFieldError("slug", fmt.Errorf("error verifying slug uniqueness: %s", err.Error()))
+	}
+
+	taken := make(map[string]bool, len(resources))
+	for _, res := range resources {
+		if res.Id() != content.Id() {
+			taken[res.(*Content).getSlug()] = true
+		}
+	}
+
+	slug := base
+	for n := 2; taken[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug, nil
+}
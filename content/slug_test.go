@@ -0,0 +1,100 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"fmt"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Crème brûlée  ", "creme-brulee"},
+		{"Straße 42", "strasse-42"},
+		{"Привет, мир", "privet-mir"},
+		{"Καλημέρα", "kalimera"},
+		{"日本語", "日本語"},
+		{"-- ! --", ""},
+	}
+
+	for _, test := range tests {
+		if got := Slugify(test.text); got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestSlugGeneration(t *testing.T) {
+	ctx := spellbooktest.NewContext(spellbooktest.NewUser("writer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent))
+	manager := newMemoryManager()
+
+	// taken slugs get the first free suffix in their locale
+	for _, want := range []string{"hello-world", "hello-world-2", "hello-world-3"} {
+		content := newContent(t, ctx, manager, `{"type":"page","title":"Hello world","locale":"en"}`)
+		if content.Slug != want {
+			t.Errorf("got slug %q, want %q", content.Slug, want)
+		}
+	}
+	if content := newContent(t, ctx, manager, `{"type":"page","title":"Hello world","locale":"it"}`); content.Slug != "hello-world" {
+		t.Errorf("got slug %q in another locale, want hello-world", content.Slug)
+	}
+
+	tests := []struct {
+		name   string
+		bundle string
+	}{
+		{"taken slug", `{"type":"page","title":"Another","slug":"hello-world","locale":"en"}`},
+		{"title without letters", `{"type":"page","title":"!!!","locale":"en"}`},
+	}
+	for _, test := range tests {
+		content := &Content{}
+		if err := content.FromRepresentation(spellbook.RepresentationTypeJSON, []byte(test.bundle)); err != nil {
+			t.Fatalf("invalid bundle: %s", err)
+		}
+		if err := manager.Create(ctx, content, []byte(test.bundle)); err == nil {
+			t.Errorf("%s: the content was created with slug %q", test.name, content.Slug)
+		}
+	}
+}
+
+func TestSlugRedirects(t *testing.T) {
+	ctx := spellbooktest.NewContext(spellbooktest.NewUser("writer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent))
+	manager := newMemoryManager()
+	content := newContent(t, ctx, manager, `{"type":"page","title":"Moving","slug":"first","locale":"en"}`)
+
+	move := func(slug string) {
+		t.Helper()
+		bundle := fmt.Sprintf(`{"type":"page","title":"Moving","slug":%q,"locale":"en"}`, slug)
+		if err := manager.Update(ctx, content, []byte(bundle)); err != nil {
+			t.Fatalf("error moving the content to %s: %s", slug, err)
+		}
+	}
+	// checks where the slugs redirect, an empty target meaning no redirect
+	expect := func(redirects map[string]string) {
+		t.Helper()
+		for from, to := range redirects {
+			redirect, err := manager.Redirect(ctx, "en", from)
+			switch {
+			case to == "" && !spellbook.IsNotFound(err):
+				t.Errorf("got redirect %+v and error %v from %s, want none", redirect, err, from)
+			case to != "" && (err != nil || redirect.To != to || redirect.ContentKey != content.Id()):
+				t.Errorf("got redirect %+v and error %v from %s, want one to %s", redirect, err, from, to)
+			}
+		}
+	}
+
+	move("second")
+	expect(map[string]string{"first": "second", "second": ""})
+
+	// no redirect chains are served
+	move("third")
+	expect(map[string]string{"first": "third", "second": "third", "third": ""})
+
+	// the slug is live again
+	move("first")
+	expect(map[string]string{"first": "", "second": "first", "third": "first"})
+}
@@ -0,0 +1,233 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/sql"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"strings"
+)
+
+// sql storage of the contents, searched with the full-text search of Postgres.
+// The search of the other databases is unsupported, so that the ContentManager ranks the contents itself
+type sqlContentStore struct {
+	sql.Repository
+}
+
+// text search configurations of Postgres, by language of the locale.
+// The languages without a configuration are searched without stemming
+var searchConfigs = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"it": "italian",
+	"nb": "norwegian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// returns the text search configuration of the locale
+func searchConfig(locale string) string {
+	language := strings.ToLower(locale)
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	if config, ok := searchConfigs[language]; ok {
+		return config
+	}
+	return "simple"
+}
+
+// returns the weighted search document of the contents, reading the columns of the given row, e.g. NEW
+func searchDocument(row string) string {
+	weights := []struct {
+		column string
+		weight string
+	}{
+		{"title", "A"},
+		{"subtitle", "B"},
+		{"description", "B"},
+		{"tags", "B"},
+		{"body", "C"},
+	}
+
+	parts := make([]string, len(weights))
+	for i, w := range weights {
+		text := fmt.Sprintf("coalesce(%s.%s, '')", row, w.column)
+		if w.column == "body" {
+			text = fmt.Sprintf("regexp_replace(%s, '<[^>]*>', ' ', 'g')", text)
+		}
+		parts[i] = fmt.Sprintf("setweight(to_tsvector(content_search_config(%s.locale), %s), '%s')", row, text, w.weight)
+	}
+	return strings.Join(parts, " || ")
+}
+
+// searchIndex is the migration step adding the search vector of the contents, kept up to date by a trigger.
+// Only Postgres has a full-text search, the step does nothing on the other databases
+func searchIndex(tx *gorm.DB) error {
+	if tx.Dialect().GetName() != "postgres" {
+		return nil
+	}
+
+	languages := make([]string, 0, len(searchConfigs))
+	for language := range searchConfigs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	cases := make([]string, len(languages))
+	for i, language := range languages {
+		cases[i] = fmt.Sprintf("WHEN '%s' THEN '%s'::regconfig", language, searchConfigs[language])
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION content_search_config(locale text) RETURNS regconfig AS $$
+			SELECT CASE lower(split_part(replace(locale, '_', '-'), '-', 1)) %s ELSE 'simple'::regconfig END
+		$$ LANGUAGE sql IMMUTABLE`, strings.Join(cases, " ")),
+		`ALTER TABLE contents ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION content_search_vector() RETURNS trigger AS $$
+			BEGIN
+				NEW.search_vector := %s;
+				RETURN NEW;
+			END
+		$$ LANGUAGE plpgsql`, searchDocument("NEW")),
+		`DROP TRIGGER IF EXISTS content_search_vector ON contents`,
+		`CREATE TRIGGER content_search_vector BEFORE INSERT OR UPDATE ON contents FOR EACH ROW EXECUTE PROCEDURE content_search_vector()`,
+		fmt.Sprintf(`UPDATE contents SET search_vector = %s`, searchDocument("contents")),
+		`CREATE INDEX IF NOT EXISTS content_search ON contents USING GIN (search_vector)`,
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropSearchIndex is the migration step reverting searchIndex
+func dropSearchIndex(tx *gorm.DB) error {
+	if tx.Dialect().GetName() != "postgres" {
+		return nil
+	}
+
+	statements := []string{
+		`DROP TRIGGER IF EXISTS content_search_vector ON contents`,
+		`DROP FUNCTION IF EXISTS content_search_vector()`,
+		`DROP INDEX IF EXISTS content_search`,
+		`ALTER TABLE contents DROP COLUMN IF EXISTS search_vector`,
+		`DROP FUNCTION IF EXISTS content_search_config(text)`,
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search ranks the contents matching the text, parsed as a web search, with the rank of Postgres.
+// Contents are searched with the configuration of the filtered locale, if any, or of their own
+func (repository sqlContentStore) Search(ctx context.Context, text string, query spellbook.Query) ([]*SearchResult, error) {
+	db := sql.FromContext(ctx)
+	if db.Dialect().GetName() != "postgres" {
+		return nil, spellbook.NewUnsupportedError()
+	}
+
+	db, err := sql.Filter(db.Model(&Content{}), contentFilterFields, query.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// a constant configuration lets Postgres use the index
+	config := "content_search_config(contents.locale)"
+	for _, filter := range query.Filters {
+		if filter.Field == "Locale" && (filter.Operator == "" || filter.Operator == spellbook.FilterEqual) {
+			config = fmt.Sprintf("'%s'::regconfig", searchConfig(filter.Value))
+		}
+	}
+
+	const highlight = "StartSel=<mark>, StopSel=</mark>"
+	columns := []string{"contents.id", "ts_rank_cd(contents.search_vector, search_query) AS search_rank"}
+	for _, column := range []string{"title", "subtitle", "description", "tags"} {
+		columns = append(columns, fmt.Sprintf("ts_headline(%s, coalesce(contents.%s, ''), search_query, '%s, HighlightAll=true') AS %s_highlight", config, column, highlight, column))
+	}
+	columns = append(columns, fmt.Sprintf("ts_headline(%s, regexp_replace(coalesce(contents.body, ''), '<[^>]*>', ' ', 'g'), search_query, '%s, MaxWords=%d, MinWords=%d, MaxFragments=2, FragmentDelimiter=\" … \"') AS body_highlight",
+		config, highlight, snippetWords, snippetWords/2))
+
+	db = db.Table("contents").
+		Select(strings.Join(columns, ", ")).
+		Joins(fmt.Sprintf("CROSS JOIN websearch_to_tsquery(%s, ?) AS search_query", config), text).
+		Where("contents.search_vector @@ search_query").
+		Order("search_rank desc")
+	if query.Offset > 0 {
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+
+	var hits []struct {
+		ID                   uint
+		SearchRank           float64
+		TitleHighlight       string
+		SubtitleHighlight    string
+		DescriptionHighlight string
+		TagsHighlight        string
+		BodyHighlight        string
+	}
+	if err := db.Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0, len(hits))
+	if len(hits) == 0 {
+		return results, nil
+	}
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	var contents []*Content
+	if err := sql.FromContext(ctx).Where("id IN (?)", ids).Find(&contents).Error; err != nil {
+		return nil, err
+	}
+	byId := make(map[uint]*Content, len(contents))
+	for _, content := range contents {
+		byId[content.ID] = content
+	}
+
+	for _, hit := range hits {
+		content, ok := byId[hit.ID]
+		if !ok {
+			continue
+		}
+
+		result := SearchResult{Content: content, Rank: hit.SearchRank, Highlights: make(map[string]string)}
+		for name, snippet := range map[string]string{
+			"title":       hit.TitleHighlight,
+			"subtitle":    hit.SubtitleHighlight,
+			"description": hit.DescriptionHighlight,
+			"tags":        hit.TagsHighlight,
+			"body":        hit.BodyHighlight,
+		} {
+			// headlines are returned for every field, the matching ones have marks
+			if strings.Contains(snippet, "<mark>") {
+				result.Highlights[name] = snippet
+			}
+		}
+		results = append(results, &result)
+	}
+	return results, nil
+}
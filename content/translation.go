@@ -0,0 +1,239 @@
+package content
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/log"
+	"sort"
+	"time"
+)
+
+// TranslationGroup is the group of the contents sharing an IdTranslate, by locale.
+// Missing are the languages of the application without a translation
+type TranslationGroup struct {
+	IdTranslate  string
+	Translations map[string]*Content
+	Missing      []string
+}
+
+func (group *TranslationGroup) Id() string {
+	return group.IdTranslate
+}
+
+func (group *TranslationGroup) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		IdTranslate  string              `json:"idTranslate"`
+		Translations map[string]*Content `json:"translations"`
+		Missing      []string            `json:"missing"`
+	}{group.IdTranslate, group.Translations, group.Missing})
+}
+
+func (group *TranslationGroup) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(group)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (group *TranslationGroup) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// NewTranslationController returns the controller of the translations of the datastore content with the given key
+func NewTranslationController(contentKey string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: TranslationManager{ContentKey: contentKey}}
+	c := spellbook.NewRestController(handler)
+	c.Key = contentKey
+	return c
+}
+
+// NewSqlTranslationController returns the controller of the translations of the sql content with the given key
+func NewSqlTranslationController(contentKey string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: TranslationManager{ContentKey: contentKey, Content: SqlContentManager{}.content()}}
+	c := spellbook.NewRestController(handler)
+	c.Key = contentKey
+	return c
+}
+
+// TranslationManager handles the translation group of the content with the given key:
+// getting it returns the group, posting a content to it creates the translation of the content in the posted locale,
+// e.g. {"locale": "en", "slug": "..."}, and deleting it deletes every translation of the group.
+// The zero Content manager stores the contents in the datastore
+type TranslationManager struct {
+	ContentKey string
+	Content    ContentManager
+}
+
+// Describe describes the translation groups for the OpenAPI document
+func (manager TranslationManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "TranslationGroup",
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadContent},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteContent},
+	}
+}
+
+// NewResource returns the translation to create, see Create
+func (manager TranslationManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return &Content{}, nil
+}
+
+// FromId returns the translation group of the content with the given key
+func (manager TranslationManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	source, err := manager.Content.contents().FromId(ctx, id)
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve content %s: %s", id, err.Error())
+		return nil, err
+	}
+
+	translations, err := manager.Content.translationsOf(ctx, source.(*Content))
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve the translations of content %s: %s", id, err.Error())
+		return nil, err
+	}
+
+	group := TranslationGroup{IdTranslate: source.(*Content).IdTranslate, Translations: make(map[string]*Content), Missing: make([]string, 0)}
+	for _, t := range translations {
+		group.Translations[t.Locale] = t
+	}
+	for _, l := range spellbook.Application().Options().Languages {
+		if _, ok := group.Translations[l.String()]; !ok {
+			group.Missing = append(group.Missing, l.String())
+		}
+	}
+	sort.Strings(group.Missing)
+	return &group, nil
+}
+
+func (manager TranslationManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager TranslationManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// Create creates the translation of the content in the locale of the posted content, see ContentManager.Translate
+func (manager TranslationManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	source, err := manager.Content.contents().FromId(ctx, manager.ContentKey)
+	if err != nil {
+		return err
+	}
+	return manager.Content.Translate(ctx, source.(*Content), res.(*Content))
+}
+
+func (manager TranslationManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// Delete deletes every translation of the group through the content manager.
+// Each translation is deleted on its own, so that a failure leaves the group partially deleted
+func (manager TranslationManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	group := res.(*TranslationGroup)
+	locales := make([]string, 0, len(group.Translations))
+	for locale := range group.Translations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+
+	for _, locale := range locales {
+		translation := group.Translations[locale]
+		if err := manager.Content.Delete(ctx, translation); err != nil {
+			log.Errorf(ctx, "error deleting the %s translation of group %s: %s", locale, group.IdTranslate, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the contents sharing the IdTranslate of the content, the content included
+func (manager ContentManager) translationsOf(ctx context.Context, content *Content) ([]*Content, error) {
+	resources, err := manager.contents().ListOf(ctx, spellbook.Query{Filters: []spellbook.Filter{
+		{Field: "IdTranslate", Operator: spellbook.FilterEqual, Value: content.IdTranslate},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	translations := make([]*Content, len(resources))
+	for i := range resources {
+		translations[i] = resources[i].(*Content)
+	}
+	return translations, nil
+}
+
+// Translate creates the translation of the source content in the locale of the translation, as a draft.
+// The title, subtitle, body and description of the translation replace the ones of the source when set,
+// non special translations without a slug get one from their title.
+// The translation gets the other fields and a copy of the attachments of the source
+func (manager ContentManager) Translate(ctx context.Context, source *Content, translation *Content) error {
+	if translation.Locale == "" {
+		return spellbook.NewFieldError("locale", errors.New("locale can't be empty"))
+	}
+
+	if languages := spellbook.Application().Options().Languages; len(languages) > 0 {
+		supported := false
+		for _, l := range languages {
+			supported = supported || l.String() == translation.Locale
+		}
+		if !supported {
+			return spellbook.NewFieldError("locale", fmt.Errorf("unsupported locale %q", translation.Locale))
+		}
+	}
+
+	attachments, err := manager.attachmentsOf(ctx, source)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the attachments of content %s: %s", source.Slug, err)
+		return err
+	}
+
+	clone := *source
+	clone.Model = translation.Model
+	clone.ID = 0
+	clone.Updated = time.Time{}
+	clone.Locale = translation.Locale
+	clone.setSlug(translation.Slug)
+	clone.setCode(source.getCode())
+	if translation.Title != "" {
+		clone.Title = translation.Title
+	}
+	if translation.Subtitle != "" {
+		clone.Subtitle = translation.Subtitle
+	}
+	if translation.Body != "" {
+		clone.Body = translation.Body
+	}
+	if translation.Description != "" {
+		clone.Description = translation.Description
+	}
+	// translations start over as drafts, with their own schedule
+	clone.Published = time.Time{}
+	clone.PublishAt = time.Time{}
+	clone.UnpublishAt = time.Time{}
+
+	clone.Attachments = make([]*Attachment, len(attachments))
+	for i, att := range attachments {
+		clone.Attachments[i] = &Attachment{
+			Name:             att.Name,
+			Description:      att.Description,
+			ResourceUrl:      att.ResourceUrl,
+			ResourceThumbUrl: att.ResourceThumbUrl,
+			Group:            att.Group,
+			Type:             att.Type,
+			AltText:          att.AltText,
+			DisplayOrder:     att.DisplayOrder,
+			Uploader:         att.Uploader,
+		}
+	}
+
+	*translation = clone
+	return manager.Create(ctx, translation, nil)
+}
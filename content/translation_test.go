@@ -0,0 +1,48 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"testing"
+)
+
+func TestTranslationGroup(t *testing.T) {
+	ctx := spellbooktest.NewContext(spellbooktest.NewUser("writer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent))
+	content := newMemoryManager()
+	source := newContent(t, ctx, content, `{"type":"page","title":"Hello","slug":"hello","locale":"en","body":"<p>hello</p>","subtitle":"greetings"}`)
+	manager := TranslationManager{ContentKey: source.Id(), Content: content}
+
+	translation := &Content{Locale: "it", Title: "Ciao"}
+	if err := manager.Create(ctx, translation, nil); err != nil {
+		t.Fatalf("error creating the translation: %s", err)
+	}
+	// the translation gets its own slug and the untranslated fields of the source
+	if translation.Slug != "ciao" || translation.Body != "<p>hello</p>" || translation.Subtitle != "greetings" || translation.IdTranslate != source.IdTranslate {
+		t.Errorf("got translation %+v", translation)
+	}
+
+	if err := manager.Create(ctx, &Content{Locale: "it", Title: "Salve"}, nil); err == nil {
+		t.Error("a second translation was created in the same locale")
+	}
+	if err := manager.Create(ctx, &Content{Title: "No locale"}, nil); err == nil {
+		t.Error("a translation was created without a locale")
+	}
+
+	res, err := manager.FromId(ctx, source.Id())
+	if err != nil {
+		t.Fatalf("error reading the group: %s", err)
+	}
+	group := res.(*TranslationGroup)
+	if len(group.Translations) != 2 || group.Translations["en"].Id() != source.Id() || group.Translations["it"].Id() != translation.Id() {
+		t.Errorf("got translations %v, want the source and its translation", group.Translations)
+	}
+
+	if err := manager.Delete(ctx, group); err != nil {
+		t.Fatalf("error deleting the group: %s", err)
+	}
+	for _, c := range []*Content{source, translation} {
+		if _, err := content.contents().FromId(ctx, c.Id()); !spellbook.IsNotFound(err) {
+			t.Errorf("got error %v reading the %s translation of the deleted group, want not found", err, c.Locale)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package content
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/spellbook"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"strconv"
+)
+
+// ChildrenPolicy is what happens to the children of a content, the contents with its key as ParentKey, when it is deleted
+type ChildrenPolicy int
+
+const (
+	// the children are moved to the parent of the deleted content, with their subtrees
+	ChildrenReparent ChildrenPolicy = iota
+	// the subtree of the content is deleted with it, in the same transaction, each descendant with its own trash item.
+	// Datastore transactions span at most 25 entity groups, which limits the subtrees that can be deleted
+	ChildrenCascade
+	// the content can't be deleted while it has children
+	ChildrenRestrict
+)
+
+// checks that the parent exists and that the content with the given id, empty if new, is not an ancestor of it
+func (manager ContentManager) validateParent(ctx context.Context, id string, parentKey string) error {
+	if parentKey == "" {
+		return nil
+	}
+	if parentKey == id {
+		return spellbook.NewFieldError("parent", errors.New("a content can't be its own parent"))
+	}
+
+	res, err := manager.contents().FromId(ctx, parentKey)
+	if spellbook.IsNotFound(err) {
+		return spellbook.NewFieldError("parent", fmt.Errorf("parent %s doesn't exist", parentKey))
+	}
+	if err != nil {
+		return err
+	}
+
+	ancestors, err := manager.ancestorsOf(ctx, res.(*Content))
+	if err != nil {
+		return err
+	}
+	for _, ancestor := range ancestors {
+		if id != "" && ancestor.Id() == id {
+			return spellbook.NewFieldError("parent", fmt.Errorf("content %s is a descendant of content %s", parentKey, id))
+		}
+	}
+	return nil
+}
+
+// returns the content and its ancestors, the root first.
+// Missing ancestors end the path, since their children are roots of their own
+func (manager ContentManager) ancestorsOf(ctx context.Context, content *Content) ([]*Content, error) {
+	path := []*Content{content}
+	seen := map[string]bool{content.Id(): true}
+	for key := content.ParentKey; key != ""; {
+		if seen[key] {
+			return nil, fmt.Errorf("the ancestors of content %s form a cycle through %s", content.Id(), key)
+		}
+		seen[key] = true
+
+		res, err := manager.contents().FromId(ctx, key)
+		if spellbook.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := res.(*Content)
+		path = append(path, parent)
+		key = parent.ParentKey
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// Ancestors returns the path from the root of the tree of the content to its parent, e.g. for breadcrumbs
+func (manager ContentManager) Ancestors(ctx context.Context, content *Content) ([]*Content, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	path, err := manager.ancestorsOf(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	return path[:len(path)-1], nil
+}
+
+// returns every child of the content, by display order
+func (manager ContentManager) childrenOf(ctx context.Context, content *Content) ([]*Content, error) {
+	resources, err := manager.contents().ListOf(ctx, spellbook.Query{
+		Filters: []spellbook.Filter{{Field: "ParentKey", Operator: spellbook.FilterEqual, Value: content.Id()}},
+		Order:   "Order",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]*Content, len(resources))
+	for i := range resources {
+		children[i] = resources[i].(*Content)
+	}
+	return children, nil
+}
+
+// Move moves the content, with its subtree, under the parent with the given key, or to the root if empty,
+// at the given display order. The move is an update of the content and stores its replaced revision
+func (manager ContentManager) Move(ctx context.Context, content *Content, parentKey string, order int) error {
+	current := spellbook.IdentityFromContext(ctx)
+	if current == nil || !current.HasPermission(spellbook.PermissionWriteContent) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteContent))
+	}
+
+	if err := manager.validateParent(ctx, content.Id(), parentKey); err != nil {
+		return err
+	}
+
+	return manager.update(ctx, content, nil, func() {
+		content.ParentKey = parentKey
+		content.Order = order
+	})
+}
+
+// returns the descendants of the content, the deepest first
+func (manager ContentManager) descendantsOf(ctx context.Context, content *Content) ([]*Content, error) {
+	var descendants []*Content
+	seen := map[string]bool{content.Id(): true}
+	for level := []*Content{content}; len(level) > 0; {
+		var next []*Content
+		for _, parent := range level {
+			children, err := manager.childrenOf(ctx, parent)
+			if err != nil {
+				return nil, err
+			}
+			for _, child := range children {
+				if !seen[child.Id()] {
+					seen[child.Id()] = true
+					next = append(next, child)
+				}
+			}
+		}
+		descendants = append(next, descendants...)
+		level = next
+	}
+	return descendants, nil
+}
+
+// handles the children of a deleted content according to the children policy of the manager.
+// The tree is read right away, since queries can't run in datastore transactions, while the returned function
+// moves the reparented children to the parent of the content, or removes the cascaded subtree,
+// and is meant to run in the transaction of the delete, so that a failure never leaves the tree half deleted.
+// The removed descendants are returned too
+func (manager ContentManager) orphaned(ctx context.Context, content *Content) (func(ctx context.Context) error, []*Content, error) {
+	if manager.Children == ChildrenCascade {
+		descendants, err := manager.descendantsOf(ctx, content)
+		if err != nil {
+			log.Errorf(ctx, "error retrieving the subtree of content %s: %s", content.Slug, err.Error())
+			return nil, nil, err
+		}
+
+		removals := make([]func(ctx context.Context) error, len(descendants))
+		for i, descendant := range descendants {
+			if removals[i], err = manager.removal(ctx, descendant); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return func(ctx context.Context) error {
+			for i, remove := range removals {
+				if err := remove(ctx); err != nil {
+					return fmt.Errorf("error deleting descendant %s of content %s: %s", descendants[i].Id(), content.Slug, err)
+				}
+			}
+			return nil
+		}, descendants, nil
+	}
+
+	children, err := manager.childrenOf(ctx, content)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the children of content %s: %s", content.Slug, err.Error())
+		return nil, nil, err
+	}
+
+	if manager.Children == ChildrenRestrict && len(children) > 0 {
+		return nil, nil, spellbook.NewFieldError("children", fmt.Errorf("content %s has %d children", content.Id(), len(children)))
+	}
+
+	return func(ctx context.Context) error {
+		for _, child := range children {
+			child.ParentKey = content.ParentKey
+			if err := manager.contents().Update(ctx, child); err != nil {
+				return fmt.Errorf("error moving child %s of content %s: %s", child.Id(), content.Slug, err)
+			}
+		}
+		return nil
+	}, nil, nil
+}
+
+// NewChildrenController returns the controller of the children of the datastore content with the given key
+func NewChildrenController(contentKey string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: ChildrenManager{ContentKey: contentKey}}
+	return spellbook.NewRestController(handler)
+}
+
+// NewSqlChildrenController returns the controller of the children of the sql content with the given key
+func NewSqlChildrenController(contentKey string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: ChildrenManager{ContentKey: contentKey, Content: SqlContentManager{}.content()}}
+	return spellbook.NewRestController(handler)
+}
+
+// ChildrenManager lists the children of the content with the given key, by display order unless ordered otherwise.
+// Children are created and moved through the contents, see ContentManager.Move.
+// The zero Content manager reads the datastore
+type ChildrenManager struct {
+	ContentKey string
+	Content    ContentManager
+}
+
+// Describe describes the children of the contents for the OpenAPI document
+func (manager ChildrenManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:            "Content",
+		Filterable:      contentFilterFields,
+		Orderable:       []string{"Order", "Title", "Created", "Updated", "Published"},
+		ReadPermissions: []spellbook.Permission{spellbook.PermissionReadContent},
+	}
+}
+
+func (manager ChildrenManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager ChildrenManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// ListOf returns the page of the children of the content
+func (manager ChildrenManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	if _, err := manager.Content.contents().FromId(ctx, manager.ContentKey); err != nil {
+		return nil, err
+	}
+
+	query := spellbook.QueryFromOptions(opts)
+	query.Filters = append(query.Filters, spellbook.Filter{Field: "ParentKey", Operator: spellbook.FilterEqual, Value: manager.ContentKey})
+	if query.Order == "" {
+		query.Order = "Order"
+	}
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+
+	resources, err := manager.Content.contents().ListOf(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the children of content %s: %s", manager.ContentKey, err.Error())
+		return nil, err
+	}
+	return resources, nil
+}
+
+func (manager ChildrenManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager ChildrenManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager ChildrenManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager ChildrenManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// TreeController renders the ancestors of the content with the given key on GET, root first,
+// and moves the content with its subtree on POST, to the "parent" key, or to the root if empty, at the given "order"
+type TreeController struct {
+	Manager    ContentManager
+	ContentKey string
+}
+
+// NewTreeController returns the controller of the position of the datastore content with the given key
+func NewTreeController(contentKey string) *TreeController {
+	return &TreeController{ContentKey: contentKey}
+}
+
+// NewSqlTreeController returns the controller of the position of the sql content with the given key
+func NewSqlTreeController(contentKey string) *TreeController {
+	return &TreeController{Manager: SqlContentManager{}.content(), ContentKey: contentKey}
+}
+
+func (controller *TreeController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	ins := spellbook.InputsFromContext(ctx)
+	method := ins[flamel.KeyRequestMethod].Value()
+	if method != http.MethodGet && method != http.MethodPost {
+		return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
+	}
+
+	res, err := controller.Manager.FromId(ctx, controller.ContentKey)
+	if err != nil {
+		return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(err), out)
+	}
+	content := res.(*Content)
+
+	if method == http.MethodPost {
+		order := content.Order
+		if oin, ok := ins["order"]; ok && oin.Value() != "" {
+			if order, err = strconv.Atoi(oin.Value()); err != nil {
+				return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(spellbook.NewFieldError("order", err)), out)
+			}
+		}
+		if err := controller.Manager.Move(ctx, content, ins["parent"].Value(), order); err != nil {
+			log.Errorf(ctx, "error moving content %s: %s", controller.ContentKey, err.Error())
+			return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(err), out)
+		}
+	}
+
+	ancestors, err := controller.Manager.Ancestors(ctx, content)
+	if err != nil {
+		log.Errorf(ctx, "error retrieving the ancestors of content %s: %s", controller.ContentKey, err.Error())
+		return spellbook.RenderProblem(ctx, spellbook.ProblemFromError(err), out)
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = struct {
+		Content   *Content   `json:"content"`
+		Ancestors []*Content `json:"ancestors"`
+	}{content, ancestors}
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *TreeController) OnDestroy(ctx context.Context) {}
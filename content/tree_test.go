@@ -0,0 +1,71 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"fmt"
+	"testing"
+)
+
+func TestTreeCycles(t *testing.T) {
+	ctx := spellbooktest.NewContext(spellbooktest.NewUser("writer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent))
+	manager := newMemoryManager()
+
+	// root > child > grandchild
+	root := newContent(t, ctx, manager, `{"type":"page","title":"Root","locale":"en"}`)
+	child := newContent(t, ctx, manager, fmt.Sprintf(`{"type":"page","title":"Child","locale":"en","parent":%q}`, root.Id()))
+	grandchild := newContent(t, ctx, manager, fmt.Sprintf(`{"type":"page","title":"Grandchild","locale":"en","parent":%q}`, child.Id()))
+
+	ancestors, err := manager.Ancestors(ctx, grandchild)
+	if err != nil || len(ancestors) != 2 || ancestors[0].Id() != root.Id() || ancestors[1].Id() != child.Id() {
+		t.Fatalf("got ancestors %v and error %v, want the root and the child", ancestors, err)
+	}
+
+	tests := []struct {
+		name    string
+		content *Content
+		parent  string
+	}{
+		{"under itself", root, root.Id()},
+		{"under its child", root, child.Id()},
+		{"under its grandchild", root, grandchild.Id()},
+		{"under its child, from the middle", child, grandchild.Id()},
+		{"under a missing parent", child, "999"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := test.content.ParentKey
+			err := manager.Move(ctx, test.content, test.parent, 0)
+			if _, ok := err.(spellbook.FieldError); !ok {
+				t.Errorf("got error %v, want a field error", err)
+			}
+
+			// the update path rejects the cycle too
+			bundle := fmt.Sprintf(`{"type":"page","title":%q,"slug":%q,"locale":"en","parent":%q}`, test.content.Title, test.content.Slug, test.parent)
+			if err := manager.Update(ctx, test.content, []byte(bundle)); err == nil {
+				t.Error("the update made the cycle")
+			}
+
+			res, err := manager.contents().FromId(ctx, test.content.Id())
+			if err != nil {
+				t.Fatalf("error reading the content: %s", err)
+			}
+			if parent := res.(*Content).ParentKey; parent != before {
+				t.Errorf("got parent %q, want %q", parent, before)
+			}
+		})
+	}
+
+	// the subtree moves with its root
+	if err := manager.Move(ctx, child, "", 1); err != nil {
+		t.Fatalf("error moving the child to the root: %s", err)
+	}
+	ancestors, err = manager.Ancestors(ctx, grandchild)
+	if err != nil || len(ancestors) != 1 || ancestors[0].Id() != child.Id() {
+		t.Errorf("got ancestors %v and error %v after the move, want the child", ancestors, err)
+	}
+	if err := manager.Move(ctx, root, grandchild.Id(), 0); err != nil {
+		t.Errorf("error moving the root under the former grandchild: %s", err)
+	}
+}
@@ -0,0 +1,331 @@
+package content
+
+import (
+	"context"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// editorial states of the contents besides published and unpublished.
+// Drafts are the unpublished contents, so that contents created before the workflow are drafts
+const (
+	PublicationStateDraft    PublicationState = PublicationStateUnpublished
+	PublicationStateInReview PublicationState = "IN_REVIEW"
+	PublicationStateApproved PublicationState = "APPROVED"
+	PublicationStateArchived PublicationState = "ARCHIVED"
+)
+
+// Transition moves the contents in one of the From states to the To state.
+// Only the users with the Permission can make it
+type Transition struct {
+	Name       string
+	From       []PublicationState
+	To         PublicationState
+	Permission spellbook.Permission
+}
+
+func (transition Transition) allows(from PublicationState) bool {
+	for _, state := range transition.From {
+		if state == from {
+			return true
+		}
+	}
+	return false
+}
+
+// Workflow is the set of transitions between the publication states of the contents
+type Workflow struct {
+	Transitions []Transition
+}
+
+// DefaultWorkflow is the workflow of the managers without one.
+// Reviewers approve or reject the submitted contents, writers publish them.
+// Drafts can be published without review, as they were before the workflow:
+// workflows requiring the review leave the draft state out of the publish transition
+var DefaultWorkflow = Workflow{Transitions: []Transition{
+	{Name: "submit", From: []PublicationState{PublicationStateDraft}, To: PublicationStateInReview, Permission: spellbook.PermissionWriteContent},
+	{Name: "reject", From: []PublicationState{PublicationStateInReview}, To: PublicationStateDraft, Permission: spellbook.PermissionReviewContent},
+	{Name: "approve", From: []PublicationState{PublicationStateInReview}, To: PublicationStateApproved, Permission: spellbook.PermissionReviewContent},
+	{Name: "publish", From: []PublicationState{PublicationStateDraft, PublicationStateApproved}, To: PublicationStatePublished, Permission: spellbook.PermissionWriteContent},
+	{Name: "unpublish", From: []PublicationState{PublicationStatePublished}, To: PublicationStateDraft, Permission: spellbook.PermissionWriteContent},
+	{Name: "archive", From: []PublicationState{PublicationStateDraft, PublicationStateApproved, PublicationStatePublished}, To: PublicationStateArchived, Permission: spellbook.PermissionWriteContent},
+	{Name: "reopen", From: []PublicationState{PublicationStateArchived}, To: PublicationStateDraft, Permission: spellbook.PermissionWriteContent},
+}}
+
+// returns the transition with the given name
+func (workflow Workflow) transition(name string) (Transition, bool) {
+	for _, t := range workflow.Transitions {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Transition{}, false
+}
+
+// returns the first transition between the two states
+func (workflow Workflow) between(from PublicationState, to PublicationState) (Transition, bool) {
+	for _, t := range workflow.Transitions {
+		if t.To == to && t.allows(from) {
+			return t, true
+		}
+	}
+	return Transition{}, false
+}
+
+// returns the publication state of the content,
+// derived from the publication date for the contents stored without one
+func (content *Content) state() PublicationState {
+	if content.PublicationState != "" {
+		return content.PublicationState
+	}
+	if content.IsPublished() {
+		return PublicationStatePublished
+	}
+	return PublicationStateDraft
+}
+
+// StateChange records a transition of a content, who made it and why
+type StateChange struct {
+	model.Model `json:"-"`
+	ID          uint `model:"-" json:"-"`
+	ContentKey  string
+	Transition  string
+	From        PublicationState
+	To          PublicationState
+	User        string
+	Reason      string `model:"noindex" gorm:"type:text"`
+	Created     time.Time
+}
+
+func (change StateChange) TableName() string {
+	return "content_state_changes"
+}
+
+// makes the transition of the content on behalf of the current user, returning the record of the change.
+// The content is changed but not stored
+func changeState(ctx context.Context, content *Content, transition Transition, reason string) (*StateChange, error) {
+	current := spellbook.IdentityFromContext(ctx)
+	if current == nil || !current.HasPermission(transition.Permission) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(transition.Permission))
+	}
+
+	var username string
+	if user, ok := current.(interface{ Username() string }); ok {
+		username = user.Username()
+	}
+	return makeTransition(content, transition, username, reason)
+}
+
+// makes the transition of the content on behalf of the user, without checking its permissions
+func makeTransition(content *Content, transition Transition, user string, reason string) (*StateChange, error) {
+	from := content.state()
+	if !transition.allows(from) {
+		return nil, spellbook.NewFieldError("transition", fmt.Errorf("can't %s a content in state %s", transition.Name, from))
+	}
+
+	content.PublicationState = transition.To
+	if transition.To != PublicationStatePublished {
+		content.Published = time.Time{}
+	} else if !content.IsPublished() {
+		content.Published = time.Now().UTC()
+	}
+
+	change := StateChange{ContentKey: content.Id(), Transition: transition.Name, From: from, To: transition.To, User: user, Reason: reason, Created: time.Now().UTC()}
+	return &change, nil
+}
+
+func (change *StateChange) Id() string {
+	if id := change.EncodedKey(); id != "" {
+		return id
+	}
+	return fmt.Sprintf("%d", change.ID)
+}
+
+func (change *StateChange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Id         string           `json:"id"`
+		Content    string           `json:"content"`
+		Transition string           `json:"transition"`
+		From       PublicationState `json:"from"`
+		To         PublicationState `json:"to"`
+		User       string           `json:"user"`
+		Reason     string           `json:"reason"`
+		Created    time.Time        `json:"created"`
+	}{
+		Id:         change.Id(),
+		Content:    change.ContentKey,
+		Transition: change.Transition,
+		From:       change.From,
+		To:         change.To,
+		User:       change.User,
+		Reason:     change.Reason,
+		Created:    change.Created,
+	})
+}
+
+// UnmarshalJSON reads the requested transition and its reason, the rest of the change is set by the manager
+func (change *StateChange) UnmarshalJSON(data []byte) error {
+	alias := struct {
+		Transition string `json:"transition"`
+		Reason     string `json:"reason"`
+	}{}
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	change.Transition = alias.Transition
+	change.Reason = alias.Reason
+	return nil
+}
+
+func (change *StateChange) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(change)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (change *StateChange) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Unmarshal(data, change)
+	}
+	return spellbook.NewUnsupportedError()
+}
+
+// fields the state changes can be filtered by
+var stateChangeFilterFields = spellbook.FilterFields{
+	"ContentKey": spellbook.FieldString,
+	"Transition": spellbook.FieldString,
+	"From":       spellbook.FieldString,
+	"To":         spellbook.FieldString,
+	"User":       spellbook.FieldString,
+	"Created":    spellbook.FieldTime,
+}
+
+// NewTransitionController returns the controller of the transitions of the datastore content with the given key
+func NewTransitionController(contentKey string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: TransitionManager{ContentKey: contentKey}}
+	return spellbook.NewRestController(handler)
+}
+
+// NewSqlTransitionController returns the controller of the transitions of the sql content with the given key
+func NewSqlTransitionController(contentKey string) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: TransitionManager{ContentKey: contentKey, Content: SqlContentManager{}.content()}}
+	return spellbook.NewRestController(handler)
+}
+
+// TransitionManager makes the transitions of the content with the given key and lists the past ones.
+// A transition is made by creating a state change with its name and reason, e.g. {"transition": "approve", "reason": "..."}.
+// The zero Content manager reads the datastore
+type TransitionManager struct {
+	ContentKey string
+	Content    ContentManager
+}
+
+func (manager TransitionManager) stateChanges() (spellbook.Repository, error) {
+	if r := manager.Content.stateChanges(); r != nil {
+		return r, nil
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// Describe describes the state changes for the OpenAPI document
+func (manager TransitionManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "StateChange",
+		Filterable:       stateChangeFilterFields,
+		Orderable:        []string{"Created"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadContent},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteContent, spellbook.PermissionReviewContent},
+	}
+}
+
+func (manager TransitionManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return &StateChange{}, nil
+}
+
+func (manager TransitionManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	changes, err := manager.stateChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := changes.FromId(ctx, id)
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve state change %s: %s", id, err.Error())
+		return nil, err
+	}
+
+	if change.(*StateChange).ContentKey != manager.ContentKey {
+		return nil, spellbook.ErrNotFound
+	}
+	return change, nil
+}
+
+// ListOf lists the transitions made on the content, the last first
+func (manager TransitionManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadContent) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadContent))
+	}
+
+	changes, err := manager.stateChanges()
+	if err != nil {
+		return nil, err
+	}
+
+	query := spellbook.QueryFromOptions(opts)
+	query.Filters = append(query.Filters, spellbook.Filter{Field: "ContentKey", Operator: spellbook.FilterEqual, Value: manager.ContentKey})
+	if query.Order == "" {
+		query.Order = "Created"
+		query.Descending = true
+	}
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+
+	return changes.ListOf(ctx, query)
+}
+
+func (manager TransitionManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// Create makes the transition named by the state change.
+// Permissions are the ones of the transition, so that reviewers can approve the contents they can't edit
+func (manager TransitionManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	change := res.(*StateChange)
+	if change.Transition == "" {
+		return spellbook.NewFieldError("transition", errors.New("transition can't be empty"))
+	}
+
+	content, err := manager.Content.contents().FromId(ctx, manager.ContentKey)
+	if err != nil {
+		return err
+	}
+
+	made, err := manager.Content.Transition(ctx, content.(*Content), change.Transition, change.Reason)
+	if err != nil {
+		return err
+	}
+	*change = *made
+	return nil
+}
+
+// state changes are the history of the content
+func (manager TransitionManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+func (manager TransitionManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	return spellbook.NewUnsupportedError()
+}
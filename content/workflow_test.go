@@ -0,0 +1,77 @@
+package content
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/spellbooktest"
+	"testing"
+)
+
+func TestWorkflowTransitions(t *testing.T) {
+	writer := spellbooktest.NewUser("writer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent)
+	reviewer := spellbooktest.NewUser("reviewer", spellbook.PermissionReadContent, spellbook.PermissionWriteContent, spellbook.PermissionReviewContent)
+
+	tests := []struct {
+		name       string
+		user       spellbook.Identity
+		from       PublicationState
+		transition string
+		want       PublicationState
+		// the error expected, if any
+		permission bool
+		invalid    bool
+	}{
+		{"submit", writer, PublicationStateDraft, "submit", PublicationStateInReview, false, false},
+		{"approve without the review permission", writer, PublicationStateInReview, "approve", PublicationStateInReview, true, false},
+		{"reject without the review permission", writer, PublicationStateInReview, "reject", PublicationStateInReview, true, false},
+		{"approve", reviewer, PublicationStateInReview, "approve", PublicationStateApproved, false, false},
+		{"reject", reviewer, PublicationStateInReview, "reject", PublicationStateDraft, false, false},
+		{"publish the approved", writer, PublicationStateApproved, "publish", PublicationStatePublished, false, false},
+		{"publish in review", reviewer, PublicationStateInReview, "publish", PublicationStateInReview, false, true},
+		{"unknown transition", reviewer, PublicationStateDraft, "print", PublicationStateDraft, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := spellbooktest.NewContext(writer)
+			manager := newMemoryManager()
+			content := newContent(t, ctx, manager, `{"type":"page","title":"Reviewed","locale":"en"}`)
+			content.PublicationState = test.from
+			if err := manager.contents().Update(ctx, content); err != nil {
+				t.Fatalf("error updating the content: %s", err)
+			}
+
+			change, err := manager.Transition(spellbooktest.NewContext(test.user), content, test.transition, "because")
+			_, permission := err.(spellbook.PermissionError)
+			_, invalid := err.(spellbook.FieldError)
+			if permission != test.permission || invalid != test.invalid || (err == nil) != (!test.permission && !test.invalid) {
+				t.Fatalf("got error %v, want permission error %t and field error %t", err, test.permission, test.invalid)
+			}
+
+			res, err := manager.contents().FromId(ctx, content.Id())
+			if err != nil {
+				t.Fatalf("error reading the content: %s", err)
+			}
+			if state := res.(*Content).state(); state != test.want {
+				t.Errorf("got state %s, want %s", state, test.want)
+			}
+
+			changes, err := manager.StateChanges.ListOf(ctx, spellbook.Query{})
+			if err != nil {
+				t.Fatalf("error reading the state changes: %s", err)
+			}
+			if change == nil {
+				if len(changes) != 0 {
+					t.Errorf("got state changes %v of a failed transition", changes)
+				}
+				return
+			}
+			if len(changes) != 1 {
+				t.Fatalf("got %d state changes, want 1", len(changes))
+			}
+			recorded := changes[0].(*StateChange)
+			if recorded.From != test.from || recorded.To != test.want || recorded.User != test.user.(interface{ Username() string }).Username() || recorded.Reason != "because" {
+				t.Errorf("got state change %+v", recorded)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package spellbook
 import (
 	"context"
 	"decodica.com/flamel"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
 	"net/http"
 )
 
@@ -29,3 +31,50 @@ func (controller *TemporaryRedirectController) Process(ctx context.Context, out
 }
 
 func (controller *TemporaryRedirectController) OnDestroy(ctx context.Context) {}
+
+// ResaveController saves again all the resources of the Resavers, e.g. after the properties of a type have changed.
+// It is meant to be run by a cron job or a task authenticated by the CronSecret, see IsCron, or by an appengine admin
+type ResaveController struct {
+	Resavers []Resaver
+}
+
+func (controller *ResaveController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	if !IsCron(ctx) && !user.IsAdmin(ctx) {
+		return flamel.HttpResponse{Status: http.StatusForbidden}
+	}
+
+	saved := 0
+	for _, resaver := range controller.Resavers {
+		n, err := resaver.Resave(ctx)
+		saved += n
+		if err != nil {
+			log.Errorf(ctx, "error saving the resources again, %d saved: %s", saved, err.Error())
+			return flamel.HttpResponse{Status: http.StatusInternalServerError}
+		}
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = struct {
+		Saved int `json:"saved"`
+	}{saved}
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *ResaveController) OnDestroy(ctx context.Context) {}
+
+// AdminController runs the Controller only for the appengine admins, e.g. for the maintenance reports
+type AdminController struct {
+	Controller flamel.Controller
+}
+
+func (controller *AdminController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	if !user.IsAdmin(ctx) {
+		return flamel.HttpResponse{Status: http.StatusForbidden}
+	}
+	return controller.Controller.Process(ctx, out)
+}
+
+func (controller *AdminController) OnDestroy(ctx context.Context) {
+	controller.Controller.OnDestroy(ctx)
+}
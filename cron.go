@@ -0,0 +1,21 @@
+package spellbook
+
+import (
+	"context"
+	"crypto/subtle"
+)
+
+// HeaderCronSecret carries the CronSecret of the application options on the requests of the scheduled jobs.
+// Jobs that can't set headers, like the App Engine cron, pass it as a query parameter
+const HeaderCronSecret = "X-Cron-Secret"
+
+// IsCron reports if the request has been made by a scheduled job, which authenticates with the CronSecret of the options.
+// Headers set by the platform, e.g. X-Appengine-Cron, can't be trusted: the inputs merge them with the query parameters
+func IsCron(ctx context.Context) bool {
+	secret := Application().Options().CronSecret
+	if secret == "" {
+		return false
+	}
+	given := InputsFromContext(ctx)[HeaderCronSecret].Value()
+	return subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
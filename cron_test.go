@@ -0,0 +1,35 @@
+package spellbook
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIsCron(t *testing.T) {
+	defer Application().SetOptions(Application().Options())
+
+	tests := []struct {
+		name   string
+		secret string
+		ins    Inputs
+		want   bool
+	}{
+		{"secret", "s3cret", Inputs{HeaderCronSecret: NewInput("s3cret")}, true},
+		{"wrong secret", "s3cret", Inputs{HeaderCronSecret: NewInput("secret")}, false},
+		{"missing secret", "s3cret", Inputs{}, false},
+		{"platform header", "s3cret", Inputs{"X-Appengine-Cron": NewInput("true")}, false},
+		{"no configured secret", "", Inputs{HeaderCronSecret: NewInput("")}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := Application().Options()
+			opts.CronSecret = test.secret
+			Application().SetOptions(opts)
+
+			if got := IsCron(ContextWithInputs(context.Background(), test.ins)); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
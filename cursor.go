@@ -0,0 +1,208 @@
+package spellbook
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// CursorManager is implemented by managers that support cursor based pagination.
+// ListOfWithCursor behaves like ListOf, honoring ListOptions.Cursor when set,
+// and returns the cursor of the next page, or an empty string if there are no more results
+type CursorManager interface {
+	ListOfWithCursor(ctx context.Context, opts ListOptions) ([]Resource, string, error)
+}
+
+// Cursor is the decoded form of the opaque cursor exchanged with the clients.
+// A cursor points after the last resource of a page:
+// Value is the JSON encoded value of the order field of that resource and
+// Ids are the ids of the resources sharing that value that have already been returned.
+// Lists without an order fall back to an offset, while Token carries the page token
+// of backends that provide their own, like Cloud Storage
+type Cursor struct {
+	Order  string          `json:"o,omitempty"`
+	Value  json.RawMessage `json:"v,omitempty"`
+	Ids    []string        `json:"i,omitempty"`
+	Offset int             `json:"n,omitempty"`
+	Token  string          `json:"t,omitempty"`
+}
+
+func (cursor Cursor) Encode() string {
+	j, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+// DecodeCursor decodes a cursor received from a client.
+// An empty string decodes to the zero Cursor
+func DecodeCursor(s string) (Cursor, error) {
+	cursor := Cursor{}
+	if s == "" {
+		return cursor, nil
+	}
+
+	j, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, NewFieldError("cursor", errors.New("invalid cursor"))
+	}
+
+	if err := json.Unmarshal(j, &cursor); err != nil {
+		return cursor, NewFieldError("cursor", errors.New("invalid cursor"))
+	}
+	return cursor, nil
+}
+
+// IsKeyset reports if the cursor points to a value of the order field instead of an offset
+func (cursor Cursor) IsKeyset() bool {
+	return len(cursor.Value) > 0
+}
+
+// OrderValue decodes the value of the cursor into the type of the order field of the given prototype
+func (cursor Cursor) OrderValue(prototype interface{}) (interface{}, error) {
+	f, ok := orderField(reflect.TypeOf(prototype), cursor.Order)
+	if !ok {
+		return nil, NewFieldError("cursor", fmt.Errorf("invalid cursor order %s", cursor.Order))
+	}
+
+	v := reflect.New(f.Type)
+	if err := json.Unmarshal(cursor.Value, v.Interface()); err != nil {
+		return nil, NewFieldError("cursor", errors.New("invalid cursor value"))
+	}
+	return v.Elem().Interface(), nil
+}
+
+// Page removes from the results of a query the resources already returned with the previous page
+// and trims them to the page size plus one, so that the handler can tell if there are more results.
+// Returns the trimmed results and the cursor of the next page
+func (cursor Cursor) Page(results []Resource, opts ListOptions) ([]Resource, string, error) {
+	results = cursor.skip(results)
+	if len(results) > opts.Size+1 {
+		results = results[:opts.Size+1]
+	}
+
+	next, err := NextCursor(cursor, results, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return results, next, nil
+}
+
+// removes from the given resources the ones already returned with the previous page
+func (cursor Cursor) skip(resources []Resource) []Resource {
+	if len(cursor.Ids) == 0 {
+		return resources
+	}
+
+	seen := make(map[string]bool, len(cursor.Ids))
+	for _, id := range cursor.Ids {
+		seen[id] = true
+	}
+
+	filtered := make([]Resource, 0, len(resources))
+	for _, r := range resources {
+		if !seen[r.Id()] {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// NextCursor builds the cursor of the page following the given results.
+// results must contain one more resource than the page size if there are more pages,
+// as returned by ListOf, and must be sorted by opts.Order.
+// An empty string is returned if there are no more results
+func NextCursor(prev Cursor, results []Resource, opts ListOptions) (string, error) {
+	if len(results) <= opts.Size || opts.Size == 0 {
+		return "", nil
+	}
+	page := results[:opts.Size]
+
+	if opts.Order == "" {
+		offset := prev.Offset
+		if offset == 0 {
+			offset = opts.Page * opts.Size
+		}
+		return Cursor{Offset: offset + len(page)}.Encode(), nil
+	}
+
+	next := Cursor{Order: opts.Order}
+	for i := len(page) - 1; i >= 0; i-- {
+		v, err := orderValue(page[i], opts.Order)
+		if err != nil {
+			return "", err
+		}
+		if next.Value == nil {
+			next.Value = v
+		} else if string(next.Value) != string(v) {
+			break
+		}
+		next.Ids = append(next.Ids, page[i].Id())
+	}
+
+	// the whole page shares the same value of the previous one
+	if prev.Order == next.Order && string(prev.Value) == string(next.Value) {
+		next.Ids = append(next.Ids, prev.Ids...)
+	}
+
+	return next.Encode(), nil
+}
+
+// returns the JSON encoded value of the order field of the resource
+func orderValue(resource Resource, order string) (json.RawMessage, error) {
+	v := reflect.Indirect(reflect.ValueOf(resource))
+	f, ok := orderField(v.Type(), order)
+	if !ok {
+		return nil, NewFieldError("order", fmt.Errorf("invalid order field %s", order))
+	}
+	return json.Marshal(v.FieldByIndex(f.Index).Interface())
+}
+
+// finds the field matching the given order, either in its Go or column form
+func orderField(t reflect.Type, order string) (reflect.StructField, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+
+	name := strings.Replace(order, "_", "", -1)
+	return t.FieldByNameFunc(func(field string) bool {
+		return strings.EqualFold(field, name)
+	})
+}
+
+// PageQuery returns the query of the page requested by the list options, either by cursor or by offset.
+// The query fetches one more resource than the page size, plus the ones already returned
+// with the previous page: the returned cursor pages its results through Cursor.Page.
+// prototype is a resource of the listed type, used to decode the cursor value
+func PageQuery(opts ListOptions, prototype interface{}) (Query, Cursor, error) {
+	query := QueryFromOptions(opts)
+
+	cursor, err := DecodeCursor(opts.Cursor)
+	if err != nil {
+		return query, cursor, err
+	}
+
+	switch {
+	case cursor.IsKeyset():
+		if cursor.Order != opts.Order {
+			return query, cursor, NewFieldError("cursor", errors.New("the cursor doesn't match the requested order"))
+		}
+		v, err := cursor.OrderValue(prototype)
+		if err != nil {
+			return query, cursor, err
+		}
+		query.Start = v
+	case cursor.Offset > 0:
+		query.Offset = cursor.Offset
+	default:
+		query.Offset = opts.Page * opts.Size
+	}
+
+	query.Limit = opts.Size + 1 + len(cursor.Ids)
+	return query, cursor, nil
+}
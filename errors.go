@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var ErrMissingField = errors.New("missing field")
 
+var errMissingBody = errors.New("missing json body")
+
 // Errors is a response object that contains the list of possible request errors
 type Errors struct {
 	errors []FieldError
@@ -29,6 +32,14 @@ func (errs *Errors) Clear() {
 	errs.errors = nil
 }
 
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs.errors))
+	for i, err := range errs.errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
 func (errs Errors) MarshalJSON() ([]byte, error) {
 	return json.Marshal(errs.errors)
 }
@@ -0,0 +1,57 @@
+package spellbook
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	HeaderETag        string = "ETag"
+	HeaderIfMatch     string = "If-Match"
+	HeaderIfNoneMatch string = "If-None-Match"
+)
+
+// Versioner is implemented by resources that expose their own version,
+// e.g. a revision number or the last update time.
+// The version is used to compute the ETag of the resource
+type Versioner interface {
+	Version() string
+}
+
+// Unversioned is implemented by managers whose resources can't be versioned.
+// If Unversioned returns true no ETag is emitted and conditional headers are ignored
+type Unversioned interface {
+	Unversioned() bool
+}
+
+// ETag returns the entity tag of the given resource.
+// If the resource implements Versioner, its version is used,
+// otherwise the tag is the hash of the JSON representation of the resource
+func ETag(resource Resource) (string, error) {
+	if v, ok := resource.(Versioner); ok {
+		return `"` + v.Version() + `"`, nil
+	}
+
+	j, err := resource.ToRepresentation(RepresentationTypeJSON)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(j)
+	return `"` + hex.EncodeToString(sum[:]) + `"`, nil
+}
+
+// reports if the etag matches any of the tags listed in an If-Match or If-None-Match header value.
+// Weak tags are compared by their opaque value
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,176 @@ package spellbook
 
 import "context"
 
+type extk string
+
+const extendersKey extk = "__extenders"
+
+// Extender hooks custom logic into the lifecycle of the resources handled by a RestController.
+// Before hooks are called ahead of the Manager action and can abort the request by returning an error,
+// usually a FieldError or a PermissionError, which is then converted to its HTTP status.
+// After hooks are called once the Manager action has succeeded.
+// If the Manager is Transactional the hooks run in its transaction, and an After hook error rolls back the action;
+// otherwise the action is already stored and After hook errors are logged, not returned to the client.
+// Embed BaseExtender to implement only the hooks that are needed.
 type Extender interface {
-	BeforeCreate(ctx context.Context, resource Resource) error
+	BeforeCreate(ctx context.Context, resource Resource, bundle []byte) error
+	AfterCreate(ctx context.Context, resource Resource, bundle []byte) error
+	BeforeUpdate(ctx context.Context, resource Resource, bundle []byte) error
+	AfterUpdate(ctx context.Context, resource Resource, bundle []byte) error
+	BeforeDelete(ctx context.Context, resource Resource) error
+	AfterDelete(ctx context.Context, resource Resource) error
+	BeforeGet(ctx context.Context, key string) error
+	AfterGet(ctx context.Context, resource Resource) error
+	BeforeList(ctx context.Context, opts *ListOptions) error
+	AfterList(ctx context.Context, opts ListOptions, resources []Resource) error
+}
+
+// BaseExtender implements every hook of the Extender interface as a no-op
+type BaseExtender struct{}
+
+func (extender BaseExtender) BeforeCreate(ctx context.Context, resource Resource, bundle []byte) error {
+	return nil
+}
+
+func (extender BaseExtender) AfterCreate(ctx context.Context, resource Resource, bundle []byte) error {
+	return nil
+}
+
+func (extender BaseExtender) BeforeUpdate(ctx context.Context, resource Resource, bundle []byte) error {
+	return nil
+}
+
+func (extender BaseExtender) AfterUpdate(ctx context.Context, resource Resource, bundle []byte) error {
+	return nil
+}
+
+func (extender BaseExtender) BeforeDelete(ctx context.Context, resource Resource) error {
+	return nil
+}
+
+func (extender BaseExtender) AfterDelete(ctx context.Context, resource Resource) error {
+	return nil
+}
+
+func (extender BaseExtender) BeforeGet(ctx context.Context, key string) error {
+	return nil
+}
+
+func (extender BaseExtender) AfterGet(ctx context.Context, resource Resource) error {
+	return nil
+}
+
+func (extender BaseExtender) BeforeList(ctx context.Context, opts *ListOptions) error {
+	return nil
+}
+
+func (extender BaseExtender) AfterList(ctx context.Context, opts ListOptions, resources []Resource) error {
+	return nil
+}
+
+// Extenders is the ordered list of extenders attached to a controller.
+// Each hook is called on every extender in registration order and stops at the first error.
+type Extenders []Extender
+
+func (extenders Extenders) BeforeCreate(ctx context.Context, resource Resource, bundle []byte) error {
+	for _, e := range extenders {
+		if err := e.BeforeCreate(ctx, resource, bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) AfterCreate(ctx context.Context, resource Resource, bundle []byte) error {
+	for _, e := range extenders {
+		if err := e.AfterCreate(ctx, resource, bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) BeforeUpdate(ctx context.Context, resource Resource, bundle []byte) error {
+	for _, e := range extenders {
+		if err := e.BeforeUpdate(ctx, resource, bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) AfterUpdate(ctx context.Context, resource Resource, bundle []byte) error {
+	for _, e := range extenders {
+		if err := e.AfterUpdate(ctx, resource, bundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) BeforeDelete(ctx context.Context, resource Resource) error {
+	for _, e := range extenders {
+		if err := e.BeforeDelete(ctx, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) AfterDelete(ctx context.Context, resource Resource) error {
+	for _, e := range extenders {
+		if err := e.AfterDelete(ctx, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) BeforeGet(ctx context.Context, key string) error {
+	for _, e := range extenders {
+		if err := e.BeforeGet(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) AfterGet(ctx context.Context, resource Resource) error {
+	for _, e := range extenders {
+		if err := e.AfterGet(ctx, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) BeforeList(ctx context.Context, opts *ListOptions) error {
+	for _, e := range extenders {
+		if err := e.BeforeList(ctx, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (extenders Extenders) AfterList(ctx context.Context, opts ListOptions, resources []Resource) error {
+	for _, e := range extenders {
+		if err := e.AfterList(ctx, opts, resources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtendersFromContext returns the extenders of the controller serving the request.
+// RestHandler implementations call the hooks on the returned list
+func ExtendersFromContext(ctx context.Context) Extenders {
+	if e, ok := ctx.Value(extendersKey).(Extenders); ok {
+		return e
+	}
+	return nil
+}
+
+func ContextWithExtenders(ctx context.Context, extenders Extenders) context.Context {
+	return context.WithValue(ctx, extendersKey, extenders)
 }
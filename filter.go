@@ -0,0 +1,210 @@
+package spellbook
+
+import (
+	"decodica.com/flamel/model"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterOperator is the comparison applied by a Filter.
+//
+// Filters are passed with the filter input, separated by ^:
+//
+//	filter=Locale=it^Published>=2020-01-01^Category:in:news,events
+//
+// Comparison operators are written between the field and the value: = != < <= > >=.
+// The other operators use the Field:operator:value form:
+//
+//	Field:in:a,b,c   the field is one of the comma separated values
+//	Field:prefix:abc the field starts with the value
+//	Field:null:      the field is null or, on datastore, holds its zero value
+//	Field:notnull:   the opposite of null
+type FilterOperator string
+
+const (
+	FilterEqual          FilterOperator = "="
+	FilterNotEqual       FilterOperator = "!="
+	FilterLess           FilterOperator = "<"
+	FilterLessOrEqual    FilterOperator = "<="
+	FilterGreater        FilterOperator = ">"
+	FilterGreaterOrEqual FilterOperator = ">="
+	FilterIn             FilterOperator = "in"
+	FilterPrefix         FilterOperator = "prefix"
+	FilterNull           FilterOperator = "null"
+	FilterNotNull        FilterOperator = "notnull"
+)
+
+// comparison operators, longest first so that <= is matched before <
+var symbolOperators = []FilterOperator{FilterNotEqual, FilterLessOrEqual, FilterGreaterOrEqual, FilterEqual, FilterLess, FilterGreater}
+
+var namedOperators = map[FilterOperator]bool{FilterIn: true, FilterPrefix: true, FilterNull: true, FilterNotNull: true}
+
+// ParseFilter parses a single filter expression
+func ParseFilter(expr string) (Filter, error) {
+	if parts := strings.SplitN(expr, ":", 3); len(parts) == 3 && namedOperators[FilterOperator(parts[1])] {
+		return Filter{Field: parts[0], Operator: FilterOperator(parts[1]), Value: parts[2]}, nil
+	}
+
+	i := strings.IndexAny(expr, "!<>=")
+	if i <= 0 {
+		return Filter{}, NewFieldError("filter", fmt.Errorf("invalid filter %s", expr))
+	}
+
+	for _, op := range symbolOperators {
+		if strings.HasPrefix(expr[i:], string(op)) {
+			return Filter{Field: expr[:i], Operator: op, Value: expr[i+len(op):]}, nil
+		}
+	}
+	return Filter{}, NewFieldError("filter", fmt.Errorf("invalid filter %s", expr))
+}
+
+// Values returns the values of the filter. Only the in operator has more than one value
+func (filter Filter) Values() []string {
+	if filter.Operator == FilterIn {
+		return strings.Split(filter.Value, ",")
+	}
+	return []string{filter.Value}
+}
+
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldInt
+	FieldTime
+	FieldBool
+)
+
+// FilterFields is the whitelist of the fields a manager can be filtered by, with their type.
+// Fields are named after the struct field of the resource
+type FilterFields map[string]FieldType
+
+// Value converts a filter value to the type of the field
+func (fields FilterFields) Value(field string, value string) (interface{}, error) {
+	ft, ok := fields[field]
+	if !ok {
+		return nil, NewFieldError("filter", fmt.Errorf("field %s can't be filtered", field))
+	}
+
+	switch ft {
+	case FieldInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, NewFieldError("filter", fmt.Errorf("invalid value %s for field %s: must be an integer", value, field))
+		}
+		return i, nil
+	case FieldTime:
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return nil, NewFieldError("filter", fmt.Errorf("invalid value %s for field %s: must be a date", value, field))
+		}
+		return t, nil
+	case FieldBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, NewFieldError("filter", fmt.Errorf("invalid value %s for field %s: must be a boolean", value, field))
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// Zero returns the zero value of the field type
+func (fields FilterFields) Zero(field string) interface{} {
+	switch fields[field] {
+	case FieldInt:
+		return int64(0)
+	case FieldTime:
+		return time.Time{}
+	case FieldBool:
+		return false
+	}
+	return ""
+}
+
+// Validate checks that the filters only refer to whitelisted fields with valid values
+func (fields FilterFields) Validate(filters []Filter) error {
+	for _, filter := range filters {
+		if filter.Field == "" {
+			continue
+		}
+		if filter.Operator == FilterNull || filter.Operator == FilterNotNull {
+			if _, ok := fields[filter.Field]; !ok {
+				return NewFieldError("filter", fmt.Errorf("field %s can't be filtered", filter.Field))
+			}
+			continue
+		}
+		if filter.Operator == FilterPrefix && fields[filter.Field] != FieldString {
+			return NewFieldError("filter", fmt.Errorf("prefix can only be applied to text fields"))
+		}
+		for _, v := range filter.Values() {
+			if _, err := fields.Value(filter.Field, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// returns the fields compared by the inequality operators of the filters, in order of appearance
+func inequalityFields(filters []Filter) []string {
+	var fields []string
+	seen := map[string]bool{}
+	for _, filter := range filters {
+		switch filter.Operator {
+		case FilterLess, FilterLessOrEqual, FilterGreater, FilterGreaterOrEqual, FilterPrefix, FilterNotNull, FilterNotEqual:
+			if filter.Field != "" && !seen[filter.Field] {
+				seen[filter.Field] = true
+				fields = append(fields, filter.Field)
+			}
+		}
+	}
+	return fields
+}
+
+// FilterQuery applies the filters to a datastore query.
+// A datastore query can't apply the != and in operators, which are rejected: DatastoreMerged runs the queries of their values.
+// Datastore doesn't support inequality operators on more than one field either, != included
+func FilterQuery(q *model.Query, fields FilterFields, filters []Filter) (*model.Query, error) {
+	if err := fields.Validate(filters); err != nil {
+		return nil, err
+	}
+
+	if inequalities := inequalityFields(filters); len(inequalities) > 1 {
+		return nil, NewFieldError("filter", fmt.Errorf("inequality filters can only be applied to one field, not to %s", strings.Join(inequalities, " and ")))
+	}
+
+	for _, filter := range filters {
+		if filter.Field == "" {
+			continue
+		}
+
+		switch filter.Operator {
+		case FilterNotEqual, FilterIn:
+			return nil, NewFieldError("filter", fmt.Errorf("operator %s is not supported", filter.Operator))
+		case FilterPrefix:
+			q = q.WithField(filter.Field+" >=", filter.Value)
+			q = q.WithField(filter.Field+" <", filter.Value+"\ufffd")
+		case FilterNull:
+			q = q.WithField(filter.Field+" =", fields.Zero(filter.Field))
+		case FilterNotNull:
+			q = q.WithField(filter.Field+" >", fields.Zero(filter.Field))
+		case "", FilterEqual, FilterLess, FilterLessOrEqual, FilterGreater, FilterGreaterOrEqual:
+			op := filter.Operator
+			if op == "" {
+				op = FilterEqual
+			}
+			v, _ := fields.Value(filter.Field, filter.Value)
+			q = q.WithField(fmt.Sprintf("%s %s", filter.Field, op), v)
+		default:
+			return nil, NewFieldError("filter", errors.New("unknown filter operator"))
+		}
+	}
+	return q, nil
+}
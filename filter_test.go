@@ -0,0 +1,199 @@
+package spellbook
+
+import (
+	"decodica.com/flamel/model"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want Filter
+	}{
+		{"Locale=it", Filter{Field: "Locale", Operator: FilterEqual, Value: "it"}},
+		{"Locale!=it", Filter{Field: "Locale", Operator: FilterNotEqual, Value: "it"}},
+		{"Order<3", Filter{Field: "Order", Operator: FilterLess, Value: "3"}},
+		{"Order<=3", Filter{Field: "Order", Operator: FilterLessOrEqual, Value: "3"}},
+		{"Order>3", Filter{Field: "Order", Operator: FilterGreater, Value: "3"}},
+		{"Published>=2020-01-01", Filter{Field: "Published", Operator: FilterGreaterOrEqual, Value: "2020-01-01"}},
+		{"Category:in:news,events", Filter{Field: "Category", Operator: FilterIn, Value: "news,events"}},
+		{"Slug:prefix:abc", Filter{Field: "Slug", Operator: FilterPrefix, Value: "abc"}},
+		{"Cover:null:", Filter{Field: "Cover", Operator: FilterNull}},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.expr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.expr, got, test.want)
+		}
+	}
+
+	for _, expr := range []string{"", "Locale", "=it"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestDatastoreQueryInequalities(t *testing.T) {
+	fields := FilterFields{"Published": FieldTime, "Created": FieldTime, "Locale": FieldString, "Order": FieldInt}
+	published := Filter{Field: "Published", Operator: FilterGreaterOrEqual, Value: "2020-01-01"}
+
+	tests := []struct {
+		name  string
+		query Query
+		field string
+	}{
+		{"inequality", Query{Filters: []Filter{published}}, ""},
+		{"ordered by the inequality field", Query{Filters: []Filter{published}, Order: "Published"}, ""},
+		{"two inequalities on the same field", Query{Filters: []Filter{published, {Field: "Published", Operator: FilterLess, Value: "2021-01-01"}}, Order: "Published"}, ""},
+		{"equality ordered by another field", Query{Filters: []Filter{{Field: "Locale", Value: "it"}}, Order: "Created"}, ""},
+		{"inequality ordered by another field", Query{Filters: []Filter{published}, Order: "Created", Descending: true}, "order"},
+		{"inequality started by another field", Query{Filters: []Filter{published}, Order: "Created", Start: "2020-01-01"}, "order"},
+		{"inequalities on two fields", Query{Filters: []Filter{published, {Field: "Order", Operator: FilterGreater, Value: "1"}}}, "filter"},
+		{"prefix and inequality", Query{Filters: []Filter{{Field: "Locale", Operator: FilterPrefix, Value: "e"}, published}}, "filter"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DatastoreQuery(model.NewQuery(nil), test.query, fields)
+			if test.field == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			fe, ok := err.(FieldError)
+			if !ok {
+				t.Fatalf("got error %v, want a field error", err)
+			}
+			if fe.field != test.field {
+				t.Errorf("got an error on %s, want %s", fe.field, test.field)
+			}
+		})
+	}
+}
+
+// resource of the merge tests
+type filed struct {
+	Name     string
+	Category string
+	Order    int
+}
+
+func (f *filed) Id() string {
+	return f.Name
+}
+
+func (f *filed) ToRepresentation(rtype RepresentationType) ([]byte, error) {
+	return []byte(f.Name), nil
+}
+
+func (f *filed) FromRepresentation(rtype RepresentationType, data []byte) error {
+	return nil
+}
+
+// returns a datastore stand-in of the resources, which like the datastore can't apply the in and != filters
+func filedList(t *testing.T, resources ...*filed) func(query Query) ([]Resource, error) {
+	return func(query Query) ([]Resource, error) {
+		if _, err := DatastoreQuery(model.NewQuery(nil), query, FilterFields{"Category": FieldString, "Order": FieldInt}); err != nil {
+			return nil, err
+		}
+
+		var matching []*filed
+		for _, res := range resources {
+			match := true
+			for _, filter := range query.Filters {
+				switch filter.Operator {
+				case "", FilterEqual:
+					match = match && res.Category == filter.Value
+				case FilterLess:
+					match = match && res.Category < filter.Value
+				case FilterGreater:
+					match = match && res.Category > filter.Value
+				default:
+					t.Fatalf("the datastore got the %s operator", filter.Operator)
+				}
+			}
+			if match {
+				matching = append(matching, res)
+			}
+		}
+		if query.Order != "" {
+			sort.SliceStable(matching, func(i, j int) bool {
+				if query.Descending {
+					return matching[i].Order > matching[j].Order
+				}
+				return matching[i].Order < matching[j].Order
+			})
+		}
+
+		var listed []Resource
+		for i := query.Offset; i < len(matching) && (query.Limit == 0 || len(listed) < query.Limit); i++ {
+			listed = append(listed, matching[i])
+		}
+		return listed, nil
+	}
+}
+
+func TestDatastoreMerged(t *testing.T) {
+	list := filedList(t,
+		&filed{Name: "a", Category: "news", Order: 4},
+		&filed{Name: "b", Category: "events", Order: 1},
+		&filed{Name: "c", Category: "blog", Order: 3},
+		&filed{Name: "d", Category: "news", Order: 2},
+		&filed{Name: "e", Category: "events", Order: 5},
+	)
+	in := Filter{Field: "Category", Operator: FilterIn, Value: "news,events"}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"in", Query{Filters: []Filter{in}, Order: "Order"}, "bdae"},
+		{"in descending", Query{Filters: []Filter{in}, Order: "Order", Descending: true}, "eadb"},
+		{"in paged", Query{Filters: []Filter{in}, Order: "Order", Offset: 1, Limit: 2}, "da"},
+		{"in past the end", Query{Filters: []Filter{in}, Order: "Order", Offset: 4, Limit: 2}, ""},
+		{"in of repeated values", Query{Filters: []Filter{{Field: "Category", Operator: FilterIn, Value: "blog,blog"}}}, "c"},
+		{"not equal", Query{Filters: []Filter{{Field: "Category", Operator: FilterNotEqual, Value: "news"}}}, "bce"},
+		{"equality", Query{Filters: []Filter{{Field: "Category", Value: "news"}}, Order: "Order"}, "da"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resources, err := DatastoreMerged(test.query, list)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := ""
+			for _, res := range resources {
+				got += res.Id()
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// != is an inequality, which the datastore only orders by its own field
+	notEqual := Query{Filters: []Filter{{Field: "Category", Operator: FilterNotEqual, Value: "news"}}, Order: "Order"}
+	if _, err := DatastoreMerged(notEqual, list); err == nil {
+		t.Error("!= ordered by another field accepted")
+	}
+
+	values := make([]string, maxDatastoreQueries+1)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	if _, err := DatastoreMerged(Query{Filters: []Filter{{Field: "Category", Operator: FilterIn, Value: strings.Join(values, ",")}}}, list); err == nil {
+		t.Errorf("in of %d values accepted", len(values))
+	}
+}
@@ -28,6 +28,9 @@ const (
 	PermissionReadSubscription
 	PermissionWriteAction
 	PermissionReadAction
+	PermissionReadTrash
+	PermissionWriteTrash
+	PermissionReviewContent
 )
 
 var Permissions = map[Permission]string{
@@ -49,6 +52,9 @@ var Permissions = map[Permission]string{
 	PermissionWriteSubscription: "PERMISSION_WRITE_SUBSCRIPTION",
 	PermissionWriteAction: "PERMISSION_READ_ACTION",
 	PermissionReadAction: "PERMISSION_WRITE_ACTION",
+	PermissionReadTrash:         "PERMISSION_READ_TRASH",
+	PermissionWriteTrash:        "PERMISSION_WRITE_TRASH",
+	PermissionReviewContent:     "PERMISSION_REVIEW_CONTENT",
 }
 
 func PermissionName(permission Permission) string {
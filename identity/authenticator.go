@@ -13,7 +13,7 @@ type UserAuthenticator struct {
 }
 
 func (authenticator UserAuthenticator) Authenticate(ctx context.Context) context.Context {
-	inputs := flamel.InputsFromContext(ctx)
+	inputs := spellbook.InputsFromContext(ctx)
 	if tkn, ok := inputs[spellbook.HeaderToken]; ok {
 		token := tkn.Value()
 		// grab the last chars after hashLength
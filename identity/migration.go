@@ -0,0 +1,30 @@
+package identity
+
+import (
+	"decodica.com/spellbook/sql"
+)
+
+// Migrations create the sql schema of the users.
+// Tables and indexes are created only if missing, so that databases created by AutoMigrate adopt them
+var Migrations = sql.Migrations{
+	{
+		Version: 2026101803,
+		Name:    "create users",
+		Up: sql.Steps(
+			sql.CreateTable("users",
+				sql.Column{Name: "username", Type: sql.TypeString, PrimaryKey: true},
+				sql.Column{Name: "name", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "surname", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "email", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "password", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "token", Type: sql.TypeString},
+				sql.Column{Name: "locale", Type: sql.TypeString, NotNull: true},
+				sql.Column{Name: "permission", Type: sql.TypeBigInt, NotNull: true},
+				sql.Column{Name: "last_login", Type: sql.TypeTime},
+			),
+			sql.CreateUniqueIndex("idx_users_email", "users", "email"),
+			sql.CreateUniqueIndex("idx_users_token", "users", "token"),
+		),
+		Down: sql.DropTable("users"),
+	},
+}
@@ -0,0 +1,94 @@
+package identity
+
+import (
+	"context"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/sql"
+	"reflect"
+)
+
+// sql storage of the users
+var sqlUserRepository = sql.NewRepository(&User{}, userFilterFields, nil)
+
+// datastore storage of the users, the default of the UserManager.
+// Users are keyed by their username
+type userRepository struct{}
+
+func (repository userRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	us := User{}
+	if err := model.FromStringID(ctx, &us, id, nil); err != nil {
+		return nil, err
+	}
+	return &us, nil
+}
+
+func (repository userRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&User{}), query, userFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &users); err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(users))
+	for i := range users {
+		resources[i] = users[i]
+	}
+	return resources, nil
+}
+
+func (repository userRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&User{}), query, userFilterFields)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(spellbook.DatastoreQueryContext(ctx))
+}
+
+func (repository userRepository) Distinct(ctx context.Context, field string, query spellbook.Query) ([]string, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&User{}), query, userFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	if err := q.Distinct(field).GetAll(spellbook.DatastoreQueryContext(ctx), &users); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, u := range users {
+		value := reflect.ValueOf(u).Elem().FieldByName(field).String()
+		if len(value) > 0 {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+// Create stores the user with its username as the key
+func (repository userRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	user := res.(*User)
+	opts := model.CreateOptions{}
+	opts.WithStringId(user.SqlUsername)
+	return model.CreateWithOptions(ctx, user, &opts)
+}
+
+func (repository userRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return model.Update(ctx, res.(*User))
+}
+
+func (repository userRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*User), nil)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository userRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
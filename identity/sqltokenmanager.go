@@ -2,7 +2,6 @@ package identity
 
 import (
 	"context"
-	"decodica.com/flamel/model"
 	"decodica.com/spellbook"
 	"decodica.com/spellbook/sql"
 	"fmt"
@@ -72,31 +71,36 @@ func (manager SqlTokenManager) Create(ctx context.Context, res spellbook.Resourc
 		return spellbook.NewFieldError("password", err)
 	}
 
-	u := &User{}
-	db := sql.FromContext(ctx)
-	err := db.Where("username = ?", token.Username).First(u).Error
-
+	// the user is read and updated in a transaction, so that the token is rotated atomically
+	var u *User
+	err := sql.RunInTransaction(ctx, func(ctx context.Context) error {
+		res, err := sqlUserRepository.FromId(ctx, token.Username)
+		if err != nil {
+			return err
+		}
+		u = res.(*User)
+
+		salt := spellbook.Application().Options().Salt
+		hp := HashPassword(token.Password, salt)
+		if u.Password != hp {
+			return gorm.ErrRecordNotFound
+		}
+
+		tv, err := u.GenerateToken()
+		if err != nil {
+			return fmt.Errorf("error generating token for user %s: %s", u.Username(), err.Error())
+		}
+
+		u.setToken(tv)
+		if err := sqlUserRepository.Update(ctx, u); err != nil {
+			return fmt.Errorf("error updating user token: %s", err.Error())
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	salt := spellbook.Application().Options().Salt
-	hp := HashPassword(token.Password, salt)
-	if u.Password != hp {
-		return gorm.ErrRecordNotFound
-	}
-
-	tv, err := u.GenerateToken()
-	if err != nil {
-		return fmt.Errorf("error generating token for user %s: %s", u.Username(), err.Error())
-	}
-
-	u.setToken(tv)
-	err = db.Save(&u).Error
-	if err != nil {
-		return fmt.Errorf("error updating user token: %s", err.Error())
-	}
-
 	token.Value = u.Token
 
 	return nil
@@ -115,11 +119,6 @@ func (manager SqlTokenManager) Delete(ctx context.Context, res spellbook.Resourc
 	}
 
 	user.setToken("")
-	err := model.Update(ctx, &user)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return sqlUserRepository.Update(ctx, &user)
 }
 
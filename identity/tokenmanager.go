@@ -74,33 +74,34 @@ func (manager TokenManager) Create(ctx context.Context, res spellbook.Resource,
 		return spellbook.NewFieldError("password", err)
 	}
 
+	// the user is read and updated in a transaction, so that the token is rotated atomically
 	u := User{}
-	err := model.FromStringID(ctx, &u, token.Username, nil)
-
-	if err == datastore.ErrNoSuchEntity {
-		return err
-	}
-
+	err := spellbook.RunInDatastoreTransaction(ctx, func(ctx context.Context) error {
+		if err := model.FromStringID(ctx, &u, token.Username, nil); err != nil {
+			return err
+		}
+
+		salt := spellbook.Application().Options().Salt
+		hp := HashPassword(token.Password, salt)
+		if u.Password != hp {
+			return datastore.ErrNoSuchEntity
+		}
+
+		var err error
+		u.Token, err = u.GenerateToken()
+		if err != nil {
+			return fmt.Errorf("error generating token for user %s: %s", u.StringID(), err.Error())
+		}
+
+		if err := model.Update(ctx, &u); err != nil {
+			return fmt.Errorf("error updating user token: %s", err.Error())
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	salt := spellbook.Application().Options().Salt
-	hp := HashPassword(token.Password, salt)
-	if u.Password != hp {
-		return datastore.ErrNoSuchEntity
-	}
-
-	u.Token, err = u.GenerateToken()
-	if err != nil {
-		return fmt.Errorf("error generating token for user %s: %s", u.StringID(), err.Error())
-	}
-
-	err = model.Update(ctx, &u)
-	if err != nil {
-		return fmt.Errorf("error updating user token: %s", err.Error())
-	}
-
 	token.Value = u.Token
 
 	return nil
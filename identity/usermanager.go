@@ -1,19 +1,37 @@
 package identity
 
 import (
-	"cloud.google.com/go/datastore"
 	"context"
-	"decodica.com/flamel/model"
 	"decodica.com/spellbook"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"google.golang.org/appengine/log"
-	"reflect"
 	"sort"
 )
 
-type UserManager struct{}
+// UserManager handles the users stored in the repository.
+// The zero value stores them in the datastore
+type UserManager struct {
+	Repository spellbook.Repository
+}
+
+func (manager UserManager) users() spellbook.Repository {
+	if manager.Repository == nil {
+		return userRepository{}
+	}
+	return manager.Repository
+}
+
+// fields the users can be filtered by
+var userFilterFields = spellbook.FilterFields{
+	"Name":       spellbook.FieldString,
+	"Surname":    spellbook.FieldString,
+	"Email":      spellbook.FieldString,
+	"Locale":     spellbook.FieldString,
+	"Permission": spellbook.FieldInt,
+	"LastLogin":  spellbook.FieldTime,
+}
 
 func NewUserController() *spellbook.RestController {
 	return NewUserControllerWithKey("")
@@ -27,6 +45,17 @@ func NewUserControllerWithKey(key string) *spellbook.RestController {
 	return c
 }
 
+// Describe describes the users for the OpenAPI document
+func (manager UserManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "User",
+		Filterable:       userFilterFields,
+		Orderable:        []string{"Name", "Surname", "Email", "LastLogin"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadUser},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteUser},
+	}
+}
+
 func (manager UserManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &User{}, nil
 }
@@ -46,13 +75,13 @@ func (manager UserManager) FromId(ctx context.Context, id string) (spellbook.Res
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadUser))
 	}
 
-	us := User{}
-	if err := model.FromStringID(ctx, &us, id, nil); err != nil {
+	us, err := manager.users().FromId(ctx, id)
+	if err != nil {
 		log.Errorf(ctx, "could not retrieve user %s: %s", id, err.Error())
 		return nil, err
 	}
 
-	return &us, nil
+	return us, nil
 }
 
 func (manager UserManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
@@ -61,36 +90,17 @@ func (manager UserManager) ListOf(ctx context.Context, opts spellbook.ListOption
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadUser))
 	}
 
-	var users []*User
-	q := model.NewQuery(&User{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
-	}
-
+	query := spellbook.QueryFromOptions(opts)
+	query.Offset = opts.Page * opts.Size
 	// get one more so we know if we are done
-	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &users)
+	query.Limit = opts.Size + 1
+
+	resources, err := manager.users().ListOf(ctx, query)
 	if err != nil {
+		log.Errorf(ctx, "error retrieving users: %s", err.Error())
 		return nil, err
 	}
 
-	resources := make([]spellbook.Resource, len(users))
-	for i := range users {
-		resources[i] = users[i]
-	}
-
 	return resources, nil
 }
 
@@ -109,38 +119,13 @@ func (manager UserManager) ListOfProperties(ctx context.Context, opts spellbook.
 		return nil, errors.New("no property found")
 	}
 
-	var conts []*User
-	q := model.NewQuery(&User{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
+	repository, ok := manager.users().(spellbook.DistinctRepository)
+	if !ok {
+		return nil, spellbook.NewUnsupportedError()
 	}
 
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
-	}
-
-	q = q.Distinct(name)
-	q = q.Limit(opts.Size + 1)
-	err := q.GetAll(ctx, &conts)
-	if err != nil {
-		return nil, err
-	}
-	var result []string
-	for _, c := range conts {
-		value := reflect.ValueOf(c).Elem().FieldByName(name).String()
-		if len(value) > 0 {
-			result = append(result, value)
-		}
-	}
-	return result, nil
+	query := spellbook.Query{Filters: opts.Filters, Offset: opts.Page * opts.Size, Limit: opts.Size + 1}
+	return repository.Distinct(ctx, name, query)
 }
 
 func (manager UserManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
@@ -195,7 +180,7 @@ func (manager UserManager) Create(ctx context.Context, res spellbook.Resource, b
 	}
 
 	// check for user existence
-	err = model.FromStringID(ctx, &User{}, username, nil)
+	_, err = manager.users().FromId(ctx, username)
 
 	if err == nil {
 		// user already exists
@@ -203,19 +188,18 @@ func (manager UserManager) Create(ctx context.Context, res spellbook.Resource, b
 		return spellbook.NewFieldError("user", errors.New(msg))
 	}
 
-	if err != datastore.ErrNoSuchEntity {
-		// generic datastore error
+	if !spellbook.IsNotFound(err) {
+		// generic storage error
 		msg := fmt.Sprintf("error retrieving user with username %s: %s", username, err.Error())
 		return spellbook.NewFieldError("user", errors.New(msg))
 	}
 
 	salt := spellbook.Application().Options().Salt
 	user.Password = HashPassword(meta.Password, salt)
+	// the username is the key of the user
+	user.SqlUsername = username
 
-	opts := model.CreateOptions{}
-	opts.WithStringId(username)
-
-	err = model.CreateWithOptions(ctx, user, &opts)
+	err = manager.users().Create(ctx, user)
 	if err != nil {
 		return fmt.Errorf("error creating post %s: %s", user.Name, err)
 	}
@@ -225,7 +209,7 @@ func (manager UserManager) Create(ctx context.Context, res spellbook.Resource, b
 
 func (manager UserManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 	current := spellbook.IdentityFromContext(ctx)
-	if !current.HasPermission(spellbook.PermissionWriteUser) {
+	if current == nil || !current.HasPermission(spellbook.PermissionWriteUser) {
 		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteUser))
 	}
 
@@ -271,20 +255,26 @@ func (manager UserManager) Update(ctx context.Context, res spellbook.Resource, b
 	user.Surname = other.Surname
 	user.Permission = other.Permission
 
-	return model.Update(ctx, user)
+	return manager.users().Update(ctx, user)
 }
 
 func (manager UserManager) Delete(ctx context.Context, res spellbook.Resource) error {
 	current := spellbook.IdentityFromContext(ctx)
-	if !current.HasPermission(spellbook.PermissionWriteUser) {
+	if current == nil || !current.HasPermission(spellbook.PermissionWriteUser) {
 		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteUser))
 	}
 
 	user := res.(*User)
-	err := model.Delete(ctx, user, nil)
+	err := manager.users().Delete(ctx, user)
 	if err != nil {
 		return fmt.Errorf("error deleting user %s: %s", user.Name, err.Error())
 	}
 
 	return nil
 }
+
+// RunInTransaction runs fn in the transaction of the repository of the manager,
+// making the batch requests and the extenders hooks atomic
+func (manager UserManager) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInTransaction(ctx, fn, manager.users())
+}
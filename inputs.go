@@ -0,0 +1,130 @@
+package spellbook
+
+import (
+	"bytes"
+	"context"
+	"decodica.com/flamel"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+)
+
+type inputsKey string
+
+const (
+	keyInputs        inputsKey = "__spellbook_inputs__"
+	keyRoutingParams inputsKey = "__spellbook_routing_params__"
+	keyHeaders       inputsKey = "__spellbook_headers__"
+	keyBody          inputsKey = "__spellbook_body__"
+)
+
+// Input is a value of a request, as parsed by flamel
+type Input struct {
+	values []string
+	files  []*multipart.FileHeader
+}
+
+// NewInput returns an input with the given values
+func NewInput(values ...string) Input {
+	return Input{values: values}
+}
+
+// NewFileInput returns an input with the given uploaded files
+func NewFileInput(files ...*multipart.FileHeader) Input {
+	return Input{files: files}
+}
+
+func (input Input) Value() string {
+	if len(input.values) == 0 {
+		return ""
+	}
+	return input.values[0]
+}
+
+func (input Input) Values() []string {
+	return input.values
+}
+
+func (input Input) Files() []*multipart.FileHeader {
+	return input.files
+}
+
+// Inputs are the inputs of a request, by name
+type Inputs map[string]Input
+
+// InputsFromContext returns the inputs of the request.
+// Inputs set with ContextWithInputs take the place of the ones parsed by flamel,
+// so that controllers can be run without a flamel server
+func InputsFromContext(ctx context.Context) Inputs {
+	if ins, ok := ctx.Value(keyInputs).(Inputs); ok {
+		return ins
+	}
+	return fromFlamel(flamel.InputsFromContext(ctx))
+}
+
+func ContextWithInputs(ctx context.Context, ins Inputs) context.Context {
+	return context.WithValue(ctx, keyInputs, ins)
+}
+
+// RoutingParams returns the parameters of the route matching the request, see InputsFromContext
+func RoutingParams(ctx context.Context) Inputs {
+	if params, ok := ctx.Value(keyRoutingParams).(Inputs); ok {
+		return params
+	}
+	return fromFlamel(flamel.RoutingParams(ctx))
+}
+
+func ContextWithRoutingParams(ctx context.Context, params Inputs) context.Context {
+	return context.WithValue(ctx, keyRoutingParams, params)
+}
+
+// BodyFromContext returns the raw body of the request, if set with ContextWithBody.
+// flamel only passes the bodies of the application/json requests, see flamel.KeyRequestJSON
+func BodyFromContext(ctx context.Context) ([]byte, bool) {
+	body, ok := ctx.Value(keyBody).([]byte)
+	return body, ok
+}
+
+func ContextWithBody(ctx context.Context, body []byte) context.Context {
+	return context.WithValue(ctx, keyBody, body)
+}
+
+// BodyHandler keeps the body of the requests in their context, see BodyFromContext,
+// leaving it readable by the next handler
+func BodyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r = r.WithContext(ContextWithBody(r.Context(), body))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AddHeader adds the header to the response output.
+// flamel writes the headers of the output without exposing them: the header is also recorded
+// in the headers of the context, if set with ContextWithHeaders, so that servers other than flamel can write it
+func AddHeader(ctx context.Context, out *flamel.ResponseOutput, key string, value string) {
+	out.AddHeader(key, value)
+	if headers, ok := ctx.Value(keyHeaders).(http.Header); ok {
+		headers.Set(key, value)
+	}
+}
+
+// ContextWithHeaders returns a context recording in headers the response headers added with AddHeader
+func ContextWithHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, keyHeaders, headers)
+}
+
+func fromFlamel(fins flamel.RequestInputs) Inputs {
+	ins := make(Inputs, len(fins))
+	for k, in := range fins {
+		ins[k] = Input{values: in.Values(), files: in.Files()}
+	}
+	return ins
+}
@@ -28,6 +28,25 @@ func NewMailMessageControllerWithKey(key string) *spellbook.RestController {
 
 type MailMessageManager struct{}
 
+// fields the mail messages can be filtered by
+var mailMessageFilterFields = spellbook.FilterFields{
+	"Recipient": spellbook.FieldString,
+	"Sender":    spellbook.FieldString,
+	"Object":    spellbook.FieldString,
+	"Created":   spellbook.FieldTime,
+}
+
+// Describe describes the mail messages for the OpenAPI document
+func (manager MailMessageManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "MailMessage",
+		Filterable:       mailMessageFilterFields,
+		Orderable:        []string{"Created", "Recipient"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadMailMessage},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteMailMessage},
+	}
+}
+
 func (manager MailMessageManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &MailMessage{}, nil
 }
@@ -63,15 +82,14 @@ func (manager MailMessageManager) ListOf(ctx context.Context, opts spellbook.Lis
 		q = q.OrderBy(opts.Order, dir)
 	}
 
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	q, err := spellbook.FilterQuery(q, mailMessageFilterFields, opts.Filters)
+	if err != nil {
+		return nil, err
 	}
 
 	// get one more so we know if we are done
 	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &mailMessages)
+	err = q.GetMulti(ctx, &mailMessages)
 	if err != nil {
 		return nil, err
 	}
@@ -108,15 +126,14 @@ func (manager MailMessageManager) ListOfProperties(ctx context.Context, opts spe
 		q = q.OrderBy(opts.Order, dir)
 	}
 
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	q, err := spellbook.FilterQuery(q, mailMessageFilterFields, opts.Filters)
+	if err != nil {
+		return nil, err
 	}
 
 	q = q.Distinct(name)
 	q = q.Limit(opts.Size + 1)
-	err := q.GetAll(ctx, &conts)
+	err = q.GetAll(ctx, &conts)
 	if err != nil {
 		log.Errorf(ctx, "Error retrieving result: %+v", err)
 		return nil, err
@@ -0,0 +1,560 @@
+// Package memory provides an in-memory storage for the spellbook managers,
+// meant to run them offline, e.g. in tests
+package memory
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrExists = errors.New("resource already exists")
+
+// Repository stores resources in memory.
+// Resources are stored and returned as copies, so that changes are only saved by Update.
+// Deleted resources can be kept in the trash, see spellbook.TrashRepository: a new resource with the key of a trashed one replaces it.
+// Queries can filter and order by any field of the resource struct, unless Fields is set,
+// in which case filters are validated against it as by the other backends
+type Repository struct {
+	// Key returns the key of a new resource.
+	// If nil, resources are keyed by their id, or by a sequence if they don't have one yet.
+	// Resources with an integer ID field get the sequence value as their ID
+	Key    func(resource spellbook.Resource) string
+	Fields spellbook.FilterFields
+
+	mutex     sync.RWMutex
+	resources map[string]spellbook.Resource
+	// keys of the stored resources, in insertion order
+	order []string
+	// keys of the copies returned to the callers
+	keys map[spellbook.Resource]string
+	seq  int64
+	// keys of the resources in the trash
+	trashed map[string]bool
+}
+
+func NewRepository() *Repository {
+	return &Repository{}
+}
+
+func (repository *Repository) init() {
+	if repository.resources == nil {
+		repository.resources = map[string]spellbook.Resource{}
+		repository.keys = map[spellbook.Resource]string{}
+		repository.trashed = map[string]bool{}
+	}
+}
+
+// returns a copy of the resource, tracking its key
+func (repository *Repository) copy(key string, resource spellbook.Resource) spellbook.Resource {
+	v := reflect.ValueOf(resource)
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	r := c.Interface().(spellbook.Resource)
+	repository.keys[r] = key
+	return r
+}
+
+// returns the key of a resource returned by or given to the repository
+func (repository *Repository) key(resource spellbook.Resource) (string, bool) {
+	key, ok := repository.keys[resource]
+	switch {
+	case repository.Key != nil:
+		key = repository.Key(resource)
+	case !ok:
+		key = resource.Id()
+	}
+	_, ok = repository.resources[key]
+	return key, ok
+}
+
+func (repository *Repository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	resource, ok := repository.resources[id]
+	if !ok || repository.trashed[id] {
+		return nil, spellbook.ErrNotFound
+	}
+	return repository.copy(id, resource), nil
+}
+
+func (repository *Repository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	keys, err := repository.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(keys))
+	for i, key := range keys {
+		resources[i] = repository.copy(key, repository.resources[key])
+	}
+	return resources, nil
+}
+
+func (repository *Repository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	query.Order = ""
+	query.Offset = 0
+	query.Limit = 0
+	keys, err := repository.query(query)
+	return len(keys), err
+}
+
+// Distinct lists the distinct, non empty values of the field
+func (repository *Repository) Distinct(ctx context.Context, name string, query spellbook.Query) ([]string, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	offset, limit := query.Offset, query.Limit
+	query.Order = ""
+	query.Offset = 0
+	query.Limit = 0
+	keys, err := repository.query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var values []string
+	for _, key := range keys {
+		f, ok := field(repository.resources[key], name)
+		if !ok {
+			return nil, spellbook.NewFieldError("property", fmt.Errorf("unknown property %s", name))
+		}
+		value := fmt.Sprint(f.Interface())
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	return page(values, offset, limit), nil
+}
+
+func (repository *Repository) Create(ctx context.Context, resource spellbook.Resource) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	repository.seq++
+	if id, ok := field(resource, "ID"); ok && id.CanSet() && isInt(id.Kind()) && isZero(id) {
+		if id.Kind() >= reflect.Uint && id.Kind() <= reflect.Uint64 {
+			id.SetUint(uint64(repository.seq))
+		} else {
+			id.SetInt(repository.seq)
+		}
+	}
+
+	var key string
+	switch {
+	case repository.Key != nil:
+		key = repository.Key(resource)
+	case resource.Id() != "" && resource.Id() != "0":
+		key = resource.Id()
+	default:
+		key = strconv.FormatInt(repository.seq, 10)
+	}
+
+	if _, ok := repository.resources[key]; ok {
+		if !repository.trashed[key] {
+			return ErrExists
+		}
+		repository.remove(key)
+	}
+
+	repository.resources[key] = repository.copy(key, resource)
+	repository.order = append(repository.order, key)
+	repository.keys[resource] = key
+	return nil
+}
+
+func (repository *Repository) Update(ctx context.Context, resource spellbook.Resource) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	key, ok := repository.key(resource)
+	if !ok {
+		return spellbook.ErrNotFound
+	}
+	repository.resources[key] = repository.copy(key, resource)
+	return nil
+}
+
+func (repository *Repository) Delete(ctx context.Context, resource spellbook.Resource) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	key, ok := repository.key(resource)
+	if !ok {
+		return spellbook.ErrNotFound
+	}
+	repository.remove(key)
+	return nil
+}
+
+// removes the resource with the key, also from the trash
+func (repository *Repository) remove(key string) {
+	delete(repository.resources, key)
+	delete(repository.trashed, key)
+	for i, k := range repository.order {
+		if k == key {
+			repository.order = append(repository.order[:i], repository.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Trash moves the resource to the trash
+func (repository *Repository) Trash(ctx context.Context, resource spellbook.Resource) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	key, ok := repository.key(resource)
+	if !ok || repository.trashed[key] {
+		return spellbook.ErrNotFound
+	}
+	repository.trashed[key] = true
+	return nil
+}
+
+// Trashed returns the resource with the given id if it is in the trash
+func (repository *Repository) Trashed(ctx context.Context, id string) (spellbook.Resource, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	if !repository.trashed[id] {
+		return nil, spellbook.ErrNotFound
+	}
+	return repository.copy(id, repository.resources[id]), nil
+}
+
+// Restore takes the resource out of the trash
+func (repository *Repository) Restore(ctx context.Context, resource spellbook.Resource) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	repository.init()
+
+	key, ok := repository.key(resource)
+	if !ok || !repository.trashed[key] {
+		return spellbook.ErrNotFound
+	}
+	delete(repository.trashed, key)
+	return nil
+}
+
+// RunInTransaction runs fn, restoring the content of the repository if it fails.
+// Concurrent changes are not isolated from fn
+func (repository *Repository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	repository.mutex.Lock()
+	repository.init()
+	resources := make(map[string]spellbook.Resource, len(repository.resources))
+	for k, v := range repository.resources {
+		resources[k] = v
+	}
+	trashed := make(map[string]bool, len(repository.trashed))
+	for k, v := range repository.trashed {
+		trashed[k] = v
+	}
+	order := append([]string(nil), repository.order...)
+	seq := repository.seq
+	repository.mutex.Unlock()
+
+	if err := fn(ctx); err != nil {
+		repository.mutex.Lock()
+		repository.resources = resources
+		repository.trashed = trashed
+		repository.order = order
+		repository.seq = seq
+		repository.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// returns the keys of the resources matching the query, in order
+func (repository *Repository) query(query spellbook.Query) ([]string, error) {
+	if repository.Fields != nil {
+		if err := repository.Fields.Validate(query.Filters); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []string
+	for _, key := range repository.order {
+		if repository.trashed[key] && !query.Trashed {
+			continue
+		}
+		ok, err := matches(repository.resources[key], query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+
+	if query.Order != "" && len(keys) > 0 {
+		if _, ok := field(repository.resources[keys[0]], query.Order); !ok {
+			return nil, spellbook.NewFieldError("order", fmt.Errorf("invalid order field %s", query.Order))
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			a, _ := field(repository.resources[keys[i]], query.Order)
+			b, _ := field(repository.resources[keys[j]], query.Order)
+			c := compare(a, b)
+			if query.Descending {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	return page(keys, query.Offset, query.Limit), nil
+}
+
+func page(values []string, offset int, limit int) []string {
+	if offset >= len(values) {
+		return nil
+	}
+	values = values[offset:]
+	if limit > 0 && limit < len(values) {
+		values = values[:limit]
+	}
+	return values
+}
+
+// reports if the resource matches the filters and the start of the query
+func matches(resource spellbook.Resource, query spellbook.Query) (bool, error) {
+	if query.Order != "" && query.Start != nil {
+		v, ok := field(resource, query.Order)
+		if !ok {
+			return false, spellbook.NewFieldError("order", fmt.Errorf("invalid order field %s", query.Order))
+		}
+		c := compare(v, reflect.ValueOf(query.Start))
+		if (query.Descending && c > 0) || (!query.Descending && c < 0) {
+			return false, nil
+		}
+	}
+
+	for _, filter := range query.Filters {
+		if filter.Field == "" {
+			continue
+		}
+
+		v, ok := field(resource, filter.Field)
+		if !ok {
+			return false, spellbook.NewFieldError("filter", fmt.Errorf("field %s can't be filtered", filter.Field))
+		}
+
+		ok, err := match(v, filter)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func match(v reflect.Value, filter spellbook.Filter) (bool, error) {
+	switch filter.Operator {
+	case spellbook.FilterNull:
+		return isZero(v), nil
+	case spellbook.FilterNotNull:
+		return !isZero(v), nil
+	}
+
+	// list fields match if any of their values does, as on the datastore
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < v.Len(); i++ {
+			if ok, err := match(v.Index(i), filter); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	switch filter.Operator {
+	case spellbook.FilterPrefix:
+		return strings.HasPrefix(fmt.Sprint(v.Interface()), filter.Value), nil
+	case spellbook.FilterIn:
+		for _, value := range filter.Values() {
+			other, err := parse(v.Type(), filter.Field, value)
+			if err != nil {
+				return false, err
+			}
+			if compare(v, other) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	other, err := parse(v.Type(), filter.Field, filter.Value)
+	if err != nil {
+		return false, err
+	}
+
+	c := compare(v, other)
+	switch filter.Operator {
+	case "", spellbook.FilterEqual:
+		return c == 0, nil
+	case spellbook.FilterNotEqual:
+		return c != 0, nil
+	case spellbook.FilterLess:
+		return c < 0, nil
+	case spellbook.FilterLessOrEqual:
+		return c <= 0, nil
+	case spellbook.FilterGreater:
+		return c > 0, nil
+	case spellbook.FilterGreaterOrEqual:
+		return c >= 0, nil
+	}
+	return false, spellbook.NewFieldError("filter", fmt.Errorf("unsupported operator %s", filter.Operator))
+}
+
+// returns the field of the resource struct with the given name, either in its Go or column form
+func field(resource spellbook.Resource, name string) (reflect.Value, bool) {
+	v := reflect.Indirect(reflect.ValueOf(resource))
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	name = strings.Replace(name, "_", "", -1)
+	f := v.FieldByNameFunc(func(field string) bool {
+		return strings.EqualFold(field, name)
+	})
+	return f, f.IsValid()
+}
+
+func isInt(kind reflect.Kind) bool {
+	return (kind >= reflect.Int && kind <= reflect.Int64) || (kind >= reflect.Uint && kind <= reflect.Uint64)
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// parses the filter value into the type of the field
+func parse(t reflect.Type, name string, value string) (reflect.Value, error) {
+	invalid := spellbook.NewFieldError("filter", fmt.Errorf("invalid value %q for field %s", value, name))
+	v := reflect.New(t).Elem()
+
+	if t == reflect.TypeOf(time.Time{}) {
+		tm, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return v, invalid
+		}
+		v.Set(reflect.ValueOf(tm))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return v, invalid
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return v, invalid
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return v, invalid
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return v, invalid
+		}
+		v.SetFloat(f)
+	default:
+		return v, spellbook.NewFieldError("filter", fmt.Errorf("field %s can't be filtered", name))
+	}
+	return v, nil
+}
+
+// compares two values of the same type, returning -1, 0 or 1
+func compare(a reflect.Value, b reflect.Value) int {
+	if ta, ok := a.Interface().(time.Time); ok {
+		tb, _ := b.Interface().(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	}
+
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Bool:
+		switch {
+		case a.Bool() == b.Bool():
+			return 0
+		case !a.Bool():
+			return -1
+		}
+		return 1
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInt(a.Int(), toInt(b))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareInt(int64(a.Uint()), toInt(b))
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Convert(a.Type()).Float()
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+// returns the integer value of v, which may be of any integer kind
+func toInt(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	}
+	return 0
+}
+
+func compareInt(x int64, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
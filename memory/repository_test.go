@@ -0,0 +1,227 @@
+package memory
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+type thing struct {
+	ID    int64
+	Name  string
+	Order int
+	Tags  []string
+}
+
+func (t *thing) Id() string {
+	return strconv.FormatInt(t.ID, 10)
+}
+
+func (t *thing) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (t *thing) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	return json.Unmarshal(data, t)
+}
+
+// returns a repository with the things of the given names, ordered by their position
+func newThings(t *testing.T, names ...string) (*Repository, []*thing) {
+	t.Helper()
+	repository := NewRepository()
+	things := make([]*thing, len(names))
+	for i, name := range names {
+		things[i] = &thing{Name: name, Order: i, Tags: []string{name, "all"}}
+		if err := repository.Create(context.Background(), things[i]); err != nil {
+			t.Fatalf("error creating %s: %s", name, err)
+		}
+	}
+	return repository, things
+}
+
+// returns the names of the things listed by the query
+func names(t *testing.T, repository *Repository, query spellbook.Query) []string {
+	t.Helper()
+	resources, err := repository.ListOf(context.Background(), query)
+	if err != nil {
+		t.Fatalf("error listing %+v: %s", query, err)
+	}
+	names := make([]string, len(resources))
+	for i, res := range resources {
+		names[i] = res.(*thing).Name
+	}
+	return names
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRepositoryCreate(t *testing.T) {
+	ctx := context.Background()
+	repository, things := newThings(t, "a", "b")
+
+	if things[0].ID != 1 || things[1].ID != 2 {
+		t.Errorf("got ids %d and %d, want 1 and 2", things[0].ID, things[1].ID)
+	}
+	if err := repository.Create(ctx, &thing{ID: 1}); err != ErrExists {
+		t.Errorf("creating an existing id: got error %v, want %v", err, ErrExists)
+	}
+
+	res, err := repository.FromId(ctx, "1")
+	if err != nil {
+		t.Fatalf("error retrieving 1: %s", err)
+	}
+	res.(*thing).Name = "changed"
+	if again, _ := repository.FromId(ctx, "1"); again.(*thing).Name != "a" {
+		t.Errorf("a change of a copy has been stored as %q", again.(*thing).Name)
+	}
+
+	if err := repository.Update(ctx, res); err != nil {
+		t.Fatalf("error updating 1: %s", err)
+	}
+	if again, _ := repository.FromId(ctx, "1"); again.(*thing).Name != "changed" {
+		t.Errorf("updated name is %q, want changed", again.(*thing).Name)
+	}
+
+	if err := repository.Delete(ctx, res); err != nil {
+		t.Fatalf("error deleting 1: %s", err)
+	}
+	if _, err := repository.FromId(ctx, "1"); !spellbook.IsNotFound(err) {
+		t.Errorf("deleted resource: got error %v, want not found", err)
+	}
+}
+
+func TestRepositoryQuery(t *testing.T) {
+	repository, _ := newThings(t, "c", "a", "b", "ab")
+
+	tests := []struct {
+		name  string
+		query spellbook.Query
+		want  []string
+	}{
+		{"all", spellbook.Query{}, []string{"c", "a", "b", "ab"}},
+		{"ordered", spellbook.Query{Order: "Name"}, []string{"a", "ab", "b", "c"}},
+		{"descending", spellbook.Query{Order: "Name", Descending: true}, []string{"c", "b", "ab", "a"}},
+		{"paged", spellbook.Query{Order: "Name", Offset: 1, Limit: 2}, []string{"ab", "b"}},
+		{"started", spellbook.Query{Order: "Order", Start: 2}, []string{"b", "ab"}},
+		{"equal", spellbook.Query{Filters: []spellbook.Filter{{Field: "Name", Operator: spellbook.FilterEqual, Value: "b"}}}, []string{"b"}},
+		{"less", spellbook.Query{Filters: []spellbook.Filter{{Field: "Order", Operator: spellbook.FilterLess, Value: "2"}}}, []string{"c", "a"}},
+		{"prefix", spellbook.Query{Filters: []spellbook.Filter{{Field: "Name", Operator: spellbook.FilterPrefix, Value: "a"}}}, []string{"a", "ab"}},
+		{"in", spellbook.Query{Filters: []spellbook.Filter{{Field: "Name", Operator: spellbook.FilterIn, Value: "c,ab"}}}, []string{"c", "ab"}},
+		{"list field", spellbook.Query{Filters: []spellbook.Filter{{Field: "Tags", Operator: spellbook.FilterEqual, Value: "a"}}}, []string{"a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := names(t, repository, test.query); !equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	count, err := repository.Count(context.Background(), spellbook.Query{Filters: []spellbook.Filter{{Field: "Tags", Operator: spellbook.FilterEqual, Value: "all"}}, Limit: 1})
+	if err != nil || count != 4 {
+		t.Errorf("got count %d and error %v, want 4", count, err)
+	}
+
+	if _, err := repository.ListOf(context.Background(), spellbook.Query{Order: "Missing"}); err == nil {
+		t.Error("missing order field accepted")
+	}
+
+	repository.Fields = spellbook.FilterFields{"Name": spellbook.FieldString}
+	if _, err := repository.ListOf(context.Background(), spellbook.Query{Filters: []spellbook.Filter{{Field: "Order", Value: "1"}}}); err == nil {
+		t.Error("filter on a field not in Fields accepted")
+	}
+}
+
+func TestRepositoryTrash(t *testing.T) {
+	ctx := context.Background()
+	repository, things := newThings(t, "a", "b")
+
+	if err := repository.Trash(ctx, things[0]); err != nil {
+		t.Fatalf("error trashing a: %s", err)
+	}
+	if _, err := repository.FromId(ctx, "1"); !spellbook.IsNotFound(err) {
+		t.Errorf("trashed resource: got error %v, want not found", err)
+	}
+	if got := names(t, repository, spellbook.Query{}); !equal(got, []string{"b"}) {
+		t.Errorf("got %v, want the untrashed resources only", got)
+	}
+	if got := names(t, repository, spellbook.Query{Trashed: true}); !equal(got, []string{"a", "b"}) {
+		t.Errorf("got %v, want the trashed resources too", got)
+	}
+	if _, err := repository.Trashed(ctx, "2"); !spellbook.IsNotFound(err) {
+		t.Errorf("untrashed resource: got error %v, want not found", err)
+	}
+
+	trashed, err := repository.Trashed(ctx, "1")
+	if err != nil {
+		t.Fatalf("error retrieving trashed a: %s", err)
+	}
+	if err := repository.Restore(ctx, trashed); err != nil {
+		t.Fatalf("error restoring a: %s", err)
+	}
+	if res, err := repository.FromId(ctx, "1"); err != nil || res.(*thing).Name != "a" {
+		t.Errorf("restored resource: got %v and error %v", res, err)
+	}
+
+	if err := repository.Trash(ctx, things[1]); err != nil {
+		t.Fatalf("error trashing b: %s", err)
+	}
+	if err := repository.Delete(ctx, things[1]); err != nil {
+		t.Fatalf("error purging b: %s", err)
+	}
+	if _, err := repository.Trashed(ctx, "2"); !spellbook.IsNotFound(err) {
+		t.Errorf("purged resource: got error %v, want not found", err)
+	}
+}
+
+func TestRepositoryTransaction(t *testing.T) {
+	ctx := context.Background()
+	repository, things := newThings(t, "a", "b")
+
+	failure := errors.New("failure")
+	err := repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := repository.Create(ctx, &thing{Name: "c"}); err != nil {
+			return err
+		}
+		if err := repository.Trash(ctx, things[0]); err != nil {
+			return err
+		}
+		if err := repository.Delete(ctx, things[1]); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	if got := names(t, repository, spellbook.Query{Trashed: true}); !equal(got, []string{"a", "b"}) {
+		t.Errorf("got %v after the rollback, want %v", got, []string{"a", "b"})
+	}
+	if _, err := repository.FromId(ctx, "1"); err != nil {
+		t.Errorf("the trash has not been rolled back: %v", err)
+	}
+
+	err = repository.RunInTransaction(ctx, func(ctx context.Context) error {
+		return repository.Create(ctx, &thing{Name: "c"})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := names(t, repository, spellbook.Query{}); !equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("got %v after the commit, want %v", got, []string{"a", "b", "c"})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"decodica.com/flamel/model"
 	"decodica.com/spellbook"
 	"encoding/json"
+	"time"
 )
 
 const rootUrl = ""
@@ -18,6 +19,8 @@ type Page struct {
 	IsRoot      bool
 	Code        spellbook.StaticPageCode
 	Locale      string
+	// when the page was moved to the trash, see spellbook.TrashRepository
+	Deleted time.Time
 }
 
 func (p Page) LocalizedUrl() string {
@@ -80,7 +83,11 @@ func (p *Page) MarshalJSON() ([]byte, error) {
 }
 
 func (p *Page) Id() string {
-	return p.StringID()
+	if id := p.StringID(); id != "" {
+		return id
+	}
+	// pages stored outside of the datastore have no key, which would be their PageKey
+	return PageId(p.Locale, p.Url)
 }
 
 func (p *Page) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
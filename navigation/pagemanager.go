@@ -1,13 +1,14 @@
 package navigation
 
 import (
-	"cloud.google.com/go/datastore"
 	"context"
 	"decodica.com/flamel/model"
 	"decodica.com/spellbook"
+	"decodica.com/spellbook/trash"
 	"errors"
 	"fmt"
 	"google.golang.org/appengine/log"
+	"time"
 )
 
 func NewPageController() *spellbook.RestController {
@@ -21,7 +22,63 @@ func NewPageControllerWithKey(key string) *spellbook.RestController {
 	return c
 }
 
-type PageManager struct{}
+// PageManager handles the pages stored in the repository, moving the deleted ones to the Trash,
+// where they are kept in place, with their url and code, until they are restored or purged, see spellbook.TrashRepository.
+// The zero value stores them in the datastore, managers with a Repository and no Trash delete permanently.
+// Pages are keyed by locale and url: other repositories must key them by PageKey
+type PageManager struct {
+	Repository spellbook.Repository
+	Trash      spellbook.Repository
+}
+
+// trash item type of the pages
+const TrashTypePage = "page"
+
+func (manager PageManager) pages() spellbook.Repository {
+	if manager.Repository == nil {
+		return pageRepository{}
+	}
+	return manager.Repository
+}
+
+// pages are moved to the trash only if their repository can keep them there
+func (manager PageManager) trash() spellbook.Repository {
+	if _, ok := manager.pages().(spellbook.TrashRepository); !ok {
+		return nil
+	}
+	if manager.Trash == nil && manager.Repository == nil {
+		return trash.DatastoreRepository{}
+	}
+	return manager.Trash
+}
+
+// the cached menu is built from the datastore pages
+func (manager PageManager) invalidateMenu(ctx context.Context) {
+	if manager.Repository == nil {
+		InvalidateMenu(ctx)
+	}
+}
+
+// fields the pages can be filtered by
+var pageFilterFields = spellbook.FilterFields{
+	"Label":  spellbook.FieldString,
+	"Url":    spellbook.FieldString,
+	"Order":  spellbook.FieldInt,
+	"IsRoot": spellbook.FieldBool,
+	"Code":   spellbook.FieldString,
+	"Locale": spellbook.FieldString,
+}
+
+// Describe describes the pages for the OpenAPI document
+func (manager PageManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "Page",
+		Filterable:       pageFilterFields,
+		Orderable:        []string{"Order", "Label"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadPage},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWritePage},
+	}
+}
 
 func (manager PageManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &Page{}, nil
@@ -32,13 +89,13 @@ func (manager PageManager) FromId(ctx context.Context, id string) (spellbook.Res
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadPage))
 	}
 
-	cont := Page{}
-	if err := model.FromStringID(ctx, &cont, id, nil); err != nil {
+	cont, err := manager.pages().FromId(ctx, id)
+	if err != nil {
 		log.Errorf(ctx, "could not retrieve seo %s: %s", id, err.Error())
 		return nil, err
 	}
 
-	return &cont, nil
+	return cont, nil
 }
 
 func (manager PageManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
@@ -46,42 +103,26 @@ func (manager PageManager) ListOf(ctx context.Context, opts spellbook.ListOption
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadPage))
 	}
 
-	var conts []*Page
-	q := model.NewQuery(&Page{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
-	}
-
+	query := spellbook.QueryFromOptions(opts)
+	query.Offset = opts.Page * opts.Size
 	// get one more so we know if we are done
-	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &conts)
-	if err != nil {
-		return nil, err
-	}
+	query.Limit = opts.Size + 1
 
-	resources := make([]spellbook.Resource, len(conts))
-	for i := range conts {
-		resources[i] = conts[i]
-	}
-
-	return resources, nil
+	return manager.pages().ListOf(ctx, query)
 }
 
 func (manager PageManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
 	return nil, spellbook.NewUnsupportedError()
 }
 
+// returns the filters of the pages with the same code of the given one
+func codeFilters(p *Page) []spellbook.Filter {
+	return []spellbook.Filter{
+		{Field: "Code", Operator: spellbook.FilterEqual, Value: string(p.Code)},
+		{Field: "Locale", Operator: spellbook.FilterEqual, Value: p.Locale},
+	}
+}
+
 func (manager PageManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 
 	current := spellbook.IdentityFromContext(ctx)
@@ -89,37 +130,41 @@ func (manager PageManager) Create(ctx context.Context, res spellbook.Resource, b
 		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWritePage))
 	}
 
-	p := res.(*Page)
+	return manager.create(ctx, res.(*Page))
+}
+
+// creates the page if no other page, trashed ones included, has the same url or code
+func (manager PageManager) create(ctx context.Context, p *Page) error {
+	if pages, ok := manager.pages().(spellbook.TrashRepository); ok {
+		if _, err := pages.Trashed(ctx, PageId(p.Locale, p.Url)); err == nil {
+			msg := fmt.Sprintf("a seo for url %q is in the trash.", p.Url)
+			return spellbook.NewFieldError("", errors.New(msg))
+		} else if !spellbook.IsNotFound(err) {
+			return err
+		}
+	}
 
 	// if the same seo already exists, we must return false
-	existing, _ := manager.NewResource(ctx)
-	err := model.FromStringID(ctx, existing.(*Page), PageId(p.Locale, p.Url), nil)
-	if err == datastore.ErrNoSuchEntity {
+	_, err := manager.pages().FromId(ctx, PageId(p.Locale, p.Url))
+	if spellbook.IsNotFound(err) {
 		// we can create the new seo element if another one with the same code doesn't exists
-		q := model.NewQuery((*Page)(nil))
-		q.WithField("Code =", string(p.Code))
-		q.WithField("Locale =", p.Locale)
-
-		err = q.First(ctx, &Page{})
+		count, err := manager.pages().Count(ctx, spellbook.Query{Filters: codeFilters(p), Trashed: true})
+		if err != nil {
+			return err
+		}
 
 		// seo already exists for given code, can't create
-		if err == nil {
+		if count > 0 {
 			msg := fmt.Sprintf("a page for %q already exists.", p.Code)
 			return spellbook.NewFieldError("", errors.New(msg))
 		}
 
-		if err == datastore.ErrNoSuchEntity {
-			p.IsRoot = p.Url == rootUrl
-			opts := model.NewCreateOptions()
-			opts.WithStringId(PageId(p.Locale, p.Url))
-			err = model.CreateWithOptions(ctx, p, &opts)
-		}
-
-		if err != nil {
+		p.IsRoot = p.Url == rootUrl
+		if err := manager.pages().Create(ctx, p); err != nil {
 			return err
 		}
 
-		InvalidateMenu(ctx)
+		manager.invalidateMenu(ctx)
 		return nil
 	}
 
@@ -145,22 +190,22 @@ func (manager PageManager) Update(ctx context.Context, res spellbook.Resource, b
 	other := or.(*Page)
 
 	if err := other.FromRepresentation(spellbook.RepresentationTypeJSON, bundle); err != nil {
-		return spellbook.NewFieldError("", fmt.Errorf("invalid json for seo %q: %s", p.StringID(), err.Error()))
+		return spellbook.NewFieldError("", fmt.Errorf("invalid json for seo %q: %s", PageKey(p), err.Error()))
 	}
 
-	if err := model.FromStringID(ctx, p, PageId(other.Locale, other.Url), nil); err != nil {
+	stored, err := manager.pages().FromId(ctx, PageId(other.Locale, other.Url))
+	if err != nil {
 		return spellbook.NewUnsupportedError()
 	}
+	*p = *stored.(*Page)
 
-	q := model.NewQuery((*Page)(nil))
-	q.WithField("Code =", other.Code)
-	q.WithField("Locale =", other.Locale)
-
-	existing := Page{}
-	err := q.First(ctx, &existing)
+	existing, err := manager.pages().ListOf(ctx, spellbook.Query{Filters: codeFilters(other), Limit: 1})
+	if err != nil {
+		return err
+	}
 
 	// seo already exists for given code, can't create
-	if err == nil && existing.StringID() != PageId(other.Locale, other.Url) {
+	if len(existing) > 0 && PageKey(existing[0]) != PageId(other.Locale, other.Url) {
 		msg := fmt.Sprintf("a page for %q already exists.", other.Code)
 		return spellbook.NewFieldError("", errors.New(msg))
 	}
@@ -171,23 +216,225 @@ func (manager PageManager) Update(ctx context.Context, res spellbook.Resource, b
 	p.MetaDesc = other.MetaDesc
 	p.Code = other.Code
 
-	if err := model.Update(ctx, p); err != nil {
+	if err := manager.pages().Update(ctx, p); err != nil {
 		return fmt.Errorf("error updating seo with url %q: %s", p.Url, err)
 	}
 
-	InvalidateMenu(ctx)
+	manager.invalidateMenu(ctx)
 	return nil
 }
 
 func (manager PageManager) Delete(ctx context.Context, res spellbook.Resource) error {
 
+	current := spellbook.IdentityFromContext(ctx)
+	if current == nil || !current.HasPermission(spellbook.PermissionWritePage) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWritePage))
+	}
+
 	p := res.(*Page)
-	err := model.Delete(ctx, p, nil)
+
+	var item *trash.Item
+	if manager.trash() != nil {
+		var err error
+		if item, err = trash.NewItem(ctx, TrashTypePage, p.Label, p); err != nil {
+			return err
+		}
+	}
+
+	err := spellbook.RunInTransaction(ctx, func(ctx context.Context) error {
+		if item == nil {
+			if err := manager.pages().Delete(ctx, p); err != nil {
+				log.Errorf(ctx, "error deleting seo with url %q: %s", p.Url, err.Error())
+				return err
+			}
+			return nil
+		}
+
+		if err := manager.pages().(spellbook.TrashRepository).Trash(ctx, p); err != nil {
+			log.Errorf(ctx, "error moving seo with url %q to the trash: %s", p.Url, err.Error())
+			return err
+		}
+		if err := manager.trash().Create(ctx, item); err != nil {
+			log.Errorf(ctx, "error moving seo with url %q to the trash: %s", p.Url, err.Error())
+			return err
+		}
+		return nil
+	}, manager.pages(), manager.trash())
+	if err != nil {
+		return err
+	}
+
+	manager.invalidateMenu(ctx)
+	return nil
+}
+
+// returns the trashed page of the item, or a not found error if it is no longer in the trash
+func (manager PageManager) trashed(ctx context.Context, item *trash.Item) (*Page, error) {
+	pages, ok := manager.pages().(spellbook.TrashRepository)
+	if !ok {
+		return nil, spellbook.ErrNotFound
+	}
+	res, err := pages.Trashed(ctx, item.ResourceId)
+	if err != nil {
+		return nil, err
+	}
+	return res.(*Page), nil
+}
+
+// Restore brings back a trashed page with its url and locale,
+// unless another page has taken its code in the meantime
+func (manager PageManager) Restore(ctx context.Context, item *trash.Item) error {
+	p, err := manager.trashed(ctx, item)
+	if spellbook.IsNotFound(err) {
+		return spellbook.NewFieldError("resourceId", fmt.Errorf("page %s is no longer in the trash", item.ResourceId))
+	}
+	if err != nil {
+		return err
+	}
+
+	count, err := manager.pages().Count(ctx, spellbook.Query{Filters: codeFilters(p)})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		msg := fmt.Sprintf("a page for %q already exists.", p.Code)
+		return spellbook.NewFieldError("", errors.New(msg))
+	}
+
+	if err := manager.pages().(spellbook.TrashRepository).Restore(ctx, p); err != nil {
+		log.Errorf(ctx, "error restoring seo with url %q: %s", p.Url, err.Error())
+		return err
+	}
+
+	manager.invalidateMenu(ctx)
+	return nil
+}
+
+// Purge deletes for good a trashed page. Pages no longer in the trash are left untouched
+func (manager PageManager) Purge(ctx context.Context, item *trash.Item) error {
+	p, err := manager.trashed(ctx, item)
+	if spellbook.IsNotFound(err) {
+		return nil
+	}
 	if err != nil {
-		log.Errorf(ctx, "error deleting seo with url %q: %s", p.Url, err.Error())
 		return err
 	}
 
-	InvalidateMenu(ctx)
+	if err := manager.pages().Delete(ctx, p); err != nil {
+		log.Errorf(ctx, "error purging seo with url %q: %s", p.Url, err.Error())
+		return err
+	}
 	return nil
 }
+
+// Resave saves again all the pages, see spellbook.Resave
+func (manager PageManager) Resave(ctx context.Context) (int, error) {
+	return spellbook.Resave(ctx, manager.pages(), nil)
+}
+
+// PageKey returns the key of a page, made of its locale and url
+func PageKey(res spellbook.Resource) string {
+	p := res.(*Page)
+	return PageId(p.Locale, p.Url)
+}
+
+// datastore storage of the pages, the default of the PageManager
+type pageRepository struct{}
+
+func (repository pageRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	cont := Page{}
+	if err := model.FromStringID(ctx, &cont, id, nil); err != nil {
+		return nil, err
+	}
+	if !cont.Deleted.IsZero() {
+		return nil, spellbook.ErrNotFound
+	}
+	return &cont, nil
+}
+
+func (repository pageRepository) query(query spellbook.Query) (*model.Query, error) {
+	return spellbook.DatastoreQuery(model.NewQuery(&Page{}), query, pageFilterFields)
+}
+
+// lists the pages of the query, the trashed ones included, see spellbook.DatastoreMerged
+func (repository pageRepository) list(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreMerged(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		q, err := repository.query(query)
+		if err != nil {
+			return nil, err
+		}
+
+		var conts []*Page
+		if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &conts); err != nil {
+			return nil, err
+		}
+
+		resources := make([]spellbook.Resource, len(conts))
+		for i := range conts {
+			resources[i] = conts[i]
+		}
+		return resources, nil
+	})
+}
+
+func trashedPage(res spellbook.Resource) bool {
+	return !res.(*Page).Deleted.IsZero()
+}
+
+func (repository pageRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	return spellbook.DatastoreUntrashed(query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return repository.list(ctx, query)
+	}, trashedPage)
+}
+
+func (repository pageRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	return spellbook.DatastoreCountUntrashed(ctx, query, repository.query, func(query spellbook.Query) ([]spellbook.Resource, error) {
+		return repository.list(ctx, query)
+	}, trashedPage)
+}
+
+func (repository pageRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	opts := model.NewCreateOptions()
+	opts.WithStringId(PageKey(res))
+	return model.CreateWithOptions(ctx, res.(*Page), &opts)
+}
+
+func (repository pageRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return model.Update(ctx, res.(*Page))
+}
+
+func (repository pageRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*Page), nil)
+}
+
+// Trash marks the page as deleted, see spellbook.TrashRepository
+func (repository pageRepository) Trash(ctx context.Context, res spellbook.Resource) error {
+	page := res.(*Page)
+	page.Deleted = time.Now().UTC()
+	return repository.Update(ctx, page)
+}
+
+// Trashed returns the page with the given id if it is in the trash
+func (repository pageRepository) Trashed(ctx context.Context, id string) (spellbook.Resource, error) {
+	cont := Page{}
+	if err := model.FromStringID(ctx, &cont, id, nil); err != nil {
+		return nil, err
+	}
+	if cont.Deleted.IsZero() {
+		return nil, spellbook.ErrNotFound
+	}
+	return &cont, nil
+}
+
+// Restore clears the deleted mark of the page
+func (repository pageRepository) Restore(ctx context.Context, res spellbook.Resource) error {
+	page := res.(*Page)
+	page.Deleted = time.Time{}
+	return repository.Update(ctx, page)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository pageRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
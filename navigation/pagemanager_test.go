@@ -0,0 +1,30 @@
+package navigation
+
+import (
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/memory"
+	"decodica.com/spellbook/spellbooktest"
+	"fmt"
+	"testing"
+)
+
+func TestPageManagerSuite(t *testing.T) {
+	user := spellbooktest.NewUser("admin", spellbook.PermissionReadPage, spellbook.PermissionWritePage)
+	spellbooktest.ManagerSuite{
+		Manager: PageManager{Repository: &memory.Repository{Key: PageKey}},
+		Context: spellbooktest.NewContext(user),
+		Bundle: func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"label":"Page %d","title":"Page %d","url":"page-%d","locale":"en","code":"page-%d","order":%d}`, i, i, i, i, i))
+		},
+		UpdateBundle: func(i int) []byte {
+			return []byte(fmt.Sprintf(`{"label":"Updated %d","title":"Updated %d","url":"page-%d","locale":"en","code":"page-%d","order":%d}`, i, i, i, i, i))
+		},
+		Order: "Order",
+		Filter: func(i int) spellbook.Filter {
+			return spellbook.Filter{Field: "Url", Operator: spellbook.FilterEqual, Value: fmt.Sprintf("page-%d", i)}
+		},
+		Duplicate: func() []byte {
+			return []byte(`{"label":"Duplicate","title":"Duplicate","url":"page-0","locale":"en","code":"duplicate"}`)
+		},
+	}.Run(t)
+}
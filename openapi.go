@@ -0,0 +1,393 @@
+package spellbook
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResourceDescription describes a REST resource for the generated OpenAPI document
+type ResourceDescription struct {
+	// name of the resource schema, defaults to the name of the resource type
+	Name        string
+	Description string
+	// JSON schema of the resource, derived from the JSON representation of a new resource if nil
+	Schema     map[string]interface{}
+	Filterable FilterFields
+	Orderable  []string
+	// if the resources can be searched with the q parameter
+	Searchable bool
+	// permissions granting read and write access, any of them is enough
+	ReadPermissions  []Permission
+	WritePermissions []Permission
+}
+
+// Describer is implemented by managers that describe the resources they handle
+type Describer interface {
+	Describe() ResourceDescription
+}
+
+// OpenAPIController serves the OpenAPI 3 document of the routes
+// registered with InternationalRouter.SetUniversalRoute
+type OpenAPIController struct {
+	Title   string
+	Version string
+}
+
+func NewOpenAPIController() *OpenAPIController {
+	return &OpenAPIController{Title: "Spellbook API", Version: "1.0.0"}
+}
+
+func (controller *OpenAPIController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	ins := InputsFromContext(ctx)
+	if ins[flamel.KeyRequestMethod].Value() != http.MethodGet {
+		return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = NewOpenAPIDocument(ctx, controller.Title, controller.Version, Application().Router.UniversalRoutes())
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *OpenAPIController) OnDestroy(ctx context.Context) {}
+
+// NewOpenAPIDocument builds the OpenAPI 3 document of the given routes.
+// Routes served by a RestController are described through their manager, the others are only listed
+func NewOpenAPIDocument(ctx context.Context, title string, version string, routes []Route) map[string]interface{} {
+	paths := map[string]interface{}{}
+	schemas := map[string]interface{}{
+		"Problem": problemSchema(),
+	}
+
+	for _, route := range routes {
+		path, params := openAPIPath(route.Path)
+
+		manager, single, ok := routeManager(ctx, route)
+		if !ok {
+			paths[path] = map[string]interface{}{
+				"get": map[string]interface{}{
+					"parameters": params,
+					"responses": map[string]interface{}{
+						"default": map[string]interface{}{"description": "response of the controller"},
+					},
+				},
+			}
+			continue
+		}
+
+		desc := describe(ctx, manager)
+		if _, ok := schemas[desc.Name]; !ok {
+			schemas[desc.Name] = desc.Schema
+		}
+
+		var security []interface{}
+		if route.Authenticator != nil {
+			security = []interface{}{map[string]interface{}{"token": []string{}}}
+		}
+
+		paths[path] = resourcePath(desc, params, single, security)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": HeaderToken,
+				},
+			},
+		},
+	}
+}
+
+// converts the flamel route parameters to the OpenAPI templated form, e.g. /api/content/:id to /api/content/{id}
+func openAPIPath(route string) (string, []interface{}) {
+	params := []interface{}{}
+	segments := strings.Split(route, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			name := s[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// instantiates the controller of the route and returns its manager, if it's a RestController,
+// and if the controller addresses a single resource through its key.
+// The route parameters are set to their templated names, so that handlers reading the key from them get one
+func routeManager(ctx context.Context, route Route) (manager Manager, single bool, ok bool) {
+	// handlers may rely on the inputs of an actual request
+	defer func() {
+		if recover() != nil {
+			manager, single, ok = nil, false, false
+		}
+	}()
+
+	params := Inputs{}
+	for _, s := range strings.Split(route.Path, "/") {
+		if strings.HasPrefix(s, ":") {
+			params[s[1:]] = NewInput("{" + s[1:] + "}")
+		}
+	}
+
+	c, isRest := route.Handler(ContextWithRoutingParams(ctx, params)).(interface{ rest() *RestController })
+	if !isRest {
+		return nil, false, false
+	}
+
+	h, hasManager := c.rest().RestHandler.(interface{ manager() Manager })
+	if !hasManager || h.manager() == nil {
+		return nil, false, false
+	}
+	return h.manager(), c.rest().Key != "", true
+}
+
+// returns the description of the manager, filling in what it doesn't provide
+func describe(ctx context.Context, manager Manager) ResourceDescription {
+	desc := ResourceDescription{}
+	if d, ok := manager.(Describer); ok {
+		desc = d.Describe()
+	}
+
+	var resource Resource
+	func() {
+		defer func() {
+			recover()
+		}()
+		resource, _ = manager.NewResource(ctx)
+	}()
+
+	if desc.Name == "" {
+		desc.Name = reflect.Indirect(reflect.ValueOf(manager)).Type().Name()
+		if resource != nil {
+			desc.Name = reflect.Indirect(reflect.ValueOf(resource)).Type().Name()
+		}
+	}
+
+	if desc.Schema == nil {
+		desc.Schema = map[string]interface{}{"type": "object"}
+		if resource != nil {
+			if j, err := resource.ToRepresentation(RepresentationTypeJSON); err == nil {
+				var v interface{}
+				if json.Unmarshal(j, &v) == nil {
+					desc.Schema = schemaOf(v)
+				}
+			}
+		}
+	}
+	return desc
+}
+
+// derives the JSON schema of a value decoded from JSON
+func schemaOf(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		props := map[string]interface{}{}
+		for k, p := range t {
+			props[k] = schemaOf(p)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	case []interface{}:
+		items := map[string]interface{}{}
+		if len(t) > 0 {
+			items = schemaOf(t[0])
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case string:
+		if _, err := time.Parse(time.RFC3339, t); err == nil {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return map[string]interface{}{"type": "string"}
+	case float64:
+		return map[string]interface{}{"type": "number"}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	}
+	return map[string]interface{}{"nullable": true}
+}
+
+func problemSchema() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type":     str,
+			"title":    str,
+			"status":   map[string]interface{}{"type": "integer"},
+			"detail":   str,
+			"instance": str,
+			"errors": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"field": str,
+						"error": str,
+					},
+				},
+			},
+		},
+	}
+}
+
+func permissionNames(permissions []Permission) []string {
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = PermissionName(p)
+	}
+	return names
+}
+
+// builds the path item of a resource route, of a single item or of a collection.
+// Collections nested under a resource, e.g. /api/content/{id}/revisions, keep the parameters of their path
+func resourcePath(desc ResourceDescription, params []interface{}, item bool, security []interface{}) map[string]interface{} {
+	ref := map[string]interface{}{"$ref": "#/components/schemas/" + desc.Name}
+	body := map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref},
+		},
+	}
+	problem := map[string]interface{}{
+		"description": "error",
+		"content": map[string]interface{}{
+			MediaTypeProblem: map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Problem"}},
+		},
+	}
+	single := map[string]interface{}{
+		"description": desc.Name,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref},
+		},
+	}
+
+	operation := func(summary string, permissions []Permission, responses map[string]interface{}) map[string]interface{} {
+		responses["default"] = problem
+		op := map[string]interface{}{
+			"summary":       summary,
+			"parameters":    params,
+			"responses":     responses,
+			"x-permissions": permissionNames(permissions),
+		}
+		if desc.Description != "" {
+			op["description"] = desc.Description
+		}
+		if security != nil {
+			op["security"] = security
+		}
+		return op
+	}
+
+	if item {
+		patch := operation("patch "+desc.Name, desc.WritePermissions, map[string]interface{}{"200": single})
+		patch["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				MediaTypeMergePatch: map[string]interface{}{"schema": map[string]interface{}{"type": "object"}},
+				MediaTypeJSONPatch:  map[string]interface{}{"schema": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}}},
+			},
+		}
+		put := operation("update "+desc.Name, desc.WritePermissions, map[string]interface{}{"200": single})
+		put["requestBody"] = body
+
+		return map[string]interface{}{
+			"get":    operation("get "+desc.Name, desc.ReadPermissions, map[string]interface{}{"200": single, "304": map[string]interface{}{"description": "not modified"}}),
+			"put":    put,
+			"patch":  patch,
+			"delete": operation("delete "+desc.Name, desc.WritePermissions, map[string]interface{}{"200": map[string]interface{}{"description": "deleted"}}),
+		}
+	}
+
+	list := operation("list "+desc.Name, desc.ReadPermissions, map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "page of " + desc.Name,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"items": map[string]interface{}{"type": "array", "items": ref},
+						"more":  map[string]interface{}{"type": "boolean"},
+						"next":  map[string]interface{}{"type": "string"},
+						"total": map[string]interface{}{"type": "integer"},
+						"pages": map[string]interface{}{"type": "integer"},
+					},
+				}},
+			},
+		},
+	})
+	list["parameters"] = append(append([]interface{}{}, params...), listParameters(desc)...)
+
+	create := operation("create "+desc.Name, desc.WritePermissions, map[string]interface{}{"201": single})
+	create["requestBody"] = body
+
+	return map[string]interface{}{
+		"get":  list,
+		"post": create,
+	}
+}
+
+func listParameters(desc ResourceDescription) []interface{} {
+	query := func(name string, typ string, description string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":        name,
+			"in":          "query",
+			"description": description,
+			"schema":      map[string]interface{}{"type": typ},
+		}
+	}
+
+	filterable := make([]string, 0, len(desc.Filterable))
+	for f := range desc.Filterable {
+		filterable = append(filterable, f)
+	}
+	sort.Strings(filterable)
+
+	order := query("order", "string", "field to order by, prefixed by - for descending order")
+	if len(desc.Orderable) > 0 {
+		enum := make([]string, 0, len(desc.Orderable)*2)
+		for _, o := range desc.Orderable {
+			enum = append(enum, o, "-"+o)
+		}
+		order["schema"] = map[string]interface{}{"type": "string", "enum": enum}
+	}
+
+	filter := query("filter", "string", "filters separated by ^, see FilterOperator")
+	if len(filterable) > 0 {
+		filter["x-filterable"] = filterable
+	}
+
+	parameters := []interface{}{
+		query("page", "integer", "page number, starting from 0"),
+		query("results", "integer", "page size"),
+		query("cursor", "string", "cursor of the page, as returned by next"),
+		query("count", "boolean", "if the total number of results is returned"),
+		query(KeyFields, "string", "comma separated fields to return"),
+		order,
+		filter,
+	}
+	if desc.Searchable {
+		parameters = append(parameters, query("q", "string", "full-text search, the best matches first"))
+	}
+	return parameters
+}
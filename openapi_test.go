@@ -0,0 +1,101 @@
+package spellbook
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"testing"
+)
+
+type describedManager struct{}
+
+func (manager describedManager) Describe() ResourceDescription {
+	return ResourceDescription{Name: "Thing", Schema: map[string]interface{}{"type": "object"}, Orderable: []string{"Name"}}
+}
+
+func (manager describedManager) NewResource(ctx context.Context) (Resource, error) {
+	return nil, NewUnsupportedError()
+}
+
+func (manager describedManager) FromId(ctx context.Context, id string) (Resource, error) {
+	return nil, NewUnsupportedError()
+}
+
+func (manager describedManager) ListOf(ctx context.Context, opts ListOptions) ([]Resource, error) {
+	return nil, NewUnsupportedError()
+}
+
+func (manager describedManager) ListOfProperties(ctx context.Context, opts ListOptions) ([]string, error) {
+	return nil, NewUnsupportedError()
+}
+
+func (manager describedManager) Create(ctx context.Context, resource Resource, bundle []byte) error {
+	return NewUnsupportedError()
+}
+
+func (manager describedManager) Update(ctx context.Context, resource Resource, bundle []byte) error {
+	return NewUnsupportedError()
+}
+
+func (manager describedManager) Delete(ctx context.Context, resource Resource) error {
+	return NewUnsupportedError()
+}
+
+func TestOpenAPIDocumentOperations(t *testing.T) {
+	controller := func(param string) func(ctx context.Context) flamel.Controller {
+		return func(ctx context.Context) flamel.Controller {
+			c := NewRestController(BaseRestHandler{Manager: describedManager{}})
+			if param != "" {
+				c.Key = RoutingParams(ctx)[param].Value()
+			}
+			return c
+		}
+	}
+
+	routes := []Route{
+		{Path: "/api/things", Handler: controller("")},
+		{Path: "/api/things/:id", Handler: controller("id")},
+		{Path: "/api/things/:id/children", Handler: controller("")},
+		{Path: "/api/things/:id/children/:child", Handler: controller("child")},
+	}
+
+	tests := []struct {
+		path       string
+		operations []string
+		parameters []string
+	}{
+		{"/api/things", []string{"get", "post"}, []string{"page", "results", "cursor", "count", KeyFields, "order", "filter"}},
+		{"/api/things/{id}", []string{"get", "put", "patch", "delete"}, []string{"id"}},
+		{"/api/things/{id}/children", []string{"get", "post"}, []string{"id", "page", "results", "cursor", "count", KeyFields, "order", "filter"}},
+		{"/api/things/{id}/children/{child}", []string{"get", "put", "patch", "delete"}, []string{"id", "child"}},
+	}
+
+	doc := NewOpenAPIDocument(context.Background(), "test", "1", routes)
+	paths := doc["paths"].(map[string]interface{})
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			item, ok := paths[test.path].(map[string]interface{})
+			if !ok {
+				t.Fatalf("path missing from %v", paths)
+			}
+			if len(item) != len(test.operations) {
+				t.Errorf("got %d operations, want %v", len(item), test.operations)
+			}
+			for _, name := range test.operations {
+				if _, ok := item[name]; !ok {
+					t.Errorf("operation %s missing", name)
+				}
+			}
+
+			get := item["get"].(map[string]interface{})
+			params := get["parameters"].([]interface{})
+			if len(params) != len(test.parameters) {
+				t.Fatalf("got %d parameters of get, want %v", len(params), test.parameters)
+			}
+			for i, name := range test.parameters {
+				if got := params[i].(map[string]interface{})["name"]; got != name {
+					t.Errorf("parameter %d is %s, want %s", i, got, name)
+				}
+			}
+		})
+	}
+}
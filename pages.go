@@ -62,7 +62,7 @@ type FourOFourPage struct {
 func (page *FourOFourPage) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
 	if page.FileName != "" {
 		redir := page.StaticPage.Process(ctx, out)
-		out.AddHeader("Content-type", "text/html; charset=utf-8")
+		AddHeader(ctx, out, "Content-type", "text/html; charset=utf-8")
 		switch redir.Status {
 		case http.StatusOK:
 			return flamel.HttpResponse{Status: http.StatusNotFound}
@@ -85,7 +85,7 @@ type StatusTemplatedPage struct {
 func (page *StatusTemplatedPage) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
 	if page.FileName != "" {
 		redir := page.TemplatedPage.Process(ctx, out)
-		out.AddHeader("Content-type", "text/html; charset=utf-8")
+		AddHeader(ctx, out, "Content-type", "text/html; charset=utf-8")
 		switch redir.Status {
 		case http.StatusOK:
 			return flamel.HttpResponse{Status: page.Status}
@@ -108,7 +108,7 @@ type LocalizedStatusPage struct {
 func (page *LocalizedStatusPage) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
 	if page.FileName != "" {
 		redir := page.LocalizedPage.Process(ctx, out)
-		out.AddHeader("Content-type", "text/html; charset=utf-8")
+		AddHeader(ctx, out, "Content-type", "text/html; charset=utf-8")
 		switch redir.Status {
 		case http.StatusOK:
 			return flamel.HttpResponse{Status: page.Status}
@@ -330,7 +330,7 @@ func (page *LocalizedPage) Process(ctx context.Context, out *flamel.ResponseOutp
 		data = page.DataHandler.AssignData(ctx)
 	}
 
-	parms := flamel.InputsFromContext(ctx)
+	parms := InputsFromContext(ctx)
 	page.Url = parms[flamel.KeyRequestURL].Value()
 	// url without lang
 	page.Url = strings.Replace(page.Url, "/"+lang, "", 1)
@@ -361,12 +361,12 @@ type SendMailPage struct {
 }
 
 type Mailer interface {
-	ValidateAndSend(ctx context.Context, inputs flamel.RequestInputs) error
+	ValidateAndSend(ctx context.Context, inputs Inputs) error
 }
 
 func (page *SendMailPage) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
 
-	inputs := flamel.InputsFromContext(ctx)
+	inputs := InputsFromContext(ctx)
 
 	method := inputs[flamel.KeyRequestMethod].Value()
 
@@ -0,0 +1,335 @@
+package spellbook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaTypeMergePatch = "application/merge-patch+json"
+	MediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// MergePatch applies a JSON Merge Patch (RFC 7396) to the given document
+// and returns the patched document
+func MergePatch(document []byte, patch []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %s", err.Error())
+	}
+
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %s", err.Error())
+	}
+
+	return json.Marshal(mergePatch(doc, p))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		// non object patches replace the target entirely
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// operation is a single JSON Patch operation
+type operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+	// the value member is present, also when null
+	hasValue bool
+}
+
+// UnmarshalJSON records if the operation has a value, since a null value is a value
+func (op *operation) UnmarshalJSON(data []byte) error {
+	type alias operation
+	if err := json.Unmarshal(data, (*alias)(op)); err != nil {
+		return err
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	_, op.hasValue = members["value"]
+	return nil
+}
+
+// JSONPatch applies a JSON Patch (RFC 6902) to the given document
+// and returns the patched document.
+// Operations are applied in order and the whole patch fails if any of them fails
+func JSONPatch(document []byte, patch []byte) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %s", err.Error())
+	}
+
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid json patch: %s", err.Error())
+	}
+
+	for i, op := range ops {
+		var err error
+		doc, err = op.apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s) failed: %s", i, op.Op, op.Path, err.Error())
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+func (op operation) value() (interface{}, error) {
+	if !op.hasValue {
+		return nil, errors.New("missing value")
+	}
+	var v interface{}
+	if err := json.Unmarshal(op.Value, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (op operation) apply(doc interface{}) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, v)
+	case "remove":
+		doc, _, err := pointerRemove(doc, op.Path)
+		return doc, err
+	case "replace":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		// the whole document is replaced
+		if op.Path == "" {
+			return v, nil
+		}
+		doc, _, err = pointerRemove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, v)
+	case "move":
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("a location can't be moved into one of its children")
+		}
+		doc, v, err := pointerRemove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, v)
+	case "copy":
+		v, err := pointerGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		// copy the value so that the two locations do not share the same reference
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var cp interface{}
+		if err := json.Unmarshal(j, &cp); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, cp)
+	case "test":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := pointerGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, v) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// splits a JSON pointer (RFC 6901) into its unescaped reference tokens
+func pointerTokens(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		t = strings.Replace(t, "~1", "/", -1)
+		tokens[i] = strings.Replace(t, "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, t := range tokens {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			current = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			current = c[i]
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	}
+	return current, nil
+}
+
+// returns the container of the location referenced by the pointer and the last token
+func pointerParent(doc interface{}, pointer string) (interface{}, string, []string, error) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, "", nil, nil
+	}
+	parentPointer := pointer[:strings.LastIndex(pointer, "/")]
+	parent, err := pointerGet(doc, parentPointer)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return parent, tokens[len(tokens)-1], tokens[:len(tokens)-1], nil
+}
+
+// replaces the value at the given parent location with the updated container.
+// Needed because appending to or removing from a slice may reallocate it
+func pointerReplace(doc interface{}, parents []string, value interface{}) interface{} {
+	if len(parents) == 0 {
+		return value
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		c[parents[0]] = pointerReplace(c[parents[0]], parents[1:], value)
+		return c
+	case []interface{}:
+		i, _ := strconv.Atoi(parents[0])
+		c[i] = pointerReplace(c[i], parents[1:], value)
+		return c
+	}
+	return doc
+}
+
+func pointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	parent, last, parents, err := pointerParent(doc, pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	// the whole document is replaced
+	if pointer == "" {
+		return value, nil
+	}
+
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		c[last] = value
+		return doc, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(c), true)
+		if err != nil {
+			return nil, err
+		}
+		c = append(c, nil)
+		copy(c[i+1:], c[i:])
+		c[i] = value
+		return pointerReplace(doc, parents, c), nil
+	}
+	return nil, fmt.Errorf("path %q can't be added", pointer)
+}
+
+func pointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	if pointer == "" {
+		return nil, nil, errors.New("the document root can't be removed")
+	}
+
+	parent, last, parents, err := pointerParent(doc, pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		v, ok := c[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+		delete(c, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(c), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := c[i]
+		c = append(c[:i], c[i+1:]...)
+		return pointerReplace(doc, parents, c), v, nil
+	}
+	return nil, nil, fmt.Errorf("path %q does not exist", pointer)
+}
@@ -0,0 +1,143 @@
+package spellbook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// reports if the two JSON documents are equal, regardless of the order of their members
+func equalJSON(t *testing.T, a []byte, b string) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatalf("invalid document %s: %s", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("invalid document %s: %s", b, err)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"remove member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"remove missing member", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"nested", `{"a":{"b":"c","d":"e"}}`, `{"a":{"d":null,"f":"g"}}`, `{"a":{"b":"c","f":"g"}}`},
+		{"replace array", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"object over scalar", `{"a":"b"}`, `{"a":{"c":"d"}}`, `{"a":{"c":"d"}}`},
+		{"non object patch", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"empty patch", `{"a":"b"}`, `{}`, `{"a":"b"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(test.document), []byte(test.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !equalJSON(t, got, test.want) {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	if _, err := MergePatch([]byte(`{"a":`), []byte(`{}`)); err == nil {
+		t.Error("invalid document accepted")
+	}
+	if _, err := MergePatch([]byte(`{}`), []byte(`{"a":`)); err == nil {
+		t.Error("invalid patch accepted")
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+		want     string
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`},
+		{"add replaces member", `{"a":1}`, `[{"op":"add","path":"/a","value":2}]`, `{"a":2}`},
+		{"add null", `{"a":1}`, `[{"op":"add","path":"/b","value":null}]`, `{"a":1,"b":null}`},
+		{"add to array", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`},
+		{"append to array", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`},
+		{"add nested", `{"a":{"b":[{"c":1}]}}`, `[{"op":"add","path":"/a/b/0/d","value":2}]`, `{"a":{"b":[{"c":1,"d":2}]}}`},
+		{"add root", `{"a":1}`, `[{"op":"add","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{"remove member", `{"a":1,"b":2}`, `[{"op":"remove","path":"/a"}]`, `{"b":2}`},
+		{"remove from array", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`},
+		{"replace member", `{"a":1}`, `[{"op":"replace","path":"/a","value":2}]`, `{"a":2}`},
+		{"replace with null", `{"a":1}`, `[{"op":"replace","path":"/a","value":null}]`, `{"a":null}`},
+		{"replace in array", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/0","value":3}]`, `{"a":[3,2]}`},
+		{"replace root", `{"a":1}`, `[{"op":"replace","path":"","value":{"b":2}}]`, `{"b":2}`},
+		{"move member", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, `{"b":1}`},
+		{"move in array", `{"a":[1,2,3]}`, `[{"op":"move","from":"/a/0","path":"/a/2"}]`, `{"a":[2,3,1]}`},
+		{"copy member", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{"test", `{"a":[1,"b"]}`, `[{"op":"test","path":"/a","value":[1,"b"]}]`, `{"a":[1,"b"]}`},
+		{"test null", `{"a":null}`, `[{"op":"test","path":"/a","value":null}]`, `{"a":null}`},
+		{"escaped pointer", `{"a/b":1,"c~d":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/c~0d"}]`, `{"a/b":3}`},
+		{"operations in order", `{}`, `[{"op":"add","path":"/a","value":[]},{"op":"add","path":"/a/-","value":1},{"op":"add","path":"/a/0","value":0}]`, `{"a":[0,1]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := JSONPatch([]byte(test.document), []byte(test.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !equalJSON(t, got, test.want) {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		patch    string
+	}{
+		{"invalid patch", `{}`, `{"op":"add"}`},
+		{"unknown operation", `{}`, `[{"op":"merge","path":"/a","value":1}]`},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`},
+		{"invalid pointer", `{}`, `[{"op":"add","path":"a","value":1}]`},
+		{"add to missing parent", `{}`, `[{"op":"add","path":"/a/b","value":1}]`},
+		{"add out of bounds", `{"a":[]}`, `[{"op":"add","path":"/a/1","value":1}]`},
+		{"leading zero index", `{"a":[1,2]}`, `[{"op":"replace","path":"/a/01","value":1}]`},
+		{"remove missing member", `{}`, `[{"op":"remove","path":"/a"}]`},
+		{"remove root", `{}`, `[{"op":"remove","path":""}]`},
+		{"replace missing member", `{}`, `[{"op":"replace","path":"/a","value":1}]`},
+		{"move into child", `{"a":{}}`, `[{"op":"move","from":"/a","path":"/a/b"}]`},
+		{"copy missing member", `{}`, `[{"op":"copy","from":"/a","path":"/b"}]`},
+		{"failed test", `{"a":1}`, `[{"op":"test","path":"/a","value":2}]`},
+		{"failed test of null", `{"a":1}`, `[{"op":"test","path":"/a","value":null}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := JSONPatch([]byte(test.document), []byte(test.patch)); err == nil {
+				t.Errorf("expected an error, got %s", got)
+			}
+		})
+	}
+}
+
+func TestJSONPatchAtomic(t *testing.T) {
+	document := []byte(`{"a":1}`)
+	if _, err := JSONPatch(document, []byte(`[{"op":"replace","path":"/a","value":2},{"op":"test","path":"/a","value":3}]`)); err == nil {
+		t.Fatal("expected the patch to fail")
+	}
+	if string(document) != `{"a":1}` {
+		t.Errorf("the document was changed to %s", document)
+	}
+}
@@ -0,0 +1,126 @@
+package spellbook
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const MediaTypeProblem = "application/problem+json"
+
+// Problem types of the errors handled by spellbook
+const (
+	ProblemTypeDefault     = "about:blank"
+	ProblemTypeValidation  = "urn:spellbook:problem:validation"
+	ProblemTypePermission  = "urn:spellbook:problem:permission"
+	ProblemTypeUnsupported = "urn:spellbook:problem:unsupported"
+	ProblemTypeNotFound    = "urn:spellbook:problem:not-found"
+)
+
+// Problem is the RFC 7807 representation of an error returned by the REST handlers.
+// Errors lists the field errors of validation problems
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func (problem Problem) Error() string {
+	if problem.Detail != "" {
+		return problem.Detail
+	}
+	return problem.Title
+}
+
+// NewProblem returns a problem with the default type and the title of the given status
+func NewProblem(status int, detail string) Problem {
+	return Problem{Type: ProblemTypeDefault, Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// ErrorMapper converts an error to a Problem.
+// It returns nil if the error is not one it handles
+type ErrorMapper func(err error) *Problem
+
+var mappers struct {
+	sync.RWMutex
+	list []ErrorMapper
+}
+
+// RegisterErrorMapper adds a mapper for custom error types.
+// Mappers are tried in registration order, before the spellbook errors are mapped
+func RegisterErrorMapper(mapper ErrorMapper) {
+	mappers.Lock()
+	defer mappers.Unlock()
+	mappers.list = append(mappers.list, mapper)
+}
+
+// ProblemFromError converts an error to its Problem
+func ProblemFromError(err error) Problem {
+	mappers.RLock()
+	for _, mapper := range mappers.list {
+		if p := mapper(err); p != nil {
+			mappers.RUnlock()
+			return *p
+		}
+	}
+	mappers.RUnlock()
+
+	switch e := err.(type) {
+	case Problem:
+		return e
+	case *Problem:
+		return *e
+	case FieldError:
+		p := NewProblem(http.StatusBadRequest, e.Error())
+		p.Type = ProblemTypeValidation
+		p.Errors = []FieldError{e}
+		return p
+	case Errors:
+		p := NewProblem(http.StatusBadRequest, e.Error())
+		p.Type = ProblemTypeValidation
+		p.Errors = e.errors
+		return p
+	case *Errors:
+		return ProblemFromError(*e)
+	case PermissionError:
+		p := NewProblem(http.StatusForbidden, e.Error())
+		p.Type = ProblemTypePermission
+		return p
+	case UnsupportedError:
+		p := NewProblem(http.StatusMethodNotAllowed, e.Error())
+		p.Type = ProblemTypeUnsupported
+		return p
+	}
+
+	if IsNotFound(err) {
+		p := NewProblem(http.StatusNotFound, "resource not found")
+		p.Type = ProblemTypeNotFound
+		return p
+	}
+
+	// internal errors details are not disclosed
+	return NewProblem(http.StatusInternalServerError, "")
+}
+
+// RenderProblem renders the problem as the body of the response, setting its instance to the requested path
+func RenderProblem(ctx context.Context, problem Problem, out *flamel.ResponseOutput) flamel.HttpResponse {
+	if problem.Instance == "" {
+		ins := InputsFromContext(ctx)
+		if u, ok := ins[flamel.KeyRequestURL]; ok {
+			if parsed, err := url.Parse(u.Value()); err == nil {
+				problem.Instance = parsed.Path
+			}
+		}
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = problem
+	out.Renderer = &renderer
+	AddHeader(ctx, out, "Content-Type", MediaTypeProblem)
+	return flamel.HttpResponse{Status: problem.Status}
+}
@@ -0,0 +1,58 @@
+package spellbook
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// KeyFields is the input holding the comma separated list of fields to return
+const KeyFields = "fields"
+
+// parses the comma separated list of fields of a sparse fieldset request
+func parseFields(value string) []string {
+	var fields []string
+	for _, f := range strings.Split(value, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Project returns the JSON representation of the resource restricted to the given fields.
+// Unknown fields are ignored and the id is always included
+func Project(resource Resource, fields []string) (map[string]interface{}, error) {
+	j, err := resource.ToRepresentation(RepresentationTypeJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(j, &all); err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]interface{}, len(fields)+1)
+	if id, ok := all["id"]; ok {
+		projected["id"] = id
+	}
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			projected[f] = v
+		}
+	}
+	return projected, nil
+}
+
+// projects a list of resources
+func projectAll(resources []Resource, fields []string) ([]map[string]interface{}, error) {
+	projected := make([]map[string]interface{}, len(resources))
+	for i, r := range resources {
+		p, err := Project(r, fields)
+		if err != nil {
+			return nil, err
+		}
+		projected[i] = p
+	}
+	return projected, nil
+}
@@ -20,6 +20,9 @@ export class User {
 	static readonly PERMISSION_WRITE_SUBSCRIPTION: string = 'PERMISSION_WRITE_SUBSCRIPTION';
 	static readonly PERMISSION_READ_ACTION: string = 'PERMISSION_READ_ACTION';
 	static readonly PERMISSION_WRITE_ACTION: string = 'PERMISSION_WRITE_ACTION';
+	static readonly PERMISSION_READ_TRASH: string = 'PERMISSION_READ_TRASH';
+	static readonly PERMISSION_WRITE_TRASH: string = 'PERMISSION_WRITE_TRASH';
+	static readonly PERMISSION_REVIEW_CONTENT: string = 'PERMISSION_REVIEW_CONTENT';
 
 	username: string;
 
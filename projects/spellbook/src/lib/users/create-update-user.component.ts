@@ -40,6 +40,7 @@ export class CreateUpdateUserComponent implements OnInit {
 		const content: PermissionData = new PermissionData('Content');
 		content.addChildren(new PermissionData('Read', User.PERMISSION_READ_CONTENT));
 		content.addChildren(new PermissionData('Write', User.PERMISSION_WRITE_CONTENT));
+		content.addChildren(new PermissionData('Review', User.PERMISSION_REVIEW_CONTENT));
 		this.permissions.push(content);
 
 		const newsletter: PermissionData = new PermissionData('Newsletter');
@@ -71,6 +72,11 @@ export class CreateUpdateUserComponent implements OnInit {
 		action.addChildren(new PermissionData('Read', User.PERMISSION_READ_ACTION));
 		action.addChildren(new PermissionData('Write', User.PERMISSION_WRITE_ACTION));
 		this.permissions.push(action);
+
+		const trash: PermissionData = new PermissionData('Trash');
+		trash.addChildren(new PermissionData('Read', User.PERMISSION_READ_TRASH));
+		trash.addChildren(new PermissionData('Write', User.PERMISSION_WRITE_TRASH));
+		this.permissions.push(trash);
 	}
 
 	/** check if an id has been provided.
@@ -0,0 +1,473 @@
+package spellbook
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"decodica.com/flamel/model"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by the repositories that don't have their own not found error
+var ErrNotFound = errors.New("resource not found")
+
+// Query selects the resources of a repository.
+// Filters are validated against the fields the repository can be filtered by.
+// If Start is set, only the resources whose order field is greater or equal
+// (less or equal when descending) than Start are returned.
+// A zero Limit returns all the matching resources
+type Query struct {
+	Filters    []Filter
+	Order      string
+	Descending bool
+	Start      interface{}
+	Offset     int
+	Limit      int
+	// fields of the JSON representation the caller is interested in, repositories may load only those
+	Fields []string
+	// if the resources in the trash are returned too, see TrashRepository
+	Trashed bool
+}
+
+// QueryFromOptions returns the query of the filters, the order and the fields of the list options.
+// Paging is left to the caller, see PageQuery
+func QueryFromOptions(opts ListOptions) Query {
+	return Query{
+		Filters:    opts.Filters,
+		Order:      opts.Order,
+		Descending: opts.Descending,
+		Fields:     opts.Fields,
+	}
+}
+
+// Repository stores the resources of a type in a backend.
+// Repositories don't check permissions nor validate the resources:
+// managers implement those rules once and run against any repository
+type Repository interface {
+	FromId(ctx context.Context, id string) (Resource, error)
+	ListOf(ctx context.Context, query Query) ([]Resource, error)
+	Count(ctx context.Context, query Query) (int, error)
+	Create(ctx context.Context, resource Resource) error
+	Update(ctx context.Context, resource Resource) error
+	Delete(ctx context.Context, resource Resource) error
+}
+
+// TrashRepository is implemented by the repositories that keep the deleted resources, with their id, until they are purged.
+// Trash marks the resource as deleted: FromId doesn't find it and queries skip it, unless they ask for the Trashed resources.
+// Trashed returns a resource marked as deleted and Restore clears the mark, while Delete removes the resource for good
+type TrashRepository interface {
+	Repository
+	Trash(ctx context.Context, resource Resource) error
+	Trashed(ctx context.Context, id string) (Resource, error)
+	Restore(ctx context.Context, resource Resource) error
+}
+
+// DistinctRepository is implemented by repositories that can list the distinct values of a field.
+// The order of the query is ignored
+type DistinctRepository interface {
+	Distinct(ctx context.Context, field string, query Query) ([]string, error)
+}
+
+// BatchRepository is implemented by repositories that can read many resources at once, see FromIds
+type BatchRepository interface {
+	FromIds(ctx context.Context, ids []string) ([]Resource, error)
+}
+
+// FromIds returns the resources of the ids in their order, nil for the ones that don't exist or aren't valid ids.
+// Repositories implementing BatchRepository read them at once, the resources of the others are read concurrently
+func FromIds(ctx context.Context, repository Repository, ids []string) ([]Resource, error) {
+	if batch, ok := repository.(BatchRepository); ok {
+		return batch.FromIds(ctx, ids)
+	}
+
+	resources := make([]Resource, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := repository.FromId(ctx, ids[i])
+			if _, invalid := err.(FieldError); IsNotFound(err) || invalid {
+				return
+			}
+			resources[i], errs[i] = res, err
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resources, nil
+}
+
+// IsNotFound reports if the error returned by a repository means that the resource doesn't exist
+func IsNotFound(err error) bool {
+	return err == ErrNotFound || err == datastore.ErrNoSuchEntity || err == gorm.ErrRecordNotFound
+}
+
+// DatastoreQuery applies the query to a datastore query of the repository type.
+// Queries filtered by inequality, see FilterQuery, must be ordered by the filtered field, if ordered:
+// the start of the ordered queries is an inequality on the order field too
+func DatastoreQuery(q *model.Query, query Query, fields FilterFields) (*model.Query, error) {
+	if inequalities := inequalityFields(query.Filters); len(inequalities) == 1 && query.Order != "" && inequalities[0] != query.Order {
+		return nil, NewFieldError("order", fmt.Errorf("results filtered by inequality on %s must be ordered by %s", inequalities[0], inequalities[0]))
+	}
+
+	if query.Order != "" {
+		dir := model.ASC
+		op := " >="
+		if query.Descending {
+			dir = model.DESC
+			op = " <="
+		}
+		q = q.OrderBy(query.Order, dir)
+
+		if query.Start != nil {
+			q = q.WithField(query.Order+op, query.Start)
+		}
+	}
+
+	q, err := FilterQuery(q, fields, query.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Offset > 0 {
+		q = q.OffsetBy(query.Offset)
+	}
+
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	return q, nil
+}
+
+// most datastore queries run for a single query, as the IN filters of the datastore
+const maxDatastoreQueries = 30
+
+// splits the query into the datastore queries of each combination of the values of its in filters.
+// A != filter is split into a < and a > filter, as the datastore itself does
+func splitQuery(query Query) ([]Query, error) {
+	queries := []Query{query}
+	for i, filter := range query.Filters {
+		var alternatives []Filter
+		switch filter.Operator {
+		case FilterIn:
+			for _, v := range filter.Values() {
+				alternatives = append(alternatives, Filter{Field: filter.Field, Operator: FilterEqual, Value: v})
+			}
+		case FilterNotEqual:
+			alternatives = []Filter{{Field: filter.Field, Operator: FilterLess, Value: filter.Value}, {Field: filter.Field, Operator: FilterGreater, Value: filter.Value}}
+		default:
+			continue
+		}
+
+		split := make([]Query, 0, len(queries)*len(alternatives))
+		for _, q := range queries {
+			for _, alternative := range alternatives {
+				filters := append([]Filter{}, q.Filters...)
+				filters[i] = alternative
+				q.Filters = filters
+				split = append(split, q)
+			}
+		}
+		if len(split) > maxDatastoreQueries {
+			return nil, NewFieldError("filter", fmt.Errorf("the filters can't match more than %d combinations of values", maxDatastoreQueries))
+		}
+		queries = split
+	}
+	return queries, nil
+}
+
+// reports if the filters need more than one datastore query, see DatastoreMerged
+func splitFilters(filters []Filter) bool {
+	for _, filter := range filters {
+		if filter.Operator == FilterIn || filter.Operator == FilterNotEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// DatastoreMerged lists the resources of the query, supporting the in and != filters that a datastore query can't apply.
+// Queries with those filters are split into one query for each combination of their values, see FilterQuery,
+// whose results are merged, deduplicated by id, ordered by the order of the query and then paged by its offset and limit.
+// Every split query fetches the resources up to the end of the requested page
+func DatastoreMerged(query Query, list func(query Query) ([]Resource, error)) ([]Resource, error) {
+	if !splitFilters(query.Filters) {
+		return list(query)
+	}
+
+	queries, err := splitQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []Resource
+	seen := map[string]bool{}
+	for _, q := range queries {
+		q.Offset = 0
+		if query.Limit > 0 {
+			q.Limit = query.Offset + query.Limit
+		}
+		resources, err := list(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range resources {
+			if !seen[res.Id()] {
+				seen[res.Id()] = true
+				merged = append(merged, res)
+			}
+		}
+	}
+
+	if query.Order != "" {
+		sort.SliceStable(merged, func(i, j int) bool {
+			c := compareOrder(merged[i], merged[j], query.Order)
+			if query.Descending {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	if query.Offset >= len(merged) {
+		return nil, nil
+	}
+	merged = merged[query.Offset:]
+	if query.Limit > 0 && len(merged) > query.Limit {
+		merged = merged[:query.Limit]
+	}
+	return merged, nil
+}
+
+// compares the order fields of the resources, returning -1, 0 or 1
+func compareOrder(a Resource, b Resource, order string) int {
+	va, vb := reflect.Indirect(reflect.ValueOf(a)), reflect.Indirect(reflect.ValueOf(b))
+	f, ok := orderField(va.Type(), order)
+	if !ok {
+		return 0
+	}
+	x, y := va.FieldByIndex(f.Index), vb.FieldByIndex(f.Index)
+
+	switch x.Kind() {
+	case reflect.String:
+		return strings.Compare(x.String(), y.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareNumbers(float64(x.Int()), float64(y.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareNumbers(float64(x.Uint()), float64(y.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return compareNumbers(x.Float(), y.Float())
+	case reflect.Bool:
+		return compareNumbers(float64(boolInt(x.Bool())), float64(boolInt(y.Bool())))
+	}
+	if t, ok := x.Interface().(time.Time); ok {
+		u := y.Interface().(time.Time)
+		switch {
+		case t.Before(u):
+			return -1
+		case t.After(u):
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareNumbers(x float64, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// DatastoreCount counts the resources of the query built by build.
+// Queries split by DatastoreMerged are counted by listing them with list, which must merge them
+func DatastoreCount(ctx context.Context, query Query, build func(query Query) (*model.Query, error), list func(query Query) ([]Resource, error)) (int, error) {
+	if splitFilters(query.Filters) {
+		resources, err := list(query)
+		return len(resources), err
+	}
+
+	q, err := build(query)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(DatastoreQueryContext(ctx))
+}
+
+// DatastoreUntrashed lists the resources of the query, skipping the ones in the trash unless the query asks for them.
+// The trash mark, a non zero Deleted time, is checked on the listed entities instead of being filtered by the query:
+// the entities stored before their type had the mark have no Deleted property, which no datastore filter matches.
+// Offsets count the resources out of the trash only, so the ones before the requested page are listed too
+func DatastoreUntrashed(query Query, list func(query Query) ([]Resource, error), trashed func(res Resource) bool) ([]Resource, error) {
+	if query.Trashed {
+		return list(query)
+	}
+
+	batch := query
+	batch.Offset = 0
+	if query.Limit > 0 {
+		batch.Limit = query.Offset + query.Limit
+	}
+
+	var resources []Resource
+	skip := query.Offset
+	for {
+		listed, err := list(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, res := range listed {
+			if trashed(res) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			resources = append(resources, res)
+			if query.Limit > 0 && len(resources) == query.Limit {
+				return resources, nil
+			}
+		}
+
+		if batch.Limit == 0 || len(listed) < batch.Limit {
+			return resources, nil
+		}
+		batch.Offset += len(listed)
+	}
+}
+
+// DatastoreCountUntrashed counts the resources of the query out of the trash, see DatastoreUntrashed.
+// The trashed ones are counted by an inequality on their Deleted time and subtracted,
+// unless the query has an inequality of its own, the only one the datastore allows, or is split by DatastoreMerged:
+// then the resources are listed and counted by list, which must merge the split queries
+func DatastoreCountUntrashed(ctx context.Context, query Query, build func(query Query) (*model.Query, error), list func(query Query) ([]Resource, error), trashed func(res Resource) bool) (int, error) {
+	if splitFilters(query.Filters) || !query.Trashed && len(inequalityFields(query.Filters)) > 0 {
+		resources, err := DatastoreUntrashed(query, list, trashed)
+		return len(resources), err
+	}
+
+	q, err := build(query)
+	if err != nil {
+		return 0, err
+	}
+	count, err := q.Count(DatastoreQueryContext(ctx))
+	if err != nil || query.Trashed {
+		return count, err
+	}
+
+	q, err = build(query)
+	if err != nil {
+		return 0, err
+	}
+	inTrash, err := q.WithField("Deleted >", time.Time{}).Count(DatastoreQueryContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return count - inTrash, nil
+}
+
+// number of resources saved at once by Resave
+const resaveBatchSize = 100
+
+// Resave updates every resource of the repository, the trashed ones included, returning how many have been saved.
+// It stores the properties added to a type in the entities written before them, e.g. the search terms of the contents.
+// If prepare is not nil, it is applied to each resource before it is saved
+func Resave(ctx context.Context, repository Repository, prepare func(Resource)) (int, error) {
+	query := Query{Trashed: true, Limit: resaveBatchSize}
+	saved := 0
+	for {
+		resources, err := repository.ListOf(ctx, query)
+		if err != nil {
+			return saved, err
+		}
+
+		for _, resource := range resources {
+			if prepare != nil {
+				prepare(resource)
+			}
+			if err := repository.Update(ctx, resource); err != nil {
+				return saved, err
+			}
+			saved++
+		}
+
+		if len(resources) < resaveBatchSize {
+			return saved, nil
+		}
+		query.Offset += resaveBatchSize
+	}
+}
+
+// Resaver is implemented by the managers that can save again all their resources, see Resave
+type Resaver interface {
+	Resave(ctx context.Context) (int, error)
+}
+
+type transactionKey string
+
+const keyDatastoreTransaction transactionKey = "__spellbook_datastore_transaction__"
+
+// RunInDatastoreTransaction runs fn in a datastore transaction.
+// If the context already carries a transaction fn joins it, so that operations can be composed
+func RunInDatastoreTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(keyDatastoreTransaction) != nil {
+		return fn(ctx)
+	}
+
+	outer := ctx
+	return model.RunInTransaction(ctx, func(ctx context.Context) error {
+		return fn(context.WithValue(ctx, keyDatastoreTransaction, outer))
+	})
+}
+
+// DatastoreQueryContext returns the context datastore queries run in.
+// Queries can't join a datastore transaction: in one they run outside of it,
+// on the context the transaction was started from, and don't see its writes
+func DatastoreQueryContext(ctx context.Context) context.Context {
+	if outer, ok := ctx.Value(keyDatastoreTransaction).(context.Context); ok {
+		return outer
+	}
+	return ctx
+}
+
+// RunInTransaction runs fn in the transactions of the repositories implementing Transactional,
+// so that the operations of fn on them are atomic.
+// Repositories of the same backend join a single transaction
+func RunInTransaction(ctx context.Context, fn func(ctx context.Context) error, repositories ...Repository) error {
+	if len(repositories) == 0 {
+		return fn(ctx)
+	}
+
+	t, ok := repositories[0].(Transactional)
+	if !ok {
+		return RunInTransaction(ctx, fn, repositories[1:]...)
+	}
+	return t.RunInTransaction(ctx, func(ctx context.Context) error {
+		return RunInTransaction(ctx, fn, repositories[1:]...)
+	})
+}
@@ -0,0 +1,96 @@
+package spellbook
+
+import (
+	"context"
+	"decodica.com/flamel/model"
+	"strings"
+	"testing"
+)
+
+// resource of the trash tests, trashed if its id starts with "x"
+type stored string
+
+func (s stored) Id() string {
+	return string(s)
+}
+
+func (s stored) ToRepresentation(rtype RepresentationType) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func (s stored) FromRepresentation(rtype RepresentationType, data []byte) error {
+	return nil
+}
+
+func inTrash(res Resource) bool {
+	return strings.HasPrefix(res.Id(), "x")
+}
+
+// returns a datastore stand-in listing the resources of the ids by offset and limit, and the queries it ran
+func storedList(ids ...string) (func(query Query) ([]Resource, error), *[]Query) {
+	var queries []Query
+	return func(query Query) ([]Resource, error) {
+		queries = append(queries, query)
+		var resources []Resource
+		for i := query.Offset; i < len(ids) && (query.Limit == 0 || len(resources) < query.Limit); i++ {
+			resources = append(resources, stored(ids[i]))
+		}
+		return resources, nil
+	}, &queries
+}
+
+func TestDatastoreUntrashed(t *testing.T) {
+	ids := []string{"a", "x1", "b", "x2", "x3", "c", "d"}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"all", Query{}, "abcd"},
+		{"first page", Query{Limit: 2}, "ab"},
+		{"second page", Query{Offset: 2, Limit: 2}, "cd"},
+		{"past the end", Query{Offset: 4, Limit: 2}, ""},
+		{"trashed", Query{Trashed: true, Offset: 1, Limit: 2}, "x1b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, _ := storedList(ids...)
+			resources, err := DatastoreUntrashed(test.query, list, inTrash)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			got := ""
+			for _, res := range resources {
+				got += res.Id()
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// a page without trashed entities is read by a single query
+	list, queries := storedList("a", "b", "c")
+	if _, err := DatastoreUntrashed(Query{Offset: 1, Limit: 1}, list, inTrash); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(*queries) != 1 {
+		t.Errorf("ran %d queries, want 1", len(*queries))
+	}
+}
+
+func TestDatastoreCountUntrashedInequality(t *testing.T) {
+	list, _ := storedList("a", "x1", "b")
+	build := func(query Query) (*model.Query, error) {
+		t.Fatal("the trashed entities of a query with an inequality can't be counted by another one")
+		return nil, nil
+	}
+
+	query := Query{Filters: []Filter{{Field: "Order", Operator: FilterGreater, Value: "0"}}}
+	count, err := DatastoreCountUntrashed(context.Background(), query, build, list, inTrash)
+	if err != nil || count != 2 {
+		t.Errorf("got count %d and error %v, want 2", count, err)
+	}
+}
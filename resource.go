@@ -15,17 +15,37 @@ type ListOptions struct {
 	Order      string // field
 	Descending bool   // if -Order = desc
 	Property   string
-	Filters    []Filter // example url: &filter=Locale=it^Category=services
+	Filters    []Filter // example url: &filter=Locale=it^Category:in:news,events, see FilterOperator
+	Cursor     string   // opaque cursor returned as next by a previous list request
+	Count      bool     // if the total number of results is requested
+	Fields     []string // JSON fields to return, example url: &fields=id,title,slug
+	Search     string   // full-text search, example url: &q=summer+sale, see ResourceDescription.Searchable
 }
 
 type Filter struct {
-	Field string
-	Value string
+	Field    string         `json:"field"`
+	Operator FilterOperator `json:"operator"`
+	Value    string         `json:"value"`
 }
 
 type ListResponse struct {
 	Items interface{} `json:"items"`
 	More  bool        `json:"more"`
+	Next  string      `json:"next,omitempty"`
+	// paging metadata echoed from the request
+	Page    int      `json:"page"`
+	Size    int      `json:"size"`
+	Order   string   `json:"order,omitempty"`
+	Filters []Filter `json:"filters,omitempty"`
+	Search  string   `json:"q,omitempty"`
+	// only set when the total is requested with count=true
+	Total *int `json:"total,omitempty"`
+	Pages *int `json:"pages,omitempty"`
+}
+
+// Counter is implemented by managers that can count the resources matching the list options
+type Counter interface {
+	Count(ctx context.Context, opts ListOptions) (int, error)
 }
 
 type Manager interface {
@@ -77,8 +97,10 @@ func (r Resources) FromCSV([]string) error {
 type RestController struct {
 	Key     string
 	Private bool
+	// if set, POST requests are handled as batches of operations by a BatchHandler
+	Batch bool
 	RestHandler
-	extenders map[string][]Extender
+	extenders Extenders
 }
 
 func NewBaseRestController() *RestController {
@@ -89,13 +111,10 @@ func NewRestController(handler RestHandler) *RestController {
 	return &RestController{RestHandler: handler}
 }
 
-func (controller *RestController) AddExtender(hook string, extender Extender) {
-	e, ok := controller.extenders[hook]
-	if !ok {
-		e = make([]Extender, 0)
-	}
-	e = append(e, extender)
-	controller.extenders[hook] = e
+// Adds an extender to the controller.
+// Extenders are called in the order they have been added
+func (controller *RestController) AddExtender(extender Extender) {
+	controller.extenders = append(controller.extenders, extender)
 }
 
 func (controller *RestController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
@@ -106,12 +125,25 @@ func (controller *RestController) Process(ctx context.Context, out *flamel.Respo
 		return flamel.HttpResponse{Status: http.StatusUnauthorized}
 	}
 
-	ins := flamel.InputsFromContext(ctx)
+	ctx = ContextWithExtenders(ctx, controller.extenders)
+
+	ins := InputsFromContext(ctx)
 
 	method := ins[flamel.KeyRequestMethod].Value()
 	hasKey := controller.Key != ""
 	prop, hasProperty := ins["property"]
 
+	if controller.Batch {
+		bh, ok := controller.RestHandler.(BatchHandler)
+		if !ok {
+			return flamel.HttpResponse{Status: http.StatusNotImplemented}
+		}
+		if method != http.MethodPost {
+			return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
+		}
+		return bh.HandleBatch(ctx, out)
+	}
+
 	switch method {
 	case http.MethodPost:
 		return controller.HandlePost(ctx, out)
@@ -129,6 +161,16 @@ func (controller *RestController) Process(ctx context.Context, out *flamel.Respo
 			return flamel.HttpResponse{Status: http.StatusBadRequest}
 		}
 		return controller.HandlePut(ctx, controller.Key, out)
+	case http.MethodPatch:
+		if !hasKey {
+			log.Errorf(ctx, "no item was specify for patch method")
+			return flamel.HttpResponse{Status: http.StatusBadRequest}
+		}
+		ph, ok := controller.RestHandler.(PatchHandler)
+		if !ok {
+			return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
+		}
+		return ph.HandlePatch(ctx, controller.Key, out)
 	case http.MethodDelete:
 		if !hasKey {
 			log.Errorf(ctx, "no item was specify for delete method")
@@ -141,3 +183,8 @@ func (controller *RestController) Process(ctx context.Context, out *flamel.Respo
 }
 
 func (controller *RestController) OnDestroy(ctx context.Context) {}
+
+// returns the RestController, also when it is embedded by another controller
+func (controller *RestController) rest() *RestController {
+	return controller
+}
@@ -1,13 +1,12 @@
 package spellbook
 
 import (
-	"cloud.google.com/go/datastore"
 	"context"
 	"decodica.com/flamel"
 	"errors"
 	"fmt"
-	"github.com/jinzhu/gorm"
 	"google.golang.org/appengine/log"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
@@ -23,6 +22,12 @@ type WriteHandler interface {
 	HandleDelete(context context.Context, key string, out *flamel.ResponseOutput) flamel.HttpResponse
 }
 
+// PatchHandler is implemented by the RestHandlers that apply partial updates.
+// PATCH requests to handlers that don't implement it are not allowed
+type PatchHandler interface {
+	HandlePatch(context context.Context, key string, out *flamel.ResponseOutput) flamel.HttpResponse
+}
+
 type ListHandler interface {
 	HandleList(context context.Context, out *flamel.ResponseOutput) flamel.HttpResponse
 	HandlePropertyValues(context context.Context, out *flamel.ResponseOutput, property string) flamel.HttpResponse
@@ -38,21 +43,26 @@ type BaseRestHandler struct {
 	Manager Manager
 }
 
+// Returns the manager of the handler, used to describe the resource
+func (handler BaseRestHandler) manager() Manager {
+	return handler.Manager
+}
+
 // Builds the paging options, ordering and standard inputs of a given request
 func (handler BaseRestHandler) buildOptions(ctx context.Context, out *flamel.ResponseOutput, opts *ListOptions) (*ListOptions, error) {
 	// build paging
 	opts.Size = 20
 	opts.Page = 0
 
-	ins := flamel.InputsFromContext(ctx)
+	ins := InputsFromContext(ctx)
 	if pin, ok := ins["page"]; ok {
 		if num, err := strconv.Atoi(pin.Value()); err == nil {
 			if num > 0 {
 				opts.Page = num
 			}
 		} else {
-			msg := fmt.Sprintf("invalid page value : %v. page must be an integer", pin)
-			return nil, errors.New(msg)
+			msg := fmt.Sprintf("invalid page value : %v. page must be an integer", pin.Value())
+			return nil, NewFieldError("page", errors.New(msg))
 		}
 	}
 
@@ -62,8 +72,8 @@ func (handler BaseRestHandler) buildOptions(ctx context.Context, out *flamel.Res
 				opts.Size = num
 			}
 		} else {
-			msg := fmt.Sprintf("invalid result size value : %v. results must be an integer", sin)
-			return nil, errors.New(msg)
+			msg := fmt.Sprintf("invalid result size value : %v. results must be an integer", sin.Value())
+			return nil, NewFieldError("results", errors.New(msg))
 		}
 	}
 
@@ -79,16 +89,45 @@ func (handler BaseRestHandler) buildOptions(ctx context.Context, out *flamel.Res
 		}
 	}
 
+	// count is not mandatory
+	if cin, ok := ins["count"]; ok {
+		count, err := strconv.ParseBool(cin.Value())
+		if err != nil {
+			msg := fmt.Sprintf("invalid count value : %v. count must be a boolean", cin.Value())
+			return nil, NewFieldError("count", errors.New(msg))
+		}
+		opts.Count = count
+	}
+
+	// fields are not mandatory, all the fields are returned by default
+	if fin, ok := ins[KeyFields]; ok {
+		opts.Fields = parseFields(fin.Value())
+	}
+
+	// the full-text search is not mandatory
+	if qin, ok := ins["q"]; ok {
+		opts.Search = strings.TrimSpace(qin.Value())
+	}
+
+	// cursor is not mandatory, when set it takes precedence over the page
+	if cin, ok := ins["cursor"]; ok {
+		opts.Cursor = cin.Value()
+	}
+
 	// filter is not mandatory
 	if fin, ok := ins["filter"]; ok {
 		finv := fin.Value()
 		filters := strings.Split(finv, "^")
-		opts.Filters = make([]Filter, len(filters), cap(filters))
-		for i, filter := range filters {
-			farray := strings.Split(filter, "=")
-			if len(farray) > 1 {
-				opts.Filters[i] = Filter{farray[0], farray[1]}
+		opts.Filters = make([]Filter, 0, len(filters))
+		for _, filter := range filters {
+			if filter == "" {
+				continue
+			}
+			f, err := ParseFilter(filter)
+			if err != nil {
+				return nil, err
 			}
+			opts.Filters = append(opts.Filters, f)
 		}
 
 	}
@@ -101,12 +140,44 @@ func (handler BaseRestHandler) HandleGet(ctx context.Context, key string, out *f
 	renderer := flamel.JSONRenderer{}
 	out.Renderer = &renderer
 
+	extenders := ExtendersFromContext(ctx)
+	if err := extenders.BeforeGet(ctx, key); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
 	resource, err := handler.Manager.FromId(ctx, key)
 	if err != nil {
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
+	if err := extenders.AfterGet(ctx, resource); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	ins := InputsFromContext(ctx)
+
+	etag, err := handler.etag(resource)
+	if err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	if etag != "" {
+		AddHeader(ctx, out, HeaderETag, etag)
+		if inm, ok := ins[HeaderIfNoneMatch]; ok && etagMatches(inm.Value(), etag) {
+			return flamel.HttpResponse{Status: http.StatusNotModified}
+		}
+	}
+
 	renderer.Data = resource
+	if fin, ok := ins[KeyFields]; ok {
+		if fields := parseFields(fin.Value()); len(fields) > 0 {
+			projected, err := Project(resource, fields)
+			if err != nil {
+				return handler.ErrorToStatus(ctx, err, out)
+			}
+			renderer.Data = projected
+		}
+	}
 	return flamel.HttpResponse{Status: http.StatusOK}
 }
 
@@ -118,7 +189,7 @@ func (handler BaseRestHandler) HandlePropertyValues(ctx context.Context, out *fl
 	opts.Property = prop
 	opts, err := handler.buildOptions(ctx, out, opts)
 	if err != nil {
-		return flamel.HttpResponse{Status: http.StatusBadRequest}
+		return handler.ErrorToStatus(ctx, err, out)
 	}
 
 	results, err := handler.Manager.ListOfProperties(ctx, *opts)
@@ -134,7 +205,7 @@ func (handler BaseRestHandler) HandlePropertyValues(ctx context.Context, out *fl
 	}
 
 	renderer := flamel.JSONRenderer{}
-	renderer.Data = ListResponse{results[:count], l > opts.Size}
+	renderer.Data = ListResponse{Items: results[:count], More: l > opts.Size}
 
 	out.Renderer = &renderer
 
@@ -148,14 +219,31 @@ func (handler BaseRestHandler) HandleList(ctx context.Context, out *flamel.Respo
 	opts := &ListOptions{}
 	opts, err := handler.buildOptions(ctx, out, opts)
 	if err != nil {
-		return flamel.HttpResponse{Status: http.StatusBadRequest}
+		return handler.ErrorToStatus(ctx, err, out)
 	}
 
-	results, err := handler.Manager.ListOf(ctx, *opts)
+	extenders := ExtendersFromContext(ctx)
+	if err := extenders.BeforeList(ctx, opts); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	var results []Resource
+	var next string
+	if cm, ok := handler.Manager.(CursorManager); ok {
+		results, next, err = cm.ListOfWithCursor(ctx, *opts)
+	} else if opts.Cursor != "" {
+		err = NewFieldError("cursor", errors.New("cursor pagination is not supported by the resource"))
+	} else {
+		results, err = handler.Manager.ListOf(ctx, *opts)
+	}
 	if err != nil {
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
+	if err := extenders.AfterList(ctx, *opts, results); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
 	// output
 	l := len(results)
 	count := opts.Size
@@ -166,7 +254,7 @@ func (handler BaseRestHandler) HandleList(ctx context.Context, out *flamel.Respo
 	var renderer flamel.Renderer
 
 	// retrieve the negotiated method
-	ins := flamel.InputsFromContext(ctx)
+	ins := InputsFromContext(ctx)
 	accept := ins[flamel.KeyNegotiatedContent].Value()
 
 	if accept == "text/csv" {
@@ -178,8 +266,42 @@ func (handler BaseRestHandler) HandleList(ctx context.Context, out *flamel.Respo
 		r.Data = []byte(csv)
 		renderer = r
 	} else {
+		response := ListResponse{Items: results[:count], More: l > opts.Size || next != "", Next: next}
+		if len(opts.Fields) > 0 {
+			items, err := projectAll(results[:count], opts.Fields)
+			if err != nil {
+				return handler.ErrorToStatus(ctx, err, out)
+			}
+			response.Items = items
+		}
+		response.Page = opts.Page
+		response.Size = opts.Size
+		response.Order = opts.Order
+		if opts.Descending {
+			response.Order = "-" + opts.Order
+		}
+		response.Filters = opts.Filters
+		response.Search = opts.Search
+
+		if opts.Count {
+			counter, ok := handler.Manager.(Counter)
+			if !ok {
+				return handler.ErrorToStatus(ctx, NewFieldError("count", errors.New("the resource can't be counted")), out)
+			}
+			total, err := counter.Count(ctx, *opts)
+			if err != nil {
+				return handler.ErrorToStatus(ctx, err, out)
+			}
+			pages := total / opts.Size
+			if total%opts.Size > 0 {
+				pages++
+			}
+			response.Total = &total
+			response.Pages = &pages
+		}
+
 		jrenderer := flamel.JSONRenderer{}
-		jrenderer.Data = ListResponse{results[:count], l > opts.Size}
+		jrenderer.Data = response
 		renderer = &jrenderer
 	}
 
@@ -198,24 +320,19 @@ func (handler BaseRestHandler) HandlePost(ctx context.Context, out *flamel.Respo
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
-	errs := Errors{}
 	// get the content data
-	ins := flamel.InputsFromContext(ctx)
+	ins := InputsFromContext(ctx)
 	j, ok := ins[flamel.KeyRequestJSON]
 	if !ok {
-		return flamel.HttpResponse{Status: http.StatusBadRequest}
+		return handler.ErrorToStatus(ctx, NewFieldError("", errMissingBody), out)
 	}
 
 	err = resource.FromRepresentation(RepresentationTypeJSON, []byte(j.Value()))
 	if err != nil {
-		msg := fmt.Sprintf("bad json: %s", err.Error())
-		errs.AddError("", errors.New(msg))
-		log.Errorf(ctx, msg)
-		renderer.Data = errs
-		return flamel.HttpResponse{Status: http.StatusBadRequest}
+		return handler.ErrorToStatus(ctx, NewFieldError("", fmt.Errorf("bad json: %s", err.Error())), out)
 	}
 
-	if err = handler.Manager.Create(ctx, resource, []byte(j.Value())); err != nil {
+	if err := handler.create(ctx, resource, []byte(j.Value())); err != nil {
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
@@ -228,10 +345,74 @@ func (handler BaseRestHandler) HandlePut(ctx context.Context, key string, out *f
 	renderer := flamel.JSONRenderer{}
 	out.Renderer = &renderer
 
-	ins := flamel.InputsFromContext(ctx)
+	ins := InputsFromContext(ctx)
 	j, ok := ins[flamel.KeyRequestJSON]
 	if !ok {
-		return flamel.HttpResponse{Status: http.StatusBadRequest}
+		return handler.ErrorToStatus(ctx, NewFieldError("", errMissingBody), out)
+	}
+
+	resource, err := handler.Manager.FromId(ctx, key)
+	if err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	if ok, err := handler.ifMatch(ctx, resource); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	} else if !ok {
+		return flamel.HttpResponse{Status: http.StatusPreconditionFailed}
+	}
+
+	bundle := []byte(j.Value())
+	if err := handler.update(ctx, resource, bundle, handler.precondition(ctx, key)); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	if etag, err := handler.etag(resource); err == nil && etag != "" {
+		AddHeader(ctx, out, HeaderETag, etag)
+	}
+
+	renderer.Data = resource
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+// Handles PATCH requests, applying a partial update to the requested resource.
+// The patch is applied to the current JSON representation of the resource
+// and the resulting document is passed to the Manager as the update bundle.
+// The patch format is selected by the Content-Type of the request:
+// application/merge-patch+json (RFC 7396) or application/json-patch+json (RFC 6902),
+// whose bodies are read from the context, see BodyHandler
+func (handler BaseRestHandler) HandlePatch(ctx context.Context, key string, out *flamel.ResponseOutput) flamel.HttpResponse {
+	renderer := flamel.JSONRenderer{}
+	out.Renderer = &renderer
+
+	ins := InputsFromContext(ctx)
+	apply := MergePatch
+	raw := false
+	if ct, ok := ins["Content-Type"]; ok {
+		mt, _, err := mime.ParseMediaType(ct.Value())
+		if err != nil {
+			return flamel.HttpResponse{Status: http.StatusUnsupportedMediaType}
+		}
+		switch mt {
+		case "application/json":
+		case MediaTypeMergePatch:
+			raw = true
+		case MediaTypeJSONPatch:
+			apply = JSONPatch
+			raw = true
+		default:
+			return flamel.HttpResponse{Status: http.StatusUnsupportedMediaType}
+		}
+	}
+
+	var patch []byte
+	if body, ok := BodyFromContext(ctx); ok && raw {
+		patch = body
+	} else if j, ok := ins[flamel.KeyRequestJSON]; ok {
+		patch = []byte(j.Value())
+	}
+	if len(patch) == 0 {
+		return handler.ErrorToStatus(ctx, NewFieldError("", errMissingBody), out)
 	}
 
 	resource, err := handler.Manager.FromId(ctx, key)
@@ -239,8 +420,28 @@ func (handler BaseRestHandler) HandlePut(ctx context.Context, key string, out *f
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
-	if err = handler.Manager.Update(ctx, resource, []byte(j.Value())); err != nil {
+	if ok, err := handler.ifMatch(ctx, resource); err != nil {
 		return handler.ErrorToStatus(ctx, err, out)
+	} else if !ok {
+		return flamel.HttpResponse{Status: http.StatusPreconditionFailed}
+	}
+
+	current, err := resource.ToRepresentation(RepresentationTypeJSON)
+	if err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	bundle, err := apply(current, patch)
+	if err != nil {
+		return handler.ErrorToStatus(ctx, NewFieldError("", err), out)
+	}
+
+	if err := handler.update(ctx, resource, bundle, handler.precondition(ctx, key)); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	}
+
+	if etag, err := handler.etag(resource); err == nil && etag != "" {
+		AddHeader(ctx, out, HeaderETag, etag)
 	}
 
 	renderer.Data = resource
@@ -257,45 +458,162 @@ func (handler BaseRestHandler) HandleDelete(ctx context.Context, key string, out
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 
-	if err = handler.Manager.Delete(ctx, resource); err != nil {
+	if ok, err := handler.ifMatch(ctx, resource); err != nil {
+		return handler.ErrorToStatus(ctx, err, out)
+	} else if !ok {
+		return flamel.HttpResponse{Status: http.StatusPreconditionFailed}
+	}
+
+	if err := handler.delete(ctx, resource, handler.precondition(ctx, key)); err != nil {
 		return handler.ErrorToStatus(ctx, err, out)
 	}
 	return flamel.HttpResponse{Status: http.StatusOK}
 }
 
-// Converts an error to its equivalent HTTP representation
-func (handler BaseRestHandler) ErrorToStatus(ctx context.Context, err error, out *flamel.ResponseOutput) flamel.HttpResponse {
-	log.Errorf(ctx, "%s", err.Error())
-	switch err.(type) {
-	case UnsupportedError:
-		return flamel.HttpResponse{Status: http.StatusMethodNotAllowed}
-	case FieldError:
-		renderer := flamel.JSONRenderer{}
-		renderer.Data = struct {
-			Field string
-			Error string
-		}{
-			err.(FieldError).field,
-			err.(FieldError).error.Error(),
+// Creates the resource through the manager, calling the extenders hooks around it
+func (handler BaseRestHandler) create(ctx context.Context, resource Resource, bundle []byte) error {
+	extenders := ExtendersFromContext(ctx)
+	return handler.atomic(ctx, func(ctx context.Context) error {
+		if err := extenders.BeforeCreate(ctx, resource, bundle); err != nil {
+			return err
 		}
-		out.Renderer = &renderer
-		return flamel.HttpResponse{Status: http.StatusBadRequest}
-	case PermissionError:
-		renderer := flamel.JSONRenderer{}
-		renderer.Data = struct {
-			Error string
-		}{
-			err.(PermissionError).Error(),
+
+		if err := handler.Manager.Create(ctx, resource, bundle); err != nil {
+			return err
 		}
-		out.Renderer = &renderer
-		return flamel.HttpResponse{Status: http.StatusForbidden}
-	default:
-		if err == datastore.ErrNoSuchEntity {
-			return flamel.HttpResponse{Status: http.StatusNotFound}
+
+		return handler.after(ctx, extenders.AfterCreate(ctx, resource, bundle))
+	})
+}
+
+// Updates the resource through the manager, calling the extenders hooks around it.
+// The precondition, if any, is checked first in the same transaction
+func (handler BaseRestHandler) update(ctx context.Context, resource Resource, bundle []byte, precondition func(ctx context.Context) error) error {
+	extenders := ExtendersFromContext(ctx)
+	return handler.atomic(ctx, func(ctx context.Context) error {
+		if precondition != nil {
+			if err := precondition(ctx); err != nil {
+				return err
+			}
 		}
-		if err == gorm.ErrRecordNotFound {
-			return flamel.HttpResponse{Status: http.StatusNotFound}
+
+		if err := extenders.BeforeUpdate(ctx, resource, bundle); err != nil {
+			return err
 		}
-		return flamel.HttpResponse{Status: http.StatusInternalServerError}
+
+		if err := handler.Manager.Update(ctx, resource, bundle); err != nil {
+			return err
+		}
+
+		return handler.after(ctx, extenders.AfterUpdate(ctx, resource, bundle))
+	})
+}
+
+// Deletes the resource through the manager, calling the extenders hooks around it.
+// The precondition, if any, is checked first in the same transaction
+func (handler BaseRestHandler) delete(ctx context.Context, resource Resource, precondition func(ctx context.Context) error) error {
+	extenders := ExtendersFromContext(ctx)
+	return handler.atomic(ctx, func(ctx context.Context) error {
+		if precondition != nil {
+			if err := precondition(ctx); err != nil {
+				return err
+			}
+		}
+
+		if err := extenders.BeforeDelete(ctx, resource); err != nil {
+			return err
+		}
+
+		if err := handler.Manager.Delete(ctx, resource); err != nil {
+			return err
+		}
+
+		return handler.after(ctx, extenders.AfterDelete(ctx, resource))
+	})
+}
+
+// Runs fn in a transaction of the manager, if it is Transactional,
+// so that the extenders hooks and the action of the manager are committed together
+func (handler BaseRestHandler) atomic(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t, ok := handler.Manager.(Transactional); ok {
+		return t.RunInTransaction(ctx, fn)
+	}
+	return fn(ctx)
+}
+
+// Returns the error of an After hook if the action of the manager is rolled back with it.
+// Actions of managers that aren't Transactional are already stored: the error is logged and not returned,
+// so that the client isn't told that a stored action failed
+func (handler BaseRestHandler) after(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := handler.Manager.(Transactional); ok {
+		return err
 	}
+	log.Errorf(ctx, "after hook failed, the action was stored anyway: %s", err.Error())
+	return nil
+}
+
+// Returns the ETag of the resource, or an empty string if the manager opted out of versioning
+func (handler BaseRestHandler) etag(resource Resource) (string, error) {
+	if u, ok := handler.Manager.(Unversioned); ok && u.Unversioned() {
+		return "", nil
+	}
+	return ETag(resource)
+}
+
+// Reports if the If-Match precondition of the request, if any, holds for the given resource
+func (handler BaseRestHandler) ifMatch(ctx context.Context, resource Resource) (bool, error) {
+	ins := InputsFromContext(ctx)
+	im, ok := ins[HeaderIfMatch]
+	if !ok {
+		return true, nil
+	}
+
+	etag, err := handler.etag(resource)
+	if err != nil {
+		return false, err
+	}
+
+	if etag == "" {
+		return true, nil
+	}
+
+	return etagMatches(im.Value(), etag), nil
+}
+
+// errPreconditionFailed is returned when the resource changed after the If-Match precondition was checked
+var errPreconditionFailed = NewProblem(http.StatusPreconditionFailed, "the resource has been changed")
+
+// Returns the If-Match precondition of the request on the resource with the given key, if any.
+// The precondition reads the resource again, so that run in the transaction of the write
+// it fails if a concurrent writer holding the same ETag changed the resource first
+func (handler BaseRestHandler) precondition(ctx context.Context, key string) func(ctx context.Context) error {
+	if _, ok := InputsFromContext(ctx)[HeaderIfMatch]; !ok {
+		return nil
+	}
+
+	return func(ctx context.Context) error {
+		resource, err := handler.Manager.FromId(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		ok, err := handler.ifMatch(ctx, resource)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// Converts an error to its equivalent HTTP representation,
+// rendering it as an application/problem+json body
+func (handler BaseRestHandler) ErrorToStatus(ctx context.Context, err error, out *flamel.ResponseOutput) flamel.HttpResponse {
+	log.Errorf(ctx, "%s", err.Error())
+	return RenderProblem(ctx, ProblemFromError(err), out)
 }
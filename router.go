@@ -15,14 +15,31 @@ const KeyLanguageTag = "__p_languageTag__"
 type InternationalRouter struct {
 	*flamel.DefaultRouter
 	matcher language.Matcher
+	routes  *[]Route
+}
+
+// Route is a route registered with SetUniversalRoute
+type Route struct {
+	Path          string
+	Handler       func(ctx context.Context) flamel.Controller
+	Authenticator flamel.Authenticator
 }
 
 func NewInternationalRouter() InternationalRouter {
 	router := InternationalRouter{}
 	router.DefaultRouter = flamel.NewDefaultRouter()
+	router.routes = &[]Route{}
 	return router
 }
 
+// UniversalRoutes returns the routes registered with SetUniversalRoute, in registration order
+func (router InternationalRouter) UniversalRoutes() []Route {
+	if router.routes == nil {
+		return nil
+	}
+	return *router.routes
+}
+
 func (router InternationalRouter) SetRoutes(urls []string, handler func(ctx context.Context) flamel.Controller, authenticator flamel.Authenticator) {
 	for _, v := range urls {
 		router.SetRoute(v, handler, authenticator)
@@ -31,6 +48,9 @@ func (router InternationalRouter) SetRoutes(urls []string, handler func(ctx cont
 
 func (router InternationalRouter) SetUniversalRoute(url string, handler func(ctx context.Context) flamel.Controller, authenticator flamel.Authenticator) {
 	router.DefaultRouter.SetRoute(url, handler, authenticator)
+	if router.routes != nil {
+		*router.routes = append(*router.routes, Route{url, handler, authenticator})
+	}
 }
 
 func (router InternationalRouter) SetUniversalRoutes(urls []string, handler func(ctx context.Context) flamel.Controller, authenticator flamel.Authenticator) {
@@ -44,7 +64,7 @@ func (router InternationalRouter) SetRoute(url string, handler func(ctx context.
 	// if no language is specified, redirect to the default language
 	router.Router.SetRoute(url, func(ctx context.Context) (interface{}, context.Context) {
 		lang, _, _ := router.matcher.Match(language.Make(""))
-		parms := flamel.InputsFromContext(ctx)
+		parms := InputsFromContext(ctx)
 		url := parms[flamel.KeyRequestURL].Value()
 		url = fmt.Sprintf("/%s%s", lang.String(), url)
 		switch parms[flamel.KeyRequestMethod].Value() {
@@ -76,7 +96,7 @@ func (router InternationalRouter) SetRoute(url string, handler func(ctx context.
 			if t := tag.String(); lkey != t {
 				url := fmt.Sprintf("/%s%s", t, url)
 				// if its not a get request, return a 307
-				parms := flamel.InputsFromContext(ctx)
+				parms := InputsFromContext(ctx)
 				switch parms[flamel.KeyRequestMethod].Value() {
 				case http.MethodGet:
 					fallthrough
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"decodica.com/flamel"
+	"decodica.com/spellbook"
 	"google.golang.org/appengine/log"
 	"net/http"
 )
@@ -13,7 +14,7 @@ func (controller *CleanController) OnDestroy(ctx context.Context) {}
 
 func (controller *CleanController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
 	log.Infof(ctx, "CleanController")
-	ins := flamel.InputsFromContext(ctx)
+	ins := spellbook.InputsFromContext(ctx)
 	method := ins[flamel.KeyRequestMethod]
 	_, ok := ins["entity"]
 	if !ok {
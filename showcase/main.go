@@ -11,8 +11,10 @@ import (
 	"decodica.com/spellbook/mailmessage"
 	"decodica.com/spellbook/navigation"
 	"decodica.com/spellbook/subscription"
+	"decodica.com/spellbook/trash"
 	"golang.org/x/text/language"
 	"net/http"
+	"time"
 )
 
 const (
@@ -29,7 +31,7 @@ type HelloWorldController struct{}
 
 func (controller *HelloWorldController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
 
-	ins := flamel.InputsFromContext(ctx)
+	ins := spellbook.InputsFromContext(ctx)
 	method := ins[flamel.KeyRequestMethod].Value()
 	switch method {
 	case http.MethodGet:
@@ -72,6 +74,9 @@ func main() {
 		{Type: spellbook.ActionTypeUpload, Name: "places", Endpoint: "/api/places", Method: http.MethodGet},
 	}
 
+	// the deleted resources are purged after a month
+	opts.TrashRetention = 30 * 24 * time.Hour
+
 	instance := spellbook.NewWebsite(&opts)
 
 	instance.Router.SetUniversalRoute("/hello", func(ctx context.Context) flamel.Controller {
@@ -104,7 +109,7 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/users/:username", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["username"].Value()
 		c := identity.NewUserControllerWithKey(key)
 		c.Private = true
@@ -118,7 +123,7 @@ func main() {
 
 	instance.Router.SetUniversalRoute("/api/tokens/:username", func(ctx context.Context) flamel.Controller {
 		// todo
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["username"].Value()
 		c := identity.NewTokenControllerWithKey(key)
 		return c
@@ -131,13 +136,73 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/content/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := content.NewContentControllerWithKey(key)
 		c.Private = true
 		return c
 	}, &identity.GSupportAuthenticator{})
 
+	instance.Router.SetUniversalRoute("/api/content/:id/revisions", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		c := content.NewRevisionController(key)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/content/:id/revisions/:revision", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		revision := params["revision"].Value()
+		c := content.NewRevisionControllerWithKey(key, revision)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/content/:id/diff", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		return content.NewRevisionDiffController(key)
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/content/:id/transitions", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		c := content.NewTransitionController(key)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/content/:id/translations", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		c := content.NewTranslationController(key)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/content/:id/children", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		c := content.NewChildrenController(key)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/content/:id/tree", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		return content.NewTreeController(key)
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/batch/content", func(ctx context.Context) flamel.Controller {
+		c := content.NewContentController()
+		c.Private = true
+		c.Batch = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
 	instance.Router.SetUniversalRoute("/api/languages", func(ctx context.Context) flamel.Controller {
 		c := configuration.NewLocaleController()
 		c.Private = true
@@ -163,7 +228,7 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/task/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := content.NewTaskControllerWithKey(key, "", "", "")
 		c.Private = true
@@ -177,13 +242,20 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/attachment/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := content.NewAttachmentControllerWithKey(key)
 		c.Private = true
 		return c
 	}, &identity.GSupportAuthenticator{})
 
+	instance.Router.SetUniversalRoute("/api/batch/attachment", func(ctx context.Context) flamel.Controller {
+		c := content.NewAttachmentController()
+		c.Private = true
+		c.Batch = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
 	instance.Router.SetUniversalRoute("/api/place", func(ctx context.Context) flamel.Controller {
 		c := content.NewPlaceController()
 		c.Private = true
@@ -191,7 +263,7 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/place/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := content.NewPlaceControllerWithKey(key)
 		c.Private = true
@@ -205,7 +277,7 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/seo/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := navigation.NewPageControllerWithKey(key)
 		c.Private = true
@@ -219,7 +291,7 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/mailmessage/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := mailmessage.NewMailMessageControllerWithKey(key)
 		c.Private = true
@@ -233,7 +305,7 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/page/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := navigation.NewPageControllerWithKey(key)
 		c.Private = true
@@ -264,13 +336,90 @@ func main() {
 	}, &identity.GSupportAuthenticator{})
 
 	instance.Router.SetUniversalRoute("/api/subscription/:id", func(ctx context.Context) flamel.Controller {
-		params := flamel.RoutingParams(ctx)
+		params := spellbook.RoutingParams(ctx)
 		key := params["id"].Value()
 		c := subscription.NewSubscriptionControllerWithKey(key)
 		c.Private = true
 		return c
 	}, &identity.GSupportAuthenticator{})
 
+	// run by the cron
+	instance.Router.SetUniversalRoute("/api/cron/content", func(ctx context.Context) flamel.Controller {
+		return content.NewScheduleController()
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/public/content", func(ctx context.Context) flamel.Controller {
+		return content.NewPublicContentController()
+	}, nil)
+
+	instance.Router.SetUniversalRoute("/api/public/content/:id", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		return content.NewPublicContentControllerWithKey(key)
+	}, nil)
+
+	// past slugs are moved permanently to the current ones
+	instance.Router.SetUniversalRoute("/api/public/content/:locale/:slug", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		return content.NewSlugController(params["locale"].Value(), params["slug"].Value(), "/api/public/content/%s/%s")
+	}, nil)
+
+	instance.Router.SetUniversalRoute("/api/redirects", func(ctx context.Context) flamel.Controller {
+		c := content.NewSlugRedirectController()
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/redirects/:id", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		c := content.NewSlugRedirectControllerWithKey(key)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	// the managers restoring the trashed resources, by item type
+	restorers := map[string]trash.Restorer{
+		content.TrashTypeContent:    content.ContentManager{},
+		content.TrashTypeAttachment: content.AttachmentManager{},
+		navigation.TrashTypePage:    navigation.PageManager{},
+	}
+
+	instance.Router.SetUniversalRoute("/api/trash", func(ctx context.Context) flamel.Controller {
+		c := trash.NewTrashController(restorers)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/trash/:id", func(ctx context.Context) flamel.Controller {
+		params := spellbook.RoutingParams(ctx)
+		key := params["id"].Value()
+		c := trash.NewTrashControllerWithKey(key, restorers)
+		c.Private = true
+		return c
+	}, &identity.GSupportAuthenticator{})
+
+	// reports the attachments whose parent is missing
+	instance.Router.SetUniversalRoute("/api/attachments/orphans", func(ctx context.Context) flamel.Controller {
+		c := content.NewOrphanAttachmentController()
+		c.Private = true
+		return &spellbook.AdminController{Controller: c}
+	}, &identity.GSupportAuthenticator{})
+
+	// run by the cron
+	instance.Router.SetUniversalRoute("/api/cron/trash", func(ctx context.Context) flamel.Controller {
+		return trash.NewSweepController(restorers)
+	}, &identity.GSupportAuthenticator{})
+
+	// stores the search terms and the publication schedule in the entities written before them, run once by an admin
+	instance.Router.SetUniversalRoute("/api/cron/resave", func(ctx context.Context) flamel.Controller {
+		return &spellbook.ResaveController{Resavers: []spellbook.Resaver{content.ContentManager{}, navigation.PageManager{}}}
+	}, &identity.GSupportAuthenticator{})
+
+	instance.Router.SetUniversalRoute("/api/openapi", func(ctx context.Context) flamel.Controller {
+		return spellbook.NewOpenAPIController()
+	}, nil)
+
 	m.Router = &instance.Router
 	m.AddService(&model.Service{})
 	m.Run(instance)
@@ -5,6 +5,7 @@ import (
 	"decodica.com/flamel"
 	"golang.org/x/text/language"
 	"sync"
+	"time"
 )
 
 var once sync.Once
@@ -90,6 +91,10 @@ type Options struct {
 	StaticPages  []StaticPageCode
 	SpecialCodes []SpecialCode
 	Actions      []SupportedAction
+	// how long the deleted resources are kept in the trash, forever if zero
+	TrashRetention time.Duration
+	// secret of the scheduled jobs, see IsCron. Without it the jobs can only be run by users
+	CronSecret string
 }
 
 func NewWebsite(opts *Options) *Website {
@@ -0,0 +1,35 @@
+// Package spellbooktest provides utilities to run the spellbook managers and controllers offline,
+// together with the in-memory repositories of the memory package
+package spellbooktest
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/identity"
+	"google.golang.org/appengine"
+	"os"
+)
+
+// NewContext returns a context to run managers and controllers outside of App Engine,
+// authenticated as the given identity, if not nil
+func NewContext(id spellbook.Identity) context.Context {
+	// the App Engine log requires a context it recognizes: outside of App Engine the background context
+	// is the one of the development server, which doesn't query the instance metadata and logs to stderr
+	if !appengine.IsAppEngine() && !appengine.IsDevAppServer() {
+		os.Setenv("RUN_WITH_DEVAPPSERVER", "1")
+	}
+	ctx := appengine.BackgroundContext()
+	if id != nil {
+		ctx = spellbook.ContextWithIdentity(ctx, id)
+	}
+	return ctx
+}
+
+// NewUser returns an enabled user with the given permissions, to be used as the identity of a context
+func NewUser(username string, permissions ...spellbook.Permission) identity.User {
+	user := identity.User{SqlUsername: username, Permission: spellbook.PermissionEnabled}
+	for _, p := range permissions {
+		user.Permission |= p
+	}
+	return user
+}
@@ -0,0 +1,280 @@
+package spellbooktest
+
+import (
+	"bytes"
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/spellbook"
+	"net/http"
+	"testing"
+)
+
+// ManagerSuite checks that a Manager implementation behaves the way the REST handlers expect.
+// Implementations run it from their own tests, e.g. against the in-memory repositories:
+//
+//	spellbooktest.ManagerSuite{
+//		Manager: content.ContentManager{Repository: memory.NewRepository(), Attachments: memory.NewRepository()},
+//		Context: spellbooktest.NewContext(spellbooktest.NewUser("admin", spellbook.PermissionReadContent, spellbook.PermissionWriteContent)),
+//		Bundle:  func(i int) []byte { return []byte(fmt.Sprintf(`{"type":"page","title":"t%d","slug":"s%d","locale":"en"}`, i, i)) },
+//		Order:   "Slug",
+//	}.Run(t)
+//
+// The storage must not contain other resources of the manager when the suite starts.
+// The suite deletes the resources it creates
+type ManagerSuite struct {
+	Manager spellbook.Manager
+	// context authorized to read and write the resources of the manager
+	Context context.Context
+	// Bundle returns the JSON of a new, valid resource.
+	// Resources of different i must not conflict, and their Order field must increase with i
+	Bundle func(i int) []byte
+	// UpdateBundle returns the JSON applied to the resource i by updates, the update check is skipped if nil
+	UpdateBundle func(i int) []byte
+	// field the manager lists the resources by, the order checks are skipped if empty
+	Order string
+	// Filter returns a filter matching the resource i only, the filter check is skipped if nil
+	Filter func(i int) spellbook.Filter
+	// Duplicate returns the JSON of a resource conflicting with the resource 0, the check is skipped if nil
+	Duplicate func() []byte
+	// if the resources can be read without an identity
+	Public bool
+	// Purge removes the deleted resource from the trash, for the managers that trash what they delete.
+	// Trashed resources keep their unique fields, which would conflict with the ones created later
+	Purge func(ctx context.Context, res spellbook.Resource) error
+}
+
+// Run runs the checks of the suite as subtests of t
+func (suite ManagerSuite) Run(t *testing.T) {
+	t.Run("RoundTrip", suite.testRoundTrip)
+	t.Run("ListOf", suite.testListOf)
+	t.Run("Duplicate", suite.testDuplicate)
+	t.Run("Permissions", suite.testPermissions)
+	t.Run("NotFound", suite.testNotFound)
+}
+
+// creates the resource i
+func (suite ManagerSuite) create(t *testing.T, i int) spellbook.Resource {
+	t.Helper()
+	res, err := suite.newResource(suite.Bundle(i))
+	if err != nil {
+		t.Fatalf("invalid bundle %d: %s", i, err.Error())
+	}
+	if err := suite.Manager.Create(suite.Context, res, suite.Bundle(i)); err != nil {
+		t.Fatalf("error creating resource %d: %s", i, err.Error())
+	}
+	if res.Id() == "" {
+		t.Fatalf("created resource %d has no id", i)
+	}
+	return res
+}
+
+func (suite ManagerSuite) newResource(bundle []byte) (spellbook.Resource, error) {
+	res, err := suite.Manager.NewResource(suite.Context)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.FromRepresentation(spellbook.RepresentationTypeJSON, bundle); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// deletes the resources, reloading them from the manager
+func (suite ManagerSuite) cleanup(t *testing.T, resources ...spellbook.Resource) {
+	t.Helper()
+	for _, res := range resources {
+		stored, err := suite.Manager.FromId(suite.Context, res.Id())
+		if err != nil {
+			t.Errorf("error retrieving resource %s: %s", res.Id(), err.Error())
+			continue
+		}
+		if err := suite.Manager.Delete(suite.Context, stored); err != nil {
+			t.Errorf("error deleting resource %s: %s", res.Id(), err.Error())
+			continue
+		}
+		suite.purge(t, stored)
+	}
+}
+
+// purges the deleted resource, if the manager trashes it
+func (suite ManagerSuite) purge(t *testing.T, res spellbook.Resource) {
+	t.Helper()
+	if suite.Purge == nil {
+		return
+	}
+	if err := suite.Purge(suite.Context, res); err != nil {
+		t.Errorf("error purging resource %s: %s", res.Id(), err.Error())
+	}
+}
+
+func (suite ManagerSuite) testRoundTrip(t *testing.T) {
+	res := suite.create(t, 0)
+
+	stored, err := suite.Manager.FromId(suite.Context, res.Id())
+	if err != nil {
+		t.Fatalf("error retrieving created resource %s: %s", res.Id(), err.Error())
+	}
+	if stored.Id() != res.Id() {
+		t.Errorf("retrieved resource %s instead of %s", stored.Id(), res.Id())
+	}
+
+	if suite.UpdateBundle != nil {
+		if err := suite.Manager.Update(suite.Context, stored, suite.UpdateBundle(0)); err != nil {
+			t.Fatalf("error updating resource %s: %s", res.Id(), err.Error())
+		}
+
+		updated, err := suite.Manager.FromId(suite.Context, res.Id())
+		if err != nil {
+			t.Fatalf("error retrieving updated resource %s: %s", res.Id(), err.Error())
+		}
+
+		want, _ := stored.ToRepresentation(spellbook.RepresentationTypeJSON)
+		got, _ := updated.ToRepresentation(spellbook.RepresentationTypeJSON)
+		if !bytes.Equal(want, got) {
+			t.Errorf("updated resource %s is stored as %s, want %s", res.Id(), got, want)
+		}
+	}
+
+	if err := suite.Manager.Delete(suite.Context, stored); err != nil {
+		t.Fatalf("error deleting resource %s: %s", res.Id(), err.Error())
+	}
+
+	if _, err := suite.Manager.FromId(suite.Context, res.Id()); !spellbook.IsNotFound(err) {
+		t.Errorf("deleted resource %s: got error %v, want not found", res.Id(), err)
+	}
+	suite.purge(t, stored)
+}
+
+// checks that the manager lists the ids in the given order and reports if there are more results
+func (suite ManagerSuite) expectList(t *testing.T, opts spellbook.ListOptions, ids []string, more bool) {
+	t.Helper()
+	results, err := suite.Manager.ListOf(suite.Context, opts)
+	if err != nil {
+		t.Fatalf("error listing %+v: %s", opts, err.Error())
+	}
+
+	// managers return one more result than the size when there are more pages, see RestController
+	if len(results) > opts.Size+1 {
+		t.Fatalf("listing %+v returned %d results, want at most %d", opts, len(results), opts.Size+1)
+	}
+	if got := len(results) > opts.Size; got != more {
+		t.Errorf("listing %+v: more is %t, want %t", opts, got, more)
+	}
+	if len(results) > opts.Size {
+		results = results[:opts.Size]
+	}
+
+	if len(results) != len(ids) {
+		t.Fatalf("listing %+v returned %d results, want %d", opts, len(results), len(ids))
+	}
+	for i := range results {
+		if results[i].Id() != ids[i] {
+			t.Errorf("listing %+v returned %s at %d, want %s", opts, results[i].Id(), i, ids[i])
+		}
+	}
+}
+
+func (suite ManagerSuite) testListOf(t *testing.T) {
+	var resources []spellbook.Resource
+	var ids []string
+	for i := 0; i < 3; i++ {
+		res := suite.create(t, i)
+		resources = append(resources, res)
+		ids = append(ids, res.Id())
+	}
+	defer suite.cleanup(t, resources...)
+
+	if suite.Order != "" {
+		suite.expectList(t, spellbook.ListOptions{Size: 2, Order: suite.Order}, ids[:2], true)
+		suite.expectList(t, spellbook.ListOptions{Size: 2, Page: 1, Order: suite.Order}, ids[2:], false)
+		suite.expectList(t, spellbook.ListOptions{Size: 3, Order: suite.Order}, ids, false)
+		suite.expectList(t, spellbook.ListOptions{Size: 2, Order: suite.Order, Descending: true}, []string{ids[2], ids[1]}, true)
+	}
+
+	if suite.Filter != nil {
+		opts := spellbook.ListOptions{Size: 3, Order: suite.Order, Filters: []spellbook.Filter{suite.Filter(1)}}
+		suite.expectList(t, opts, ids[1:2], false)
+	}
+}
+
+func (suite ManagerSuite) testDuplicate(t *testing.T) {
+	if suite.Duplicate == nil {
+		t.Skip("no duplicate bundle")
+	}
+
+	res := suite.create(t, 0)
+	defer suite.cleanup(t, res)
+
+	duplicate, err := suite.newResource(suite.Duplicate())
+	if err != nil {
+		t.Fatalf("invalid duplicate bundle: %s", err.Error())
+	}
+
+	err = suite.Manager.Create(suite.Context, duplicate, suite.Duplicate())
+	if err == nil {
+		suite.cleanup(t, duplicate)
+		t.Fatal("duplicate resource has been created")
+	}
+	if status := spellbook.ProblemFromError(err).Status; status >= http.StatusInternalServerError {
+		t.Errorf("duplicate resource error %q maps to status %d, want a client error", err.Error(), status)
+	}
+}
+
+// checks that the error is a permission error
+func expectPermissionError(t *testing.T, method string, err error) {
+	t.Helper()
+	if _, ok := err.(spellbook.PermissionError); !ok {
+		t.Errorf("%s without identity: got error %v, want a permission error", method, err)
+	}
+}
+
+func (suite ManagerSuite) testPermissions(t *testing.T) {
+	res := suite.create(t, 0)
+	defer suite.cleanup(t, res)
+
+	anonymous := NewContext(nil)
+
+	if !suite.Public {
+		_, err := suite.Manager.FromId(anonymous, res.Id())
+		expectPermissionError(t, "FromId", err)
+		_, err = suite.Manager.ListOf(anonymous, spellbook.ListOptions{Size: 1, Order: suite.Order})
+		expectPermissionError(t, "ListOf", err)
+	}
+
+	stored, err := suite.Manager.FromId(suite.Context, res.Id())
+	if err != nil {
+		t.Fatalf("error retrieving resource %s: %s", res.Id(), err.Error())
+	}
+
+	other, err := suite.newResource(suite.Bundle(1))
+	if err != nil {
+		t.Fatalf("invalid bundle 1: %s", err.Error())
+	}
+	err = suite.Manager.Create(anonymous, other, suite.Bundle(1))
+	expectPermissionError(t, "Create", err)
+	if err == nil {
+		suite.cleanup(t, other)
+	}
+
+	if suite.UpdateBundle != nil {
+		expectPermissionError(t, "Update", suite.Manager.Update(anonymous, stored, suite.UpdateBundle(0)))
+	}
+	expectPermissionError(t, "Delete", suite.Manager.Delete(anonymous, stored))
+}
+
+func (suite ManagerSuite) testNotFound(t *testing.T) {
+	res := suite.create(t, 0)
+	id := res.Id()
+	suite.cleanup(t, res)
+
+	_, err := suite.Manager.FromId(suite.Context, id)
+	if err == nil {
+		t.Fatalf("deleted resource %s has been found", id)
+	}
+
+	out := flamel.ResponseOutput{}
+	handler := spellbook.BaseRestHandler{Manager: suite.Manager}
+	if status := handler.ErrorToStatus(suite.Context, err, &out).Status; status != http.StatusNotFound {
+		t.Errorf("error %q of a missing resource maps to status %d, want %d", err.Error(), status, http.StatusNotFound)
+	}
+}
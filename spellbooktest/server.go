@@ -0,0 +1,233 @@
+package spellbooktest
+
+import (
+	"bytes"
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/spellbook"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// Server serves controllers over http the way flamel does, so that they can be driven end to end,
+// either through Do or by an httptest.Server.
+// The inputs of the requests and the route parameters are passed with spellbook.ContextWithInputs
+// and spellbook.ContextWithRoutingParams: controllers and route handlers must read them
+// with spellbook.InputsFromContext and spellbook.RoutingParams.
+// As with flamel, only the bodies of the application/json requests are inputs: the raw body is passed with spellbook.ContextWithBody.
+// Only the headers added with spellbook.AddHeader are written, headers added to the flamel.ResponseOutput are kept by flamel
+type Server struct {
+	// Context returns the context the requests are run with
+	Context func(r *http.Request) context.Context
+	routes  []spellbook.Route
+}
+
+// NewServer returns a server running every request with the given context, see NewContext
+func NewServer(ctx context.Context) *Server {
+	return &Server{Context: func(r *http.Request) context.Context {
+		return ctx
+	}}
+}
+
+// Handle registers the controller of a path.
+// Path parameters are prefixed by a colon, as in flamel routes
+func (server *Server) Handle(path string, handler func(ctx context.Context) flamel.Controller, authenticator flamel.Authenticator) {
+	server.routes = append(server.routes, spellbook.Route{Path: path, Handler: handler, Authenticator: authenticator})
+}
+
+// HandleRoutes registers the given routes, e.g. the universal routes of the application router
+func (server *Server) HandleRoutes(routes []spellbook.Route) {
+	server.routes = append(server.routes, routes...)
+}
+
+// Do serves the request, returning the recorded response
+func (server *Server) Do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, params, ok := server.match(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, ins, err := inputs(r, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	headers := http.Header{}
+	ctx := server.Context(r)
+	ctx = spellbook.ContextWithHeaders(ctx, headers)
+	ctx = spellbook.ContextWithInputs(ctx, ins)
+	ctx = spellbook.ContextWithBody(ctx, body)
+	ctx = spellbook.ContextWithRoutingParams(ctx, params)
+	if route.Authenticator != nil {
+		ctx = route.Authenticator.Authenticate(ctx)
+	}
+
+	controller := route.Handler(ctx)
+	if n, ok := controller.(negotiator); ok {
+		ins[flamel.KeyNegotiatedContent] = spellbook.NewInput(negotiate(n, r.Header.Get("Accept")))
+	}
+
+	out := flamel.ResponseOutput{}
+	res := controller.Process(ctx, &out)
+	controller.OnDestroy(ctx)
+
+	if res.Location != "" {
+		w.Header().Set("Location", res.Location)
+	}
+
+	rw := &responseWriter{ResponseWriter: w, status: res.Status, headers: headers}
+	// responses without a body, e.g. not modified, aren't rendered
+	if out.Renderer != nil && res.Status != http.StatusNoContent && res.Status != http.StatusNotModified {
+		if err := out.Renderer.Render(rw); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	rw.writeHeader()
+}
+
+// finds the route of the path, returning the values of its parameters
+func (server *Server) match(path string) (spellbook.Route, spellbook.Inputs, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, route := range server.routes {
+		parts := strings.Split(strings.Trim(route.Path, "/"), "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+
+		params := spellbook.Inputs{}
+		matched := true
+		for i, part := range parts {
+			if strings.HasPrefix(part, ":") {
+				params[part[1:]] = spellbook.NewInput(segments[i])
+				continue
+			}
+			if part != segments[i] {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return route, params, true
+		}
+	}
+	return spellbook.Route{}, nil, false
+}
+
+// builds the inputs of the request: the query and form values, the headers, the JSON body and the route parameters.
+// Returns the raw body too
+func inputs(r *http.Request, params spellbook.Inputs) ([]byte, spellbook.Inputs, error) {
+	ins := spellbook.Inputs{
+		flamel.KeyRequestMethod: spellbook.NewInput(r.Method),
+		flamel.KeyRequestURL:    spellbook.NewInput(r.URL.Path),
+		flamel.KeyRequestQuery:  spellbook.NewInput(r.URL.RawQuery),
+	}
+
+	for k, v := range r.Header {
+		ins[k] = spellbook.NewInput(v...)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = b
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ct == "application/json":
+		if len(body) > 0 {
+			ins[flamel.KeyRequestJSON] = spellbook.NewInput(string(body))
+		}
+	case ct == "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, nil, err
+		}
+		for k, files := range r.MultipartForm.File {
+			ins[k] = spellbook.NewFileInput(files...)
+		}
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, err
+	}
+	for k, v := range r.Form {
+		ins[k] = spellbook.NewInput(v...)
+	}
+
+	for k, v := range params {
+		ins[k] = v
+	}
+	return body, ins, nil
+}
+
+// implemented by controllers offering more than one content type
+type negotiator interface {
+	DefaultOffer() string
+	Offers() []string
+}
+
+// returns the first offer of the controller accepted by the client
+func negotiate(n negotiator, accept string) string {
+	for _, a := range strings.Split(accept, ",") {
+		t, _, err := mime.ParseMediaType(strings.TrimSpace(a))
+		if err != nil {
+			continue
+		}
+		for _, offer := range n.Offers() {
+			if offer == t {
+				return offer
+			}
+		}
+	}
+	return n.DefaultOffer()
+}
+
+// writes the status and the headers of the controller before the body rendered by the renderer.
+// The headers of the controller take the place of the ones set by the renderer, e.g. the Content-Type of the problems
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	headers http.Header
+	written bool
+}
+
+func (w *responseWriter) writeHeader() {
+	if w.written {
+		return
+	}
+	w.written = true
+	for k, v := range w.headers {
+		w.Header()[k] = v
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// the status is the one returned by the controller
+func (w *responseWriter) WriteHeader(status int) {
+	w.writeHeader()
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.writeHeader()
+	return w.ResponseWriter.Write(b)
+}
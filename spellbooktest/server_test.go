@@ -0,0 +1,156 @@
+package spellbooktest
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/content"
+	"decodica.com/spellbook/memory"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// returns a server of the contents stored in memory, run as a user that can read and write them
+func newContentServer() *Server {
+	manager := content.ContentManager{Repository: memory.NewRepository(), Attachments: memory.NewRepository()}
+	server := NewServer(NewContext(NewUser("admin", spellbook.PermissionReadContent, spellbook.PermissionWriteContent)))
+	server.Handle("/api/contents", func(ctx context.Context) flamel.Controller {
+		return spellbook.NewRestController(spellbook.BaseRestHandler{Manager: manager})
+	}, nil)
+	server.Handle("/api/contents/:id", func(ctx context.Context) flamel.Controller {
+		c := spellbook.NewRestController(spellbook.BaseRestHandler{Manager: manager})
+		c.Key = spellbook.RoutingParams(ctx)["id"].Value()
+		return c
+	}, nil)
+	return server
+}
+
+func request(method string, path string, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// checks the status of the response, returning its JSON body
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) map[string]interface{} {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	body := make(map[string]interface{})
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid body %s: %s", w.Body.String(), err)
+		}
+	}
+	return body
+}
+
+func TestServerContents(t *testing.T) {
+	server := newContentServer()
+
+	created := expectStatus(t, server.Do(request(http.MethodPost, "/api/contents", `{"type":"page","title":"Hello world","locale":"en"}`)), http.StatusCreated)
+	id, _ := created["id"].(string)
+	if id == "" {
+		t.Fatalf("created content %v has no id", created)
+	}
+
+	w := server.Do(request(http.MethodGet, "/api/contents/"+id, ""))
+	read := expectStatus(t, w, http.StatusOK)
+	if read["slug"] != "hello-world" {
+		t.Errorf("got slug %v, want hello-world", read["slug"])
+	}
+	etag := w.Header().Get(spellbook.HeaderETag)
+	if etag == "" {
+		t.Fatal("the content has no ETag")
+	}
+
+	r := request(http.MethodGet, "/api/contents/"+id, "")
+	r.Header.Set(spellbook.HeaderIfNoneMatch, etag)
+	expectStatus(t, server.Do(r), http.StatusNotModified)
+
+	r = request(http.MethodPut, "/api/contents/"+id, `{"type":"page","title":"Hello again","slug":"hello-world","locale":"en"}`)
+	r.Header.Set(spellbook.HeaderIfMatch, `"stale"`)
+	expectStatus(t, server.Do(r), http.StatusPreconditionFailed)
+
+	list := expectStatus(t, server.Do(request(http.MethodGet, "/api/contents?results=10", "")), http.StatusOK)
+	if items, _ := list["items"].([]interface{}); len(items) != 1 {
+		t.Errorf("got items %v, want the created content", list["items"])
+	}
+
+	expectStatus(t, server.Do(request(http.MethodDelete, "/api/contents/"+id, "")), http.StatusOK)
+	expectStatus(t, server.Do(request(http.MethodGet, "/api/contents/"+id, "")), http.StatusNotFound)
+}
+
+func TestServerProblems(t *testing.T) {
+	server := newContentServer()
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+	}{
+		{"missing", request(http.MethodGet, "/api/contents/missing", ""), http.StatusNotFound},
+		{"invalid", request(http.MethodPost, "/api/contents", `{"title":"no type"}`), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := server.Do(test.r)
+			problem := expectStatus(t, w, test.status)
+			if ct, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); ct != spellbook.MediaTypeProblem {
+				t.Errorf("got content type %q, want %s", ct, spellbook.MediaTypeProblem)
+			}
+			if problem["status"] != float64(test.status) {
+				t.Errorf("got problem %v, want status %d", problem, test.status)
+			}
+		})
+	}
+
+	if w := server.Do(request(http.MethodGet, "/api/missing", "")); w.Code != http.StatusNotFound {
+		t.Errorf("unrouted path: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestServerPatch(t *testing.T) {
+	tests := []struct {
+		contentType string
+		patch       string
+	}{
+		{"application/json", `{"title":"Patched"}`},
+		{spellbook.MediaTypeMergePatch, `{"title":"Patched"}`},
+		{spellbook.MediaTypeJSONPatch, `[{"op":"replace","path":"/title","value":"Patched"}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			server := newContentServer()
+			created := expectStatus(t, server.Do(request(http.MethodPost, "/api/contents", `{"type":"page","title":"Hello world","locale":"en"}`)), http.StatusCreated)
+			id, _ := created["id"].(string)
+
+			r := httptest.NewRequest(http.MethodPatch, "/api/contents/"+id, strings.NewReader(test.patch))
+			r.Header.Set("Content-Type", test.contentType)
+			expectStatus(t, server.Do(r), http.StatusOK)
+
+			read := expectStatus(t, server.Do(request(http.MethodGet, "/api/contents/"+id, "")), http.StatusOK)
+			if read["title"] != "Patched" || read["slug"] != "hello-world" {
+				t.Errorf("got title %v and slug %v, want the patched title only", read["title"], read["slug"])
+			}
+		})
+	}
+
+	server := newContentServer()
+	created := expectStatus(t, server.Do(request(http.MethodPost, "/api/contents", `{"type":"page","title":"Hello world","locale":"en"}`)), http.StatusCreated)
+	id, _ := created["id"].(string)
+	r := httptest.NewRequest(http.MethodPatch, "/api/contents/"+id, strings.NewReader(`{"title":"Patched"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	if w := server.Do(r); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text patch: got status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
@@ -0,0 +1,64 @@
+package sql
+
+import (
+	"decodica.com/spellbook"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// returns the column of the field in the model of the query, if any, or the column named after the field
+func columnName(db *gorm.DB, field string) string {
+	if db.Value != nil {
+		if f, ok := db.NewScope(db.Value).FieldByName(field); ok {
+			return f.DBName
+		}
+	}
+	return ToColumnName(field)
+}
+
+// Filter applies the filters to a gorm query.
+// Fields are converted to the columns of the model of the query, if set with Model, or to their column names.
+// Values are always passed as query parameters
+func Filter(db *gorm.DB, fields spellbook.FilterFields, filters []spellbook.Filter) (*gorm.DB, error) {
+	if err := fields.Validate(filters); err != nil {
+		return nil, err
+	}
+
+	for _, filter := range filters {
+		if filter.Field == "" {
+			continue
+		}
+
+		column := Quote(db, columnName(db, filter.Field))
+		switch filter.Operator {
+		case spellbook.FilterIn:
+			values := filter.Values()
+			typed := make([]interface{}, len(values))
+			for i, v := range values {
+				typed[i], _ = fields.Value(filter.Field, v)
+			}
+			db = db.Where(column+" IN (?)", typed)
+		case spellbook.FilterPrefix:
+			// escape the LIKE wildcards of the value.
+			// The escape character is explicit, since not every dialect has a default one
+			prefix := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(filter.Value)
+			db = db.Where(column+" LIKE ? ESCAPE '!'", prefix+"%")
+		case spellbook.FilterNull:
+			db = db.Where(column + " IS NULL")
+		case spellbook.FilterNotNull:
+			db = db.Where(column + " IS NOT NULL")
+		case "", spellbook.FilterEqual, spellbook.FilterNotEqual, spellbook.FilterLess, spellbook.FilterLessOrEqual, spellbook.FilterGreater, spellbook.FilterGreaterOrEqual:
+			op := filter.Operator
+			if op == "" {
+				op = spellbook.FilterEqual
+			}
+			v, _ := fields.Value(filter.Field, filter.Value)
+			db = db.Where(fmt.Sprintf("%s %s ?", column, op), v)
+		default:
+			return nil, spellbook.NewFieldError("filter", errors.New("unknown filter operator"))
+		}
+	}
+	return db, nil
+}
@@ -0,0 +1,178 @@
+package sql
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"time"
+)
+
+// Step is a versioned change of the schema.
+// Up applies the change, Down reverts it
+type Step struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// Migrations are the steps of the schema, applied in version order.
+// Applied versions are recorded in the schema_migrations table, so that each step runs once.
+// Each step runs in a transaction together with its record.
+// Migrations of different packages are combined by appending them: versions must be unique
+type Migrations []Step
+
+// a step applied to the database
+type schemaMigration struct {
+	Version   int64 `gorm:"PRIMARY_KEY;AUTO_INCREMENT:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Execute applies the pending steps when the service is initialized, panicking if one of them fails
+func (migrations Migrations) Execute(db *gorm.DB) {
+	if err := migrations.Up(db); err != nil {
+		panic(err)
+	}
+}
+
+// returns the steps sorted by version
+func (migrations Migrations) sorted() (Migrations, error) {
+	steps := make(Migrations, len(migrations))
+	copy(steps, migrations)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].Version < steps[j].Version
+	})
+
+	for i := 1; i < len(steps); i++ {
+		if steps[i].Version == steps[i-1].Version {
+			return nil, fmt.Errorf("migrations %q and %q have the same version %d", steps[i-1].Name, steps[i].Name, steps[i].Version)
+		}
+	}
+	return steps, nil
+}
+
+// Applied returns the versions applied to the database, in ascending order
+func (migrations Migrations) Applied(db *gorm.DB) ([]int64, error) {
+	create := CreateTable("schema_migrations",
+		Column{Name: "version", Type: TypeBigInt, PrimaryKey: true},
+		Column{Name: "name", Type: TypeString, NotNull: true},
+		Column{Name: "applied_at", Type: TypeTime, NotNull: true},
+	)
+	if err := create(db); err != nil {
+		return nil, err
+	}
+
+	var versions []int64
+	version := Quote(db, "version")
+	if err := db.Model(&schemaMigration{}).Order(version+" asc").Pluck(version, &versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// Up applies the steps that have not been applied yet
+func (migrations Migrations) Up(db *gorm.DB) error {
+	steps, err := migrations.sorted()
+	if err != nil {
+		return err
+	}
+
+	versions, err := migrations.Applied(db)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int64]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	for _, step := range steps {
+		if applied[step.Version] {
+			continue
+		}
+
+		err := transaction(db, func(tx *gorm.DB) error {
+			if err := step.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: step.Version, Name: step.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("error applying migration %d %q: %s", step.Version, step.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Down reverts the applied steps with a version greater than the given one, starting from the latest.
+// Down(db, 0) reverts all the steps
+func (migrations Migrations) Down(db *gorm.DB, version int64) error {
+	steps, err := migrations.sorted()
+	if err != nil {
+		return err
+	}
+
+	versions, err := migrations.Applied(db)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int64]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.Version <= version || !applied[step.Version] {
+			continue
+		}
+
+		if step.Down == nil {
+			return fmt.Errorf("migration %d %q can't be reverted", step.Version, step.Name)
+		}
+
+		err := transaction(db, func(tx *gorm.DB) error {
+			if err := step.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: step.Version}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("error reverting migration %d %q: %s", step.Version, step.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// Exec returns a step function running the given statements in order.
+// Statements are run as they are, so they must be valid in the dialect of the connection
+func Exec(statements ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, s := range statements {
+			if err := tx.Exec(s).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,257 @@
+package sql
+
+import (
+	"context"
+	"decodica.com/spellbook"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strconv"
+)
+
+// Repository stores the resources of a type in the table gorm maps the type to.
+// The filters of the queries are validated against fields, while columns maps
+// the JSON fields of the resource to the columns backing them, see Select
+type Repository struct {
+	prototype spellbook.Resource
+	fields    spellbook.FilterFields
+	columns   map[string][]string
+}
+
+// NewRepository returns the repository of the type of prototype, which must be a pointer to a gorm model
+func NewRepository(prototype spellbook.Resource, fields spellbook.FilterFields, columns map[string][]string) Repository {
+	return Repository{prototype: prototype, fields: fields, columns: columns}
+}
+
+func (repository Repository) newResource() spellbook.Resource {
+	return reflect.New(reflect.TypeOf(repository.prototype).Elem()).Interface().(spellbook.Resource)
+}
+
+func (repository Repository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	return repository.find(ctx, FromContext(ctx), id)
+}
+
+// returns the resource with the primary key id among the rows of db
+func (repository Repository) find(ctx context.Context, db *gorm.DB, id string) (spellbook.Resource, error) {
+	resource := repository.newResource()
+	scope := db.NewScope(resource)
+
+	key, err := primaryKey(scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// rows read in a transaction are locked until it ends, so that they don't change between the checks and the writes.
+	// SQLite has no row locks, its writers are serialized anyway
+	if InTransaction(ctx) && db.Dialect().GetName() != "sqlite3" {
+		db = db.Set("gorm:query_option", "FOR UPDATE")
+	}
+
+	if err := db.Where(Quote(db, scope.PrimaryKey())+" = ?", key).First(resource).Error; err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+// returns the value of the primary key of the id, parsed if the key is an integer
+func primaryKey(scope *gorm.Scope, id string) (interface{}, error) {
+	if field := scope.PrimaryField(); field != nil {
+		switch field.Field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return nil, spellbook.NewFieldError("id", fmt.Errorf("invalid id format: %s. Id must be an int", id))
+			}
+			return n, nil
+		}
+	}
+	return id, nil
+}
+
+// FromIds reads the resources of the ids with a single query, see spellbook.BatchRepository
+func (repository Repository) FromIds(ctx context.Context, ids []string) ([]spellbook.Resource, error) {
+	db := FromContext(ctx)
+	scope := db.NewScope(repository.prototype)
+
+	var keys []interface{}
+	for _, id := range ids {
+		// invalid ids identify no resource
+		if key, err := primaryKey(scope, id); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	resources := make([]spellbook.Resource, len(ids))
+	if len(keys) == 0 {
+		return resources, nil
+	}
+
+	list := reflect.New(reflect.SliceOf(reflect.TypeOf(repository.prototype)))
+	if err := db.Where(Quote(db, scope.PrimaryKey())+" IN (?)", keys).Find(list.Interface()).Error; err != nil {
+		return nil, err
+	}
+
+	list = list.Elem()
+	found := make(map[string]spellbook.Resource, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		res := list.Index(i).Interface().(spellbook.Resource)
+		found[res.Id()] = res
+	}
+	for i, id := range ids {
+		resources[i] = found[id]
+	}
+	return resources, nil
+}
+
+// applies the filters and the order of the query
+func (repository Repository) query(ctx context.Context, query spellbook.Query) (*gorm.DB, error) {
+	db := FromContext(ctx)
+	if query.Trashed {
+		db = db.Unscoped()
+	}
+
+	db, err := Filter(db.Model(repository.prototype), repository.fields, query.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Order != "" {
+		column := columnName(db, query.Order)
+		dir := "asc"
+		op := ">="
+		if query.Descending {
+			dir = "desc"
+			op = "<="
+		}
+		db = db.Order(Quote(db, column) + " " + dir)
+
+		if query.Start != nil {
+			db = db.Where(fmt.Sprintf("%s %s ?", Quote(db, column), op), query.Start)
+		}
+	}
+	return db, nil
+}
+
+// applies the offset and the limit of the query
+func page(db *gorm.DB, query spellbook.Query) *gorm.DB {
+	if query.Offset > 0 {
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	return db
+}
+
+func (repository Repository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	db, err := repository.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// without the columns of the fields the whole rows are loaded
+	if repository.columns != nil {
+		order := ""
+		if query.Order != "" {
+			order = columnName(db, query.Order)
+		}
+		db = Select(db, repository.columns, query.Fields, db.NewScope(repository.prototype).PrimaryKey(), order)
+	}
+
+	list := reflect.New(reflect.SliceOf(reflect.TypeOf(repository.prototype)))
+	if err := page(db, query).Find(list.Interface()).Error; err != nil {
+		return nil, err
+	}
+
+	list = list.Elem()
+	resources := make([]spellbook.Resource, list.Len())
+	for i := range resources {
+		resources[i] = list.Index(i).Interface().(spellbook.Resource)
+	}
+	return resources, nil
+}
+
+func (repository Repository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	db, err := repository.query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	if err := db.Model(repository.prototype).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Distinct lists the distinct, non empty values of the column of the field
+func (repository Repository) Distinct(ctx context.Context, field string, query spellbook.Query) ([]string, error) {
+	query.Order = ""
+	db, err := repository.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	column := Quote(db, columnName(db, field))
+	db = db.Model(repository.prototype).Where(fmt.Sprintf("%s IS NOT NULL AND %s <> ''", column, column))
+
+	var values []string
+	if err := page(db, query).Pluck("DISTINCT "+column, &values).Error; err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (repository Repository) Create(ctx context.Context, resource spellbook.Resource) error {
+	return FromContext(ctx).Create(resource).Error
+}
+
+// Update saves the resource, also if it is in the trash: gorm would otherwise insert the trashed rows again
+func (repository Repository) Update(ctx context.Context, resource spellbook.Resource) error {
+	return FromContext(ctx).Unscoped().Save(resource).Error
+}
+
+// Delete deletes the resource for good, also if it is in the trash
+func (repository Repository) Delete(ctx context.Context, resource spellbook.Resource) error {
+	return FromContext(ctx).Unscoped().Delete(resource).Error
+}
+
+// reports if the rows of the resources have the deleted_at column gorm soft deletes with, see spellbook.GormModel
+func (repository Repository) trashable(db *gorm.DB) bool {
+	return db.NewScope(repository.prototype).HasColumn("DeletedAt")
+}
+
+// Trash sets the deleted_at column of the resource, see spellbook.TrashRepository.
+// Only the resources with a DeletedAt field can be trashed
+func (repository Repository) Trash(ctx context.Context, resource spellbook.Resource) error {
+	db := FromContext(ctx)
+	if !repository.trashable(db) {
+		return spellbook.NewUnsupportedError()
+	}
+	return db.Delete(resource).Error
+}
+
+// Trashed returns the resource with the given id if it is in the trash
+func (repository Repository) Trashed(ctx context.Context, id string) (spellbook.Resource, error) {
+	db := FromContext(ctx)
+	if !repository.trashable(db) {
+		return nil, spellbook.ErrNotFound
+	}
+	return repository.find(ctx, db.Unscoped().Where(Quote(db, "deleted_at")+" IS NOT NULL"), id)
+}
+
+// Restore clears the deleted_at column of the resource
+func (repository Repository) Restore(ctx context.Context, resource spellbook.Resource) error {
+	db := FromContext(ctx)
+	if !repository.trashable(db) {
+		return spellbook.NewUnsupportedError()
+	}
+	return db.Unscoped().Model(resource).UpdateColumn("deleted_at", nil).Error
+}
+
+// RunInTransaction runs fn in a database transaction, see RunInTransaction
+func (repository Repository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunInTransaction(ctx, fn)
+}
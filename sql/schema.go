@@ -0,0 +1,248 @@
+package sql
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+)
+
+// ColumnType is a portable column type, mapped to the type of each dialect
+type ColumnType int
+
+const (
+	// short strings, that can be indexed
+	TypeString ColumnType = iota
+	// long texts
+	TypeText
+	TypeInteger
+	TypeBigInt
+	TypeTime
+	// auto incrementing integer primary key
+	TypeSerial
+)
+
+// types of the dialects supported by spellbook
+var columnTypes = map[string]map[ColumnType]string{
+	"postgres": {
+		TypeString:  "text",
+		TypeText:    "text",
+		TypeInteger: "integer",
+		TypeBigInt:  "bigint",
+		TypeTime:    "timestamp with time zone",
+		TypeSerial:  "serial PRIMARY KEY",
+	},
+	"sqlite3": {
+		TypeString:  "text",
+		TypeText:    "text",
+		TypeInteger: "integer",
+		TypeBigInt:  "bigint",
+		TypeTime:    "datetime",
+		TypeSerial:  "integer PRIMARY KEY AUTOINCREMENT",
+	},
+	"mysql": {
+		TypeString:  "varchar(255)",
+		TypeText:    "longtext",
+		TypeInteger: "int",
+		TypeBigInt:  "bigint",
+		TypeTime:    "datetime",
+		TypeSerial:  "int AUTO_INCREMENT PRIMARY KEY",
+	},
+}
+
+// Column is a column of a table created by CreateTable.
+// TypeSerial columns are primary keys already, whatever the value of PrimaryKey
+type Column struct {
+	Name       string
+	Type       ColumnType
+	NotNull    bool
+	PrimaryKey bool
+}
+
+func columnType(db *gorm.DB, t ColumnType) (string, error) {
+	dialect := db.Dialect().GetName()
+	types, ok := columnTypes[dialect]
+	if !ok {
+		return "", fmt.Errorf("unsupported sql dialect %s", dialect)
+	}
+	return types[t], nil
+}
+
+// CreateTable returns a step function creating the table, if it doesn't exist
+func CreateTable(table string, columns ...Column) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if tx.Dialect().HasTable(table) {
+			return nil
+		}
+
+		definitions := make([]string, len(columns))
+		for i, c := range columns {
+			d, err := definition(tx, c)
+			if err != nil {
+				return err
+			}
+			definitions[i] = d
+		}
+
+		return tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", Quote(tx, table), strings.Join(definitions, ", "))).Error
+	}
+}
+
+// returns the definition of the column in the dialect of the connection
+func definition(tx *gorm.DB, c Column) (string, error) {
+	t, err := columnType(tx, c.Type)
+	if err != nil {
+		return "", err
+	}
+
+	d := Quote(tx, c.Name) + " " + t
+	if c.NotNull {
+		d += " NOT NULL"
+	}
+	if c.PrimaryKey && c.Type != TypeSerial {
+		d += " PRIMARY KEY"
+	}
+	return d, nil
+}
+
+// AddColumn returns a step function adding the column to the table, if it doesn't exist
+func AddColumn(table string, column Column) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if tx.Dialect().HasColumn(table, column.Name) {
+			return nil
+		}
+
+		d, err := definition(tx, column)
+		if err != nil {
+			return err
+		}
+		return tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", Quote(tx, table), d)).Error
+	}
+}
+
+// DropColumn returns a step function dropping the column of the table, if it exists.
+// SQLite can't drop columns before 3.35, so the step leaves the column in place there
+func DropColumn(table string, column string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if !tx.Dialect().HasColumn(table, column) {
+			return nil
+		}
+
+		if tx.Dialect().GetName() == "sqlite3" {
+			var version string
+			if err := tx.Raw("SELECT sqlite_version()").Row().Scan(&version); err != nil {
+				return err
+			}
+			var major, minor int
+			fmt.Sscanf(version, "%d.%d", &major, &minor)
+			if major < 3 || major == 3 && minor < 35 {
+				return nil
+			}
+		}
+		return tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", Quote(tx, table), Quote(tx, column))).Error
+	}
+}
+
+// reports whether the table has the index.
+// The dialect of gorm can't find the quoted indexes on SQLite, which are looked up by name in its catalog
+func hasIndex(tx *gorm.DB, table string, name string) (bool, error) {
+	if tx.Dialect().GetName() != "sqlite3" {
+		return tx.Dialect().HasIndex(table, name), nil
+	}
+
+	var count int
+	err := tx.Raw("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?", table, name).Row().Scan(&count)
+	return count > 0, err
+}
+
+// CreateUniqueIndex returns a step function creating the unique index of the columns, if it doesn't exist
+func CreateUniqueIndex(name string, table string, columns ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		exists, err := hasIndex(tx, table, name)
+		if err != nil || exists {
+			return err
+		}
+
+		quoted := make([]string, len(columns))
+		for i, c := range columns {
+			quoted[i] = Quote(tx, c)
+		}
+		return tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", Quote(tx, name), Quote(tx, table), strings.Join(quoted, ", "))).Error
+	}
+}
+
+// DropIndex returns a step function dropping the index of the table, if it exists
+func DropIndex(name string, table string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		exists, err := hasIndex(tx, table, name)
+		if err != nil || !exists {
+			return err
+		}
+
+		if tx.Dialect().GetName() == "mysql" {
+			return tx.Exec(fmt.Sprintf("DROP INDEX %s ON %s", Quote(tx, name), Quote(tx, table))).Error
+		}
+		return tx.Exec(fmt.Sprintf("DROP INDEX %s", Quote(tx, name))).Error
+	}
+}
+
+// DropTable returns a step function dropping the table, if it exists
+func DropTable(table string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", Quote(tx, table))).Error
+	}
+}
+
+// Steps returns a step function running the given ones in order
+func Steps(steps ...func(tx *gorm.DB) error) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, step := range steps {
+			if err := step(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Quote quotes the identifier with the quoting of the dialect of the connection
+func Quote(db *gorm.DB, identifier string) string {
+	return db.Dialect().Quote(identifier)
+}
+
+// AddForeignKey returns a step function adding the foreign key constraint of the column, if it doesn't exist.
+// SQLite can't add constraints to existing tables, so the step does nothing there
+func AddForeignKey(name string, table string, column string, refTable string, refColumn string) func(tx *gorm.DB) error {
+	return AddForeignKeyOnDelete(name, table, column, refTable, refColumn, "")
+}
+
+// AddForeignKeyOnDelete returns a step function adding the foreign key constraint like AddForeignKey,
+// with the action taken when the referenced row is deleted, e.g. CASCADE or SET NULL
+func AddForeignKeyOnDelete(name string, table string, column string, refTable string, refColumn string, onDelete string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if tx.Dialect().GetName() == "sqlite3" || tx.Dialect().HasForeignKey(table, name) {
+			return nil
+		}
+
+		statement := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+			Quote(tx, table), Quote(tx, name), Quote(tx, column), Quote(tx, refTable), Quote(tx, refColumn))
+		if onDelete != "" {
+			statement += " ON DELETE " + onDelete
+		}
+		return tx.Exec(statement).Error
+	}
+}
+
+// DropForeignKey returns a step function dropping the foreign key constraint, if it exists
+func DropForeignKey(name string, table string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if tx.Dialect().GetName() == "sqlite3" || !tx.Dialect().HasForeignKey(table, name) {
+			return nil
+		}
+
+		statement := "ALTER TABLE %s DROP CONSTRAINT %s"
+		if tx.Dialect().GetName() == "mysql" {
+			statement = "ALTER TABLE %s DROP FOREIGN KEY %s"
+		}
+		return tx.Exec(fmt.Sprintf(statement, Quote(tx, table), Quote(tx, name))).Error
+	}
+}
@@ -0,0 +1,34 @@
+package sql
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Select restricts the columns loaded by the query to the ones backing the requested JSON fields.
+// columns maps each JSON field to the columns it is built from, fields that are not in the map are ignored.
+// The always columns, e.g. the primary key, are selected regardless of the requested fields.
+// If no field is requested the query is returned unchanged
+func Select(db *gorm.DB, columns map[string][]string, fields []string, always ...string) *gorm.DB {
+	if len(fields) == 0 {
+		return db
+	}
+
+	seen := map[string]bool{}
+	var selected []string
+	add := func(column string) {
+		if column != "" && !seen[column] {
+			seen[column] = true
+			selected = append(selected, Quote(db, column))
+		}
+	}
+
+	for _, c := range always {
+		add(c)
+	}
+	for _, f := range fields {
+		for _, c := range columns[f] {
+			add(c)
+		}
+	}
+	return db.Select(selected)
+}
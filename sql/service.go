@@ -11,13 +11,20 @@ type key string
 
 const sqlKey key = "__sql_connection"
 
+// Service connects to the database of the application.
+// The driver of the dialect must be registered by the application,
+// e.g. by importing github.com/jinzhu/gorm/dialects/sqlite
 type Service struct {
+	// gorm dialect of the connection: postgres, mysql or sqlite3. Defaults to postgres
+	Dialect    string
 	Connection string
-	Debug bool
+	Debug      bool
+	// run on Initialize, e.g. append(content.Migrations, identity.Migrations...)
 	Migration Migration
-	db *gorm.DB
+	db        *gorm.DB
 }
 
+// Migration prepares the schema of the database, see Migrations
 type Migration interface {
 	Execute(db *gorm.DB)
 }
@@ -27,13 +34,17 @@ func (service *Service) Name() string {
 }
 
 func (service *Service) Initialize() {
-	db, err := gorm.Open("postgres", service.Connection)
-	db.LogMode(service.Debug)
+	dialect := service.Dialect
+	if dialect == "" {
+		dialect = "postgres"
+	}
 
+	db, err := gorm.Open(dialect, service.Connection)
 	if err != nil {
-		db.Close()
 		panic(err)
 	}
+	db.LogMode(service.Debug)
+
 	service.db = db
 	if service.Migration != nil {
 		service.Migration.Execute(service.db)
@@ -61,6 +72,11 @@ func FromContext(ctx context.Context) *gorm.DB {
 	return nil
 }
 
+// NewContext returns a context carrying the connection, to run the sql managers outside of the service
+func NewContext(ctx context.Context, db *gorm.DB) context.Context {
+	return context.WithValue(ctx, sqlKey, db)
+}
+
 func ToColumnName(name string) string {
 	return gorm.ToColumnName(name)
 }
@@ -0,0 +1,79 @@
+package sql
+
+import (
+	"context"
+	"errors"
+)
+
+const txKey key = "__sql_transaction"
+
+var (
+	ErrTransactionInProgress = errors.New("a transaction is already in progress")
+	ErrNoTransaction         = errors.New("no transaction in progress")
+)
+
+// Begin starts a database transaction, returning the context carrying it.
+// Managers and extenders called with the returned context run their queries in the transaction,
+// which must be ended with Commit or Rollback
+func Begin(ctx context.Context) (context.Context, error) {
+	if InTransaction(ctx) {
+		return nil, ErrTransactionInProgress
+	}
+
+	tx := FromContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	ctx = context.WithValue(ctx, sqlKey, tx)
+	return context.WithValue(ctx, txKey, tx), nil
+}
+
+// Commit commits the transaction started by Begin
+func Commit(ctx context.Context) error {
+	if !InTransaction(ctx) {
+		return ErrNoTransaction
+	}
+	return FromContext(ctx).Commit().Error
+}
+
+// Rollback rolls back the transaction started by Begin
+func Rollback(ctx context.Context) error {
+	if !InTransaction(ctx) {
+		return ErrNoTransaction
+	}
+	return FromContext(ctx).Rollback().Error
+}
+
+// InTransaction reports if the context carries a transaction
+func InTransaction(ctx context.Context) bool {
+	return ctx.Value(txKey) != nil
+}
+
+// RunInTransaction runs fn in a database transaction.
+// The context passed to fn carries the transaction, so that FromContext returns it.
+// The transaction is committed if fn returns nil and rolled back otherwise.
+// If the context already carries a transaction fn joins it, so that operations can be composed
+func RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if InTransaction(ctx) {
+		return fn(ctx)
+	}
+
+	tx, err := Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			Rollback(tx)
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		Rollback(tx)
+		return err
+	}
+	return Commit(tx)
+}
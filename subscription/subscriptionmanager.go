@@ -30,76 +30,83 @@ func NewSubscriptionController() SubscriptionController {
 }
 
 func NewSubscriptionControllerWithKey(key string) SubscriptionController {
-	man := subscriptionManager{}
+	return NewSubscriptionControllerWithRepository(key, nil)
+}
+
+// NewSubscriptionControllerWithRepository returns the controller of the subscriptions stored in the repository.
+// A nil repository stores them in the datastore
+func NewSubscriptionControllerWithRepository(key string, repository spellbook.Repository) SubscriptionController {
+	man := subscriptionManager{repository: repository}
 	handler := spellbook.BaseRestHandler{Manager: man}
 	c := spellbook.NewRestController(handler)
 	c.Key = key
 	return SubscriptionController{c}
 }
 
-type subscriptionManager struct{}
+type subscriptionManager struct {
+	repository spellbook.Repository
+}
+
+func (manager subscriptionManager) subscriptions() spellbook.Repository {
+	if manager.repository == nil {
+		return subscriptionRepository{}
+	}
+	return manager.repository
+}
+
+// fields the subscriptions can be filtered by
+var subscriptionFilterFields = spellbook.FilterFields{
+	"Email":        spellbook.FieldString,
+	"Country":      spellbook.FieldString,
+	"Organization": spellbook.FieldString,
+	"Created":      spellbook.FieldTime,
+	"Updated":      spellbook.FieldTime,
+}
+
+// Describe describes the subscriptions for the OpenAPI document
+func (manager subscriptionManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "Subscription",
+		Filterable:       subscriptionFilterFields,
+		Orderable:        []string{"Email", "Created", "Updated"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadSubscription},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteSubscription},
+	}
+}
 
 func (manager subscriptionManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
 	return &Subscription{}, nil
 }
 
 func (manager subscriptionManager) FromId(ctx context.Context, strId string) (spellbook.Resource, error) {
-	current := spellbook.IdentityFromContext(ctx)
-	if !current.HasPermission(spellbook.PermissionReadSubscription) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadSubscription) {
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadSubscription))
 	}
 
-	sub := Subscription{}
-	if err := model.FromEncodedKey(ctx, &sub, strId); err != nil {
+	sub, err := manager.subscriptions().FromId(ctx, strId)
+	if err != nil {
 		log.Errorf(ctx, "could not retrieve subscription %s: %s", strId, err.Error())
 		return nil, err
 	}
 
-	return &sub, nil
+	return sub, nil
 }
 
 func (manager subscriptionManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
-	current := spellbook.IdentityFromContext(ctx)
-	if !current.HasPermission(spellbook.PermissionReadSubscription) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadSubscription) {
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadSubscription))
 	}
 
-	var subscriptions []*Subscription
-	q := model.NewQuery(&Subscription{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
-	}
-
+	query := spellbook.QueryFromOptions(opts)
+	query.Offset = opts.Page * opts.Size
 	// get one more so we know if we are done
-	q = q.Limit(opts.Size + 1)
-	err := q.GetMulti(ctx, &subscriptions)
-	if err != nil {
-		return nil, err
-	}
-
-	resources := make([]spellbook.Resource, len(subscriptions))
-	for i := range subscriptions {
-		resources[i] = subscriptions[i]
-	}
+	query.Limit = opts.Size + 1
 
-	return resources, nil
+	return manager.subscriptions().ListOf(ctx, query)
 }
 
 func (manager subscriptionManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
-	current := spellbook.IdentityFromContext(ctx)
-	if !current.HasPermission(spellbook.PermissionReadSubscription) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadSubscription) {
 		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadSubscription))
 	}
 
@@ -113,41 +120,29 @@ func (manager subscriptionManager) ListOfProperties(ctx context.Context, opts sp
 		return nil, errors.New("no property found")
 	}
 
-	var conts []*Subscription
-	q := model.NewQuery(&Subscription{})
-	q = q.OffsetBy(opts.Page * opts.Size)
-
-	if opts.Order != "" {
-		dir := model.ASC
-		if opts.Descending {
-			dir = model.DESC
-		}
-		q = q.OrderBy(opts.Order, dir)
-	}
-
-	for _, filter := range opts.Filters {
-		if filter.Field != "" {
-			q = q.WithField(filter.Field+" =", filter.Value)
-		}
+	repository, ok := manager.subscriptions().(spellbook.DistinctRepository)
+	if !ok {
+		return nil, spellbook.NewUnsupportedError()
 	}
 
-	q = q.Distinct(name)
-	q = q.Limit(opts.Size + 1)
-	err := q.GetAll(ctx, &conts)
+	query := spellbook.Query{Filters: opts.Filters, Offset: opts.Page * opts.Size, Limit: opts.Size + 1}
+	result, err := repository.Distinct(ctx, name, query)
 	if err != nil {
 		log.Errorf(ctx, "Error retrieving result: %+v", err)
 		return nil, err
 	}
-	var result []string
-	for _, c := range conts {
-		value := reflect.ValueOf(c).Elem().FieldByName(name).String()
-		if len(value) > 0 {
-			result = append(result, value)
-		}
-	}
 	return result, nil
 }
 
+// Count returns the number of subscriptions matching the filters of the options
+func (manager subscriptionManager) Count(ctx context.Context, opts spellbook.ListOptions) (int, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadSubscription) {
+		return 0, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadSubscription))
+	}
+
+	return manager.subscriptions().Count(ctx, spellbook.QueryFromOptions(opts))
+}
+
 func (manager subscriptionManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
 
 	current := spellbook.IdentityFromContext(ctx)
@@ -184,20 +179,19 @@ func (manager subscriptionManager) Create(ctx context.Context, res spellbook.Res
 	}
 
 	// list subscription
-	var subscriptions []*Subscription
-	q := model.NewQuery(&Subscription{})
-	q = q.WithField("Email =", subscription.Email)
-	err := q.GetMulti(ctx, &subscriptions)
+	count, err := manager.subscriptions().Count(ctx, spellbook.Query{Filters: []spellbook.Filter{
+		{Field: "Email", Operator: spellbook.FilterEqual, Value: subscription.Email},
+	}})
 	if err != nil {
 		msg := fmt.Sprintf("Error retrieving list subscription %+v", err)
 		return spellbook.NewFieldError("Suscription", errors.New(msg))
 	}
-	if len(subscriptions) > 0 {
+	if count > 0 {
 		msg := fmt.Sprintf("Email already exist")
 		return spellbook.NewFieldError("Email", errors.New(msg))
 	}
 
-	err = model.Create(ctx, subscription)
+	err = manager.subscriptions().Create(ctx, subscription)
 	if err != nil {
 		log.Errorf(ctx, "error creating subscription %s: %s", subscription.Name, err)
 		return err
@@ -256,7 +250,7 @@ func (manager subscriptionManager) Update(ctx context.Context, res spellbook.Res
 	subscription.Notes = other.Notes
 	subscription.Updated = time.Now().UTC()
 
-	return model.Update(ctx, subscription)
+	return manager.subscriptions().Update(ctx, subscription)
 }
 
 func (manager subscriptionManager) Delete(ctx context.Context, res spellbook.Resource) error {
@@ -265,7 +259,7 @@ func (manager subscriptionManager) Delete(ctx context.Context, res spellbook.Res
 	}
 
 	subscription := res.(*Subscription)
-	err := model.Delete(ctx, subscription, nil)
+	err := manager.subscriptions().Delete(ctx, subscription)
 	if err != nil {
 		log.Errorf(ctx, "error deleting subscription %s: %s", subscription.Name, err.Error())
 		return err
@@ -273,3 +267,75 @@ func (manager subscriptionManager) Delete(ctx context.Context, res spellbook.Res
 
 	return nil
 }
+
+// datastore storage of the subscriptions, the default of the subscriptionManager
+type subscriptionRepository struct{}
+
+func (repository subscriptionRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	sub := Subscription{}
+	if err := model.FromEncodedKey(ctx, &sub, id); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (repository subscriptionRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Subscription{}), query, subscriptionFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []*Subscription
+	if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &subscriptions); err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(subscriptions))
+	for i := range subscriptions {
+		resources[i] = subscriptions[i]
+	}
+	return resources, nil
+}
+
+func (repository subscriptionRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Subscription{}), query, subscriptionFilterFields)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(spellbook.DatastoreQueryContext(ctx))
+}
+
+func (repository subscriptionRepository) Distinct(ctx context.Context, field string, query spellbook.Query) ([]string, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Subscription{}), query, subscriptionFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []*Subscription
+	if err := q.Distinct(field).GetAll(spellbook.DatastoreQueryContext(ctx), &subscriptions); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, s := range subscriptions {
+		value := reflect.ValueOf(s).Elem().FieldByName(field).String()
+		if len(value) > 0 {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+func (repository subscriptionRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	return model.Create(ctx, res.(*Subscription))
+}
+
+func (repository subscriptionRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return model.Update(ctx, res.(*Subscription))
+}
+
+func (repository subscriptionRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*Subscription), nil)
+}
@@ -0,0 +1,83 @@
+// Package trash keeps the deleted resources until they are restored or purged
+package trash
+
+import (
+	"context"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Item is a deleted resource, kept in the trash until it is restored or purged.
+// The resource itself stays in its repository, hidden, with ResourceId as id, see spellbook.TrashRepository:
+// Data is its JSON representation when it was deleted, for listing the trash
+type Item struct {
+	model.Model `json:"-"`
+	ID          uint `model:"-" json:"-"`
+	Type        string
+	ResourceId  string
+	Label       string
+	Data        string `model:"noindex" gorm:"type:text"`
+	Deleted     time.Time
+	DeletedBy   string
+}
+
+func (item Item) TableName() string {
+	return "trash_items"
+}
+
+// NewItem returns the trash item of the resource, deleted by the identity of the context
+func NewItem(ctx context.Context, typ string, label string, res spellbook.Resource) (*Item, error) {
+	data, err := res.ToRepresentation(spellbook.RepresentationTypeJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	item := Item{Type: typ, ResourceId: res.Id(), Label: label, Data: string(data), Deleted: time.Now().UTC()}
+	if user, ok := spellbook.IdentityFromContext(ctx).(interface{ Username() string }); ok {
+		item.DeletedBy = user.Username()
+	}
+	return &item, nil
+}
+
+func (item *Item) Id() string {
+	if id := item.EncodedKey(); id != "" {
+		return id
+	}
+	return fmt.Sprintf("%d", item.ID)
+}
+
+func (item *Item) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Id         string          `json:"id"`
+		Type       string          `json:"type"`
+		ResourceId string          `json:"resourceId"`
+		Label      string          `json:"label"`
+		Data       json.RawMessage `json:"data"`
+		Deleted    time.Time       `json:"deleted"`
+		DeletedBy  string          `json:"deletedBy"`
+	}{
+		Id:         item.Id(),
+		Type:       item.Type,
+		ResourceId: item.ResourceId,
+		Label:      item.Label,
+		Data:       json.RawMessage(item.Data),
+		Deleted:    item.Deleted,
+		DeletedBy:  item.DeletedBy,
+	})
+}
+
+func (item *Item) ToRepresentation(rtype spellbook.RepresentationType) ([]byte, error) {
+	switch rtype {
+	case spellbook.RepresentationTypeJSON:
+		return json.Marshal(item)
+	}
+	return nil, spellbook.NewUnsupportedError()
+}
+
+// items are only created by the managers deleting the resources
+func (item *Item) FromRepresentation(rtype spellbook.RepresentationType, data []byte) error {
+	return spellbook.NewUnsupportedError()
+}
@@ -0,0 +1,93 @@
+package trash
+
+import (
+	"context"
+	"decodica.com/flamel/model"
+	"decodica.com/spellbook"
+	"decodica.com/spellbook/sql"
+)
+
+// fields the trash items can be filtered by
+var itemFilterFields = spellbook.FilterFields{
+	"Type":       spellbook.FieldString,
+	"ResourceId": spellbook.FieldString,
+	"Label":      spellbook.FieldString,
+	"Deleted":    spellbook.FieldTime,
+	"DeletedBy":  spellbook.FieldString,
+}
+
+// SqlRepository stores the trash items in the sql database, see Migrations
+var SqlRepository = sql.NewRepository(&Item{}, itemFilterFields, nil)
+
+// Migrations create the sql schema of the trash
+var Migrations = sql.Migrations{
+	{
+		Version: 2026101805,
+		Name:    "create trash items",
+		Up: sql.CreateTable("trash_items",
+			sql.Column{Name: "id", Type: sql.TypeSerial},
+			sql.Column{Name: "type", Type: sql.TypeString, NotNull: true},
+			sql.Column{Name: "resource_id", Type: sql.TypeString},
+			sql.Column{Name: "label", Type: sql.TypeText},
+			sql.Column{Name: "data", Type: sql.TypeText},
+			sql.Column{Name: "deleted", Type: sql.TypeTime},
+			sql.Column{Name: "deleted_by", Type: sql.TypeString},
+		),
+		Down: sql.DropTable("trash_items"),
+	},
+}
+
+// DatastoreRepository stores the trash items in the datastore
+type DatastoreRepository struct{}
+
+func (repository DatastoreRepository) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	item := Item{}
+	if err := model.FromEncodedKey(ctx, &item, id); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (repository DatastoreRepository) ListOf(ctx context.Context, query spellbook.Query) ([]spellbook.Resource, error) {
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Item{}), query, itemFilterFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*Item
+	if err := q.GetMulti(spellbook.DatastoreQueryContext(ctx), &items); err != nil {
+		return nil, err
+	}
+
+	resources := make([]spellbook.Resource, len(items))
+	for i := range items {
+		resources[i] = items[i]
+	}
+	return resources, nil
+}
+
+func (repository DatastoreRepository) Count(ctx context.Context, query spellbook.Query) (int, error) {
+	query.Order = ""
+	q, err := spellbook.DatastoreQuery(model.NewQuery(&Item{}), query, itemFilterFields)
+	if err != nil {
+		return 0, err
+	}
+	return q.Count(spellbook.DatastoreQueryContext(ctx))
+}
+
+func (repository DatastoreRepository) Create(ctx context.Context, res spellbook.Resource) error {
+	return model.Create(ctx, res.(*Item))
+}
+
+func (repository DatastoreRepository) Update(ctx context.Context, res spellbook.Resource) error {
+	return model.Update(ctx, res.(*Item))
+}
+
+func (repository DatastoreRepository) Delete(ctx context.Context, res spellbook.Resource) error {
+	return model.Delete(ctx, res.(*Item), nil)
+}
+
+// RunInTransaction runs fn in a datastore transaction, see spellbook.RunInDatastoreTransaction
+func (repository DatastoreRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return spellbook.RunInDatastoreTransaction(ctx, fn)
+}
@@ -0,0 +1,254 @@
+package trash
+
+import (
+	"context"
+	"decodica.com/flamel"
+	"decodica.com/spellbook"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"time"
+)
+
+// Restorer is implemented by the managers that move the deleted resources to the trash.
+// Their resources are kept in place, with their id, see spellbook.TrashRepository:
+// Restore brings back the resource of the item and Purge deletes it for good.
+// Permissions are checked by the TrashManager
+type Restorer interface {
+	Restore(ctx context.Context, item *Item) error
+	Purge(ctx context.Context, item *Item) error
+}
+
+// NewTrashController returns the controller of the trash stored in the datastore.
+// Restorers are the managers restoring the items, by item type
+func NewTrashController(restorers map[string]Restorer) *spellbook.RestController {
+	return NewTrashControllerWithKey("", restorers)
+}
+
+func NewTrashControllerWithKey(key string, restorers map[string]Restorer) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: TrashManager{Restorers: restorers}}
+	c := spellbook.NewRestController(handler)
+	c.Key = key
+	return c
+}
+
+// NewSqlTrashController returns the controller of the trash stored in the sql database
+func NewSqlTrashController(restorers map[string]Restorer) *spellbook.RestController {
+	handler := spellbook.BaseRestHandler{Manager: TrashManager{Repository: SqlRepository, Restorers: restorers}}
+	return spellbook.NewRestController(handler)
+}
+
+// TrashManager lists the deleted resources.
+// Items are restored by updating them with {"restore": true} and purged, with their resource, by deleting them.
+// The zero value reads the datastore
+type TrashManager struct {
+	Repository spellbook.Repository
+	Restorers  map[string]Restorer
+}
+
+func (manager TrashManager) items() spellbook.Repository {
+	if manager.Repository == nil {
+		return DatastoreRepository{}
+	}
+	return manager.Repository
+}
+
+// Describe describes the trash for the OpenAPI document
+func (manager TrashManager) Describe() spellbook.ResourceDescription {
+	return spellbook.ResourceDescription{
+		Name:             "TrashItem",
+		Filterable:       itemFilterFields,
+		Orderable:        []string{"Deleted", "Type"},
+		ReadPermissions:  []spellbook.Permission{spellbook.PermissionReadTrash},
+		WritePermissions: []spellbook.Permission{spellbook.PermissionWriteTrash},
+	}
+}
+
+func (manager TrashManager) NewResource(ctx context.Context) (spellbook.Resource, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager TrashManager) FromId(ctx context.Context, id string) (spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadTrash) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadTrash))
+	}
+
+	item, err := manager.items().FromId(ctx, id)
+	if err != nil {
+		log.Errorf(ctx, "could not retrieve trash item %s: %s", id, err.Error())
+		return nil, err
+	}
+	return item, nil
+}
+
+func (manager TrashManager) ListOf(ctx context.Context, opts spellbook.ListOptions) ([]spellbook.Resource, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadTrash) {
+		return nil, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadTrash))
+	}
+
+	query := spellbook.QueryFromOptions(opts)
+	// the last deleted come first
+	if query.Order == "" {
+		query.Order = "Deleted"
+		query.Descending = true
+	}
+	query.Offset = opts.Page * opts.Size
+	// get one more so we know if we are done
+	query.Limit = opts.Size + 1
+
+	return manager.items().ListOf(ctx, query)
+}
+
+// Count returns the number of items matching the filters of the options
+func (manager TrashManager) Count(ctx context.Context, opts spellbook.ListOptions) (int, error) {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionReadTrash) {
+		return 0, spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionReadTrash))
+	}
+	return manager.items().Count(ctx, spellbook.Query{Filters: opts.Filters})
+}
+
+func (manager TrashManager) ListOfProperties(ctx context.Context, opts spellbook.ListOptions) ([]string, error) {
+	return nil, spellbook.NewUnsupportedError()
+}
+
+func (manager TrashManager) Create(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	return spellbook.NewUnsupportedError()
+}
+
+// Update restores the item if the bundle is {"restore": true}.
+// The item is removed from the trash once its resource has been restored
+func (manager TrashManager) Update(ctx context.Context, res spellbook.Resource, bundle []byte) error {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionWriteTrash) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteTrash))
+	}
+
+	action := struct {
+		Restore bool `json:"restore"`
+	}{}
+	if err := json.Unmarshal(bundle, &action); err != nil {
+		return spellbook.NewFieldError("", fmt.Errorf("bad json %s", string(bundle)))
+	}
+	if !action.Restore {
+		return spellbook.NewFieldError("restore", errors.New("trash items can only be restored"))
+	}
+
+	item := res.(*Item)
+	restorer, ok := manager.Restorers[item.Type]
+	if !ok {
+		return spellbook.NewFieldError("type", fmt.Errorf("items of type %q can't be restored", item.Type))
+	}
+
+	if err := restorer.Restore(ctx, item); err != nil {
+		log.Errorf(ctx, "error restoring %s %s: %s", item.Type, item.ResourceId, err.Error())
+		return err
+	}
+
+	if err := manager.items().Delete(ctx, item); err != nil {
+		log.Errorf(ctx, "error removing restored item %s from the trash: %s", item.Id(), err.Error())
+		return err
+	}
+	return nil
+}
+
+// Delete purges the item and its resource
+func (manager TrashManager) Delete(ctx context.Context, res spellbook.Resource) error {
+	if current := spellbook.IdentityFromContext(ctx); current == nil || !current.HasPermission(spellbook.PermissionWriteTrash) {
+		return spellbook.NewPermissionError(spellbook.PermissionName(spellbook.PermissionWriteTrash))
+	}
+	return purge(ctx, manager.items(), manager.Restorers, res.(*Item))
+}
+
+// deletes for good the resource of the item, through the restorer of its type, and then the item.
+// Items of types without a restorer are only removed from the trash
+func purge(ctx context.Context, items spellbook.Repository, restorers map[string]Restorer, item *Item) error {
+	if restorer, ok := restorers[item.Type]; ok {
+		if err := restorer.Purge(ctx, item); err != nil {
+			log.Errorf(ctx, "error purging %s %s: %s", item.Type, item.ResourceId, err.Error())
+			return err
+		}
+	}
+
+	if err := items.Delete(ctx, item); err != nil {
+		log.Errorf(ctx, "error purging trash item %s: %s", item.Id(), err.Error())
+		return err
+	}
+	return nil
+}
+
+// number of items purged at once by the sweep
+const sweepBatchSize = 100
+
+// Sweep purges the items deleted before the retention period, with their resources, returning how many have been purged
+func Sweep(ctx context.Context, repository spellbook.Repository, restorers map[string]Restorer, retention time.Duration) (int, error) {
+	before := time.Now().UTC().Add(-retention).Format(time.RFC3339)
+	query := spellbook.Query{
+		Filters: []spellbook.Filter{{Field: "Deleted", Operator: spellbook.FilterLess, Value: before}},
+		Limit:   sweepBatchSize,
+	}
+
+	purged := 0
+	for {
+		items, err := repository.ListOf(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+
+		for _, item := range items {
+			if err := purge(ctx, repository, restorers, item.(*Item)); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+
+		if len(items) < sweepBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// SweepController purges the items older than the TrashRetention of the application options.
+// It is meant to be run by a cron job authenticated by the CronSecret, see spellbook.IsCron,
+// but users that can write the trash can run it too
+type SweepController struct {
+	Repository spellbook.Repository
+	Restorers  map[string]Restorer
+}
+
+// NewSweepController returns the sweep of the trash stored in the datastore.
+// Restorers purge the resources of the items, by item type
+func NewSweepController(restorers map[string]Restorer) *SweepController {
+	return &SweepController{Repository: DatastoreRepository{}, Restorers: restorers}
+}
+
+// NewSqlSweepController returns the sweep of the trash stored in the sql database
+func NewSqlSweepController(restorers map[string]Restorer) *SweepController {
+	return &SweepController{Repository: SqlRepository, Restorers: restorers}
+}
+
+func (controller *SweepController) Process(ctx context.Context, out *flamel.ResponseOutput) flamel.HttpResponse {
+	if current := spellbook.IdentityFromContext(ctx); !spellbook.IsCron(ctx) && (current == nil || !current.HasPermission(spellbook.PermissionWriteTrash)) {
+		return flamel.HttpResponse{Status: http.StatusForbidden}
+	}
+
+	retention := spellbook.Application().Options().TrashRetention
+	if retention == 0 {
+		return flamel.HttpResponse{Status: http.StatusNoContent}
+	}
+
+	purged, err := Sweep(ctx, controller.Repository, controller.Restorers, retention)
+	if err != nil {
+		log.Errorf(ctx, "error sweeping the trash, %d items purged: %s", purged, err.Error())
+		return flamel.HttpResponse{Status: http.StatusInternalServerError}
+	}
+
+	renderer := flamel.JSONRenderer{}
+	renderer.Data = struct {
+		Purged int `json:"purged"`
+	}{purged}
+	out.Renderer = &renderer
+	return flamel.HttpResponse{Status: http.StatusOK}
+}
+
+func (controller *SweepController) OnDestroy(ctx context.Context) {}
@@ -21,6 +21,11 @@ type Field struct {
 }
 
 func NewField(name string, required bool, in flamel.RequestInputs) *Field {
+	return NewInputField(name, required, fromFlamel(in))
+}
+
+// NewInputField returns the field of the inputs, as read with InputsFromContext
+func NewInputField(name string, required bool, in Inputs) *Field {
 	vs := make([]Validator, 0, 0)
 	f := &Field{Name: name, Required: required, validators: vs}
 	if val, ok := in[f.Name]; ok {